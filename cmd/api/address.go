@@ -0,0 +1,195 @@
+package main
+
+import (
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// writeAddressError writes the response of an error returned by a change of an address.
+func (app *application) writeAddressError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch err {
+	case data.ErrRecordNotFound:
+		app.notFoundResponse(writer, request)
+	case data.ErrDuplicateAddress:
+		app.failedValidationResponse(writer, request, map[string]string{"type": "the company already has a registered address"})
+	default:
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// ListCompanyAddressesHandler returns the addresses of the company identified by the
+// ID provided in the request URL.
+func (app *application) ListCompanyAddressesHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	addresses, err := app.company.GetCompanyAddresses(request.Context(), id)
+	if err != nil {
+		app.writeAddressError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"addresses": addresses}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// GetCompanyAddressHandler returns an address of the company identified by the IDs
+// provided in the request URL.
+func (app *application) GetCompanyAddressHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	addressID, err := app.readAddressIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	address, err := app.company.GetCompanyAddress(request.Context(), id, addressID)
+	if err != nil {
+		app.writeAddressError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"address": address}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// CreateCompanyAddressHandler adds the address in the POSTed JSON document to the
+// company identified by the ID provided in the request URL. Country codes are
+// accepted in any case.
+func (app *application) CreateCompanyAddressHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	var input struct {
+		Type       string   `json:"type"`
+		Lines      []string `json:"lines"`
+		City       string   `json:"city"`
+		PostalCode string   `json:"postal_code"`
+		Country    string   `json:"country"`
+	}
+	err = app.readJSON(request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	address := &data.Address{
+		CompanyID:  id,
+		Type:       input.Type,
+		Lines:      input.Lines,
+		City:       input.City,
+		PostalCode: input.PostalCode,
+		Country:    strings.ToUpper(input.Country),
+	}
+
+	v := validator.New()
+	if data.ValidateAddress(v, address); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	err = app.company.CreateCompanyAddress(request.Context(), address, app.contextGetActor(request))
+	if err != nil {
+		app.writeAddressError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"address": address}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// UpdateCompanyAddressHandler updates an address of the company identified by the IDs
+// provided in the request URL with the fields of the PATCHed JSON document.
+func (app *application) UpdateCompanyAddressHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	addressID, err := app.readAddressIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	address, err := app.company.GetCompanyAddress(request.Context(), id, addressID)
+	if err != nil {
+		app.writeAddressError(writer, request, err)
+		return
+	}
+	var input struct {
+		Type       *string  `json:"type"`
+		Lines      []string `json:"lines"`
+		City       *string  `json:"city"`
+		PostalCode *string  `json:"postal_code"`
+		Country    *string  `json:"country"`
+	}
+	err = app.readJSON(request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	if input.Type != nil {
+		address.Type = *input.Type
+	}
+	if input.Lines != nil {
+		address.Lines = input.Lines
+	}
+	if input.City != nil {
+		address.City = *input.City
+	}
+	if input.PostalCode != nil {
+		address.PostalCode = *input.PostalCode
+	}
+	if input.Country != nil {
+		address.Country = strings.ToUpper(*input.Country)
+	}
+
+	v := validator.New()
+	if data.ValidateAddress(v, address); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	err = app.company.UpdateCompanyAddress(request.Context(), address, app.contextGetActor(request))
+	if err != nil {
+		app.writeAddressError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"address": address}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// DeleteCompanyAddressHandler removes an address of the company identified by the IDs
+// provided in the request URL.
+func (app *application) DeleteCompanyAddressHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	addressID, err := app.readAddressIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	err = app.company.DeleteCompanyAddress(request.Context(), id, addressID, app.contextGetActor(request))
+	if err != nil {
+		app.writeAddressError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"id": addressID}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCompanyAddresses tests the company address handlers.
+func TestCompanyAddresses(t *testing.T) {
+	const (
+		companyPath = "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c"
+		addressPath = companyPath + "/addresses/5a0c2f7e-1d3b-4c8a-9e6f-2b7d4a1c8e30"
+	)
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		body     string
+		wantCode int
+		wantBody []byte
+	}{
+		{"List", http.MethodGet, companyPath + "/addresses", "", http.StatusOK, []byte(`"city":"Amsterdam"`)},
+		{"List of unknown company", http.MethodGet, "/v1/company/5f001b5d-8cd1-4f90-8a6a-5164adee43b5/addresses", "", http.StatusNotFound, nil},
+		{"List of deleted company", http.MethodGet, "/v1/company/3b1f6a52-8f0e-4a57-9d0c-6b2f7c1e4a90/addresses", "", http.StatusNotFound, nil},
+		{"Get", http.MethodGet, addressPath, "", http.StatusOK, []byte(`"type":"registered"`)},
+		{"Get unknown address", http.MethodGet, companyPath + "/addresses/5f001b5d-8cd1-4f90-8a6a-5164adee43b5", "", http.StatusNotFound, nil},
+		{"Get invalid address", http.MethodGet, companyPath + "/addresses/1", "", http.StatusBadRequest, nil},
+		{"Create", http.MethodPost, companyPath + "/addresses",
+			`{"type":"operating","lines":["2 Test Street"],"city":"Rotterdam","postal_code":"3011 AA","country":"nl"}`,
+			http.StatusCreated, []byte(`"country":"NL"`)},
+		{"Create invalid", http.MethodPost, companyPath + "/addresses",
+			`{"type":"home","lines":[],"city":"","country":"XX"}`,
+			http.StatusUnprocessableEntity, []byte(`"country":"must be an ISO 3166-1 alpha-2 country code"`)},
+		{"Create second registered address", http.MethodPost, companyPath + "/addresses",
+			`{"type":"registered","lines":["2 Test Street"],"city":"Rotterdam","country":"NL"}`,
+			http.StatusUnprocessableEntity, []byte("already has a registered address")},
+		{"Update", http.MethodPatch, addressPath, `{"city":"Utrecht"}`, http.StatusOK, []byte(`"city":"Utrecht"`)},
+		{"Update invalid", http.MethodPatch, addressPath, `{"lines":["", "1 Test Street"]}`, http.StatusUnprocessableEntity, []byte("lines")},
+		{"Delete", http.MethodDelete, addressPath, "", http.StatusOK, nil},
+		{"Delete unknown address", http.MethodDelete, companyPath + "/addresses/5f001b5d-8cd1-4f90-8a6a-5164adee43b5", "", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := httptest.NewServer(app.routes())
+			defer ts.Close()
+
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"net/http"
+)
+
+// validateCompany runs the validation checks of data.ValidateCompany, along with the
+// ones of the attributes schema of the deployment, if any. The errors of the
+// attributes are reported under their JSON pointer, such as /attributes/crm_id.
+func (app *application) validateCompany(v *validator.Validator, company *data.Company) {
+	data.ValidateCompany(v, company)
+	if app.attributesSchema == nil {
+		return
+	}
+	attributes := map[string]interface{}(company.Attributes)
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	app.attributesSchema.Validate(v, "/attributes", attributes)
+}
+
+// ListCompaniesByAttributeHandler returns a page of the companies whose attribute
+// named by the key parameter is equal to the value parameter, sorted and paginated
+// like by ListCompaniesHandler. The value matches a string attribute, and a number
+// or boolean attribute if it spells one.
+func (app *application) ListCompaniesByAttributeHandler(writer http.ResponseWriter, request *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := request.URL.Query()
+
+	key := qs.Get("key")
+	value := qs.Get("value")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "name")
+	filters.SortSafelist = data.CompanySortSafelist
+
+	data.ValidateAttributeLookup(v, key, qs.Has("value"))
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	companies, metadata, err := app.company.GetCompaniesByAttribute(request.Context(), key, value, filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"companies": companies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
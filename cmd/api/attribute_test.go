@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mborgnolo/companyservice/internal/jsonschema"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCompanyAttributes tests the validation of the attributes against the schema of
+// the deployment and the attribute lookup. The cases share the same application and
+// run in order.
+func TestCompanyAttributes(t *testing.T) {
+	app := newTestApplication(t)
+	schema, err := jsonschema.Parse([]byte(`{
+		"type": "object",
+		"properties": {
+			"crm_id": {"type": "string", "pattern": "^CRM-[0-9]+$"},
+			"segment": {"enum": ["smb", "enterprise"]}
+		},
+		"required": ["crm_id"],
+		"additionalProperties": false
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.attributesSchema = schema
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	const company = `"name":"Attributed","employees":5,"registered":true,"type":"Corporations"`
+	tests := []struct {
+		name        string
+		method      string
+		urlPath     string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    []byte
+	}{
+		{"Create without required attribute", http.MethodPost, "/v1/company", "", `{` + company + `}`, http.StatusUnprocessableEntity,
+			[]byte(`"/attributes":"missing properties: 'crm_id'"`)},
+		{"Create with invalid attribute", http.MethodPost, "/v1/company", "", `{` + company + `,"attributes":{"crm_id":"1","owner":"me"}}`, http.StatusUnprocessableEntity,
+			[]byte(`"/attributes":"additionalProperties 'owner' not allowed"`)},
+		{"Create with attributes not an object", http.MethodPost, "/v1/company", "", `{` + company + `,"attributes":[1]}`, http.StatusBadRequest, nil},
+		{"Create", http.MethodPost, "/v1/company", "", `{` + company + `,"attributes":{"crm_id":"CRM-1","segment":"smb"}}`, http.StatusCreated, nil},
+		{"Lookup", http.MethodGet, "/v1/company/by-attribute?key=crm_id&value=CRM-1", "", "", http.StatusOK,
+			[]byte(`"attributes":{"crm_id":"CRM-1","segment":"smb"}`)},
+		{"Lookup no match", http.MethodGet, "/v1/company/by-attribute?key=segment&value=enterprise", "", "", http.StatusOK, []byte(`"companies":[]`)},
+		{"Lookup without key", http.MethodGet, "/v1/company/by-attribute?value=CRM-1", "", "", http.StatusUnprocessableEntity, []byte(`"key":"is required"`)},
+		{"Lookup without value", http.MethodGet, "/v1/company/by-attribute?key=crm_id", "", "", http.StatusUnprocessableEntity, []byte(`"value":"is required"`)},
+		{"Patch with invalid attribute", http.MethodPatch, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", "", `{"attributes":{"crm_id":"CRM-2","segment":"big"}}`,
+			http.StatusUnprocessableEntity, []byte(`"/attributes/segment":"value must be one of \"smb\", \"enterprise\""`)},
+		{"Patch without attributes", http.MethodPatch, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", "", `{"employees":3}`,
+			http.StatusUnprocessableEntity, []byte(`"/attributes":"missing properties: 'crm_id'"`)},
+		{"Patch", http.MethodPatch, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", "", `{"attributes":{"crm_id":"CRM-2"}}`,
+			http.StatusOK, []byte(`"attributes":{"crm_id":"CRM-2"}`)},
+		{"Import with invalid attribute", http.MethodPost, "/v1/company/import", mediaTypeNDJSON,
+			`{"name":"Imported","employees":5,"registered":true,"type":"Corporations","attributes":{"crm_id":"x"}}`,
+			http.StatusUnprocessableEntity, []byte(`"/attributes/crm_id":"does not match pattern '^CRM-[0-9]+$'"`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/cache"
+	"mborgnolo/companyservice/internal/changes"
+	"mborgnolo/companyservice/internal/data"
+)
+
+// cachedCompanyRepository is a CompanyRepository serving GetCompany from a cache.
+// The cached company is invalidated when a change of the company succeeds through
+// the repository. Changes made by other instances of the service are seen once they
+// are notified to invalidateOnChange, or else once the cached company has expired.
+type cachedCompanyRepository struct {
+	CompanyRepository
+	cache *cache.LRU
+}
+
+// newCachedCompanyRepository returns the repository caching the companies of
+// repository in c.
+func newCachedCompanyRepository(repository CompanyRepository, c *cache.LRU) *cachedCompanyRepository {
+	return &cachedCompanyRepository{CompanyRepository: repository, cache: c}
+}
+
+// GetCompany returns the cached company, or reads it from the repository.
+func (r *cachedCompanyRepository) GetCompany(ctx context.Context, id uuid.UUID) (*data.Company, error) {
+	return r.cache.Get(id, func() (*data.Company, error) {
+		return r.CompanyRepository.GetCompany(ctx, id)
+	})
+}
+
+// UpdateCompany updates the company and invalidates its cached state.
+func (r *cachedCompanyRepository) UpdateCompany(ctx context.Context, company *data.Company, actor string) error {
+	err := r.CompanyRepository.UpdateCompany(ctx, company, actor)
+	if err == nil {
+		r.cache.Invalidate(company.ID)
+	}
+	return err
+}
+
+// DeleteCompany deletes the company and invalidates its cached state.
+func (r *cachedCompanyRepository) DeleteCompany(ctx context.Context, id uuid.UUID, version int, actor string) error {
+	err := r.CompanyRepository.DeleteCompany(ctx, id, version, actor)
+	if err == nil {
+		r.cache.Invalidate(id)
+	}
+	return err
+}
+
+// RollbackCompany rolls the company back and invalidates its cached state.
+func (r *cachedCompanyRepository) RollbackCompany(ctx context.Context, id uuid.UUID, revision, version int, actor string) (*data.Company, error) {
+	company, err := r.CompanyRepository.RollbackCompany(ctx, id, revision, version, actor)
+	if err == nil {
+		r.cache.Invalidate(id)
+	}
+	return company, err
+}
+
+// AddCompanyTag tags the company and invalidates its cached state.
+func (r *cachedCompanyRepository) AddCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) (bool, error) {
+	added, err := r.CompanyRepository.AddCompanyTag(ctx, companyID, tag, actor)
+	if err == nil {
+		r.cache.Invalidate(companyID)
+	}
+	return added, err
+}
+
+// RemoveCompanyTag untags the company and invalidates its cached state.
+func (r *cachedCompanyRepository) RemoveCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) error {
+	err := r.CompanyRepository.RemoveCompanyTag(ctx, companyID, tag, actor)
+	if err == nil {
+		r.cache.Invalidate(companyID)
+	}
+	return err
+}
+
+// SetCompanyParent sets the parent of the company and invalidates its cached state.
+func (r *cachedCompanyRepository) SetCompanyParent(ctx context.Context, id, parentID uuid.UUID, actor string) error {
+	err := r.CompanyRepository.SetCompanyParent(ctx, id, parentID, actor)
+	if err == nil {
+		r.cache.Invalidate(id)
+	}
+	return err
+}
+
+// ClearCompanyParent clears the parent of the company and invalidates its cached state.
+func (r *cachedCompanyRepository) ClearCompanyParent(ctx context.Context, id uuid.UUID, actor string) error {
+	err := r.CompanyRepository.ClearCompanyParent(ctx, id, actor)
+	if err == nil {
+		r.cache.Invalidate(id)
+	}
+	return err
+}
+
+// invalidateOnChange returns the subscriber of the company changes invalidating the
+// changed companies in c, including the ones changed by other instances of the service.
+func invalidateOnChange(c *cache.LRU) func(changes.Change) {
+	return func(change changes.Change) {
+		if change.Operation == changes.OperationResync {
+			c.Purge()
+			return
+		}
+		c.Invalidate(change.CompanyID)
+	}
+}
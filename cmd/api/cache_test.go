@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/cache"
+	"mborgnolo/companyservice/internal/changes"
+	"mborgnolo/companyservice/internal/conformance"
+	"mborgnolo/companyservice/internal/mocks"
+	"testing"
+	"time"
+)
+
+// TestCachedCompanyRepository tests that the cached companies are invalidated by
+// their changes.
+func TestCachedCompanyRepository(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.MustParse("dc152cf7-cc4b-4555-8d4c-1878e5b9262c")
+	c := cache.New(10, time.Minute)
+	r := newCachedCompanyRepository(mocks.NewCompanyModel(), c)
+
+	if _, err := r.GetCompany(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	company, err := r.GetCompany(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	company.Employees = 20
+	if err := r.UpdateCompany(ctx, company, "test"); err != nil {
+		t.Fatal(err)
+	}
+	company, err = r.GetCompany(ctx, id)
+	if err != nil || company.Employees != 20 {
+		t.Fatalf("want the updated company; got %+v, %v", company, err)
+	}
+	if _, err := r.RollbackCompany(ctx, id, 1, company.Version, "test"); err != nil {
+		t.Fatal(err)
+	}
+	company, err = r.GetCompany(ctx, id)
+	if err != nil || company.Employees != 10 {
+		t.Fatalf("want the rolled back company; got %+v, %v", company, err)
+	}
+	if _, err := r.AddCompanyTag(ctx, id, "strategic", "test"); err != nil {
+		t.Fatal(err)
+	}
+	company, err = r.GetCompany(ctx, id)
+	if err != nil || len(company.Tags) != 1 {
+		t.Fatalf("want the tagged company; got %+v, %v", company, err)
+	}
+	if err := r.DeleteCompany(ctx, id, company.Version, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetCompany(ctx, id); err == nil {
+		t.Fatal("want the deleted company not to be found")
+	}
+	want := cache.Stats{Hits: 1, Misses: 5, Size: 0}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("want stats %+v; got %+v", want, stats)
+	}
+}
+
+// TestInvalidateOnChange tests that the changes notified by other instances of the
+// service invalidate the cached companies.
+func TestInvalidateOnChange(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.MustParse("dc152cf7-cc4b-4555-8d4c-1878e5b9262c")
+	c := cache.New(10, time.Minute)
+	r := newCachedCompanyRepository(mocks.NewCompanyModel(), c)
+	hub := changes.NewHub()
+	hub.Subscribe(invalidateOnChange(c))
+
+	r.GetCompany(ctx, id)
+	hub.Publish(changes.Change{CompanyID: uuid.New(), Operation: "updated"})
+	if size := c.Stats().Size; size != 1 {
+		t.Errorf("want the other changes to keep the company cached; got size %d", size)
+	}
+	hub.Publish(changes.Change{CompanyID: id, Operation: "updated"})
+	if size := c.Stats().Size; size != 0 {
+		t.Errorf("want the change to invalidate the company; got size %d", size)
+	}
+	r.GetCompany(ctx, id)
+	hub.Publish(changes.Change{Operation: changes.OperationResync})
+	if size := c.Stats().Size; size != 0 {
+		t.Errorf("want a resync to purge the cache; got size %d", size)
+	}
+}
+
+// TestCachedCompanyRepositoryConformance tests that caching does not change the
+// behaviour of the repository.
+func TestCachedCompanyRepositoryConformance(t *testing.T) {
+	conformance.RunCompanyRepositoryTests(t, func(t *testing.T) conformance.CompanyRepository {
+		return newCachedCompanyRepository(mocks.NewCompanyModel(), cache.New(100, time.Minute))
+	})
+}
@@ -0,0 +1,10 @@
+package main
+
+import "mborgnolo/companyservice/internal/changes"
+
+// listenChanges is a background goroutine that publishes the company changes received
+// by the listener to the subscribers of the application until done is closed.
+func (app *application) listenChanges(listener *changes.Listener, done <-chan struct{}) {
+	defer app.wg.Done()
+	listener.Run(done, app.changes)
+}
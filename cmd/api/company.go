@@ -1,15 +1,27 @@
 package main
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"mborgnolo/companyservice/internal/data"
 	"mborgnolo/companyservice/internal/validator"
 	"net/http"
+	"strings"
 	"time"
 )
 
-// GetCompanyHandler returns a single company based on the ID provided in the request URL.
-// If no matching company is found, this method returns a 404 Not Found response.
+// companyWithAddresses is a company along with its addresses, returned by
+// GetCompanyHandler with the include=addresses option.
+type companyWithAddresses struct {
+	*data.Company
+	Addresses []*data.Address `json:"addresses"`
+}
+
+// GetCompanyHandler returns a single company based on the ID provided in the request URL,
+// with its version as ETag. If the query string contains an RFC3339 as_of timestamp, the
+// state of the company at that time is returned instead. With include=addresses, the
+// company is returned along with its addresses. If no matching company is found (or the
+// company did not exist at that time), this method returns a 404 Not Found response.
 func (app *application) GetCompanyHandler(writer http.ResponseWriter, request *http.Request) {
 	id, err := app.readIDParam(request)
 	if err != nil {
@@ -17,7 +29,22 @@ func (app *application) GetCompanyHandler(writer http.ResponseWriter, request *h
 		app.badRequestResponse(writer, request, err)
 		return
 	}
-	company, err := app.company.GetCompany(id)
+	qs := request.URL.Query()
+	include := app.readCSV(qs, "include", []string{})
+	v := validator.New()
+	for _, value := range include {
+		v.Check(value == "addresses", "include", "must be a list of: addresses")
+	}
+	v.Check(len(include) == 0 || !qs.Has("as_of"), "include", "cannot be combined with as_of")
+	if !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	if qs.Has("as_of") {
+		app.getCompanyAsOf(writer, request, id, qs.Get("as_of"))
+		return
+	}
+	company, err := app.company.GetCompany(request.Context(), id)
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
@@ -27,62 +54,226 @@ func (app *application) GetCompanyHandler(writer http.ResponseWriter, request *h
 		}
 		return
 	}
-	err = app.writeJSON(writer, http.StatusOK, envelope{"company": company}, nil)
+	var response interface{} = company
+	if len(include) > 0 {
+		addresses, err := app.company.GetCompanyAddresses(request.Context(), id)
+		if err != nil {
+			app.writeAddressError(writer, request, err)
+			return
+		}
+		response = companyWithAddresses{Company: company, Addresses: addresses}
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag(company.Version))
+	err = app.writeJSON(writer, http.StatusOK, envelope{"company": response}, headers)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
 }
 
-// CreateCompanyHandler creates a new company record in the database based on the data
-// in the POSTed JSON document. If the request body contains invalid data, this method
-// returns an error response, along with a list of validation errors.
-func (app *application) CreateCompanyHandler(writer http.ResponseWriter, request *http.Request) {
-	var UUID uuid.UUID
-	var input struct {
-		Name        string                  `json:"name"`
-		Description data.CompanyDescription `json:"description"`
-		Employees   int                     `json:"employees"`
-		Registered  *bool                   `json:"registered"`
-		Type        string                  `json:"type"`
+// getCompanyAsOf writes the state of a company at the time given by the as_of parameter.
+// No ETag is returned, since the state may not be the current one.
+func (app *application) getCompanyAsOf(writer http.ResponseWriter, request *http.Request, id uuid.UUID, asOf string) {
+	t, err := time.Parse(time.RFC3339, asOf)
+	if err != nil {
+		app.failedValidationResponse(writer, request, map[string]string{"as_of": "must be an RFC3339 timestamp"})
+		return
 	}
-	err := app.readJSON(request, &input)
+	company, err := app.company.GetCompanyAsOf(request.Context(), id, t)
 	if err != nil {
-		app.badRequestResponse(writer, request, err)
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"company": company, "as_of": t}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// ListCompaniesHandler returns a page of companies matching the filters provided in
+// the query string. Invalid filter values result in a 422 Unprocessable Entity response.
+func (app *application) ListCompaniesHandler(writer http.ResponseWriter, request *http.Request) {
+	var query data.CompanyQuery
+	var filters data.Filters
+	v := validator.New()
+	qs := request.URL.Query()
+
+	query.Name = app.readString(qs, "name", "")
+	query.Type = app.readString(qs, "type", "")
+	query.Registered = app.readBool(qs, "registered", v)
+	query.MinEmployees = app.readInt(qs, "min_employees", 0, v)
+	query.MaxEmployees = app.readInt(qs, "max_employees", 0, v)
+	query.Tag = strings.ToLower(app.readString(qs, "tag", ""))
+	if qs.Has("cursor") {
+		app.listCompaniesByCursor(writer, request, query, v)
+		return
+	}
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "name")
+	filters.SortSafelist = data.CompanySortSafelist
+
+	data.ValidateCompanyQuery(v, query)
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	companies, metadata, err := app.company.GetAllCompanies(request.Context(), query, filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"companies": companies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// listCompaniesByCursor writes a keyset paginated page of companies. It is used by
+// ListCompaniesHandler when the query string contains a cursor parameter: an empty
+// cursor requests the first page, the next_cursor and prev_cursor values returned
+// in the metadata request the surrounding pages.
+func (app *application) listCompaniesByCursor(writer http.ResponseWriter, request *http.Request, query data.CompanyQuery, v *validator.Validator) {
+	var filters data.KeysetFilters
+	qs := request.URL.Query()
+
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "name")
+	filters.SortSafelist = data.CompanySortSafelist
+	v.Check(!qs.Has("page"), "page", "cannot be used together with cursor")
+	if s := qs.Get("cursor"); s != "" {
+		cursor, err := data.DecodeCursor(s, []byte(app.config.cursor.secret))
+		if err != nil {
+			v.AddError("cursor", "is invalid")
+		} else {
+			filters.Cursor = &cursor
+			// The sort value is carried by the cursor, so it can be omitted
+			// when following next_cursor and prev_cursor.
+			filters.Sort = app.readString(qs, "sort", cursor.Sort)
+		}
+	}
+
+	data.ValidateCompanyQuery(v, query)
+	if data.ValidateKeysetFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	companies, page, err := app.company.GetCompaniesByCursor(request.Context(), query, filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	metadata := envelope{"page_size": filters.PageSize, "next_cursor": nil, "prev_cursor": nil}
+	if page.Next != nil {
+		metadata["next_cursor"] = data.EncodeCursor(*page.Next, []byte(app.config.cursor.secret))
+	}
+	if page.Prev != nil {
+		metadata["prev_cursor"] = data.EncodeCursor(*page.Prev, []byte(app.config.cursor.secret))
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"companies": companies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// SearchCompaniesHandler returns a page of companies whose name or description match
+// the full-text search query provided in the q parameter, ranked by relevance and with
+// the matching words highlighted.
+func (app *application) SearchCompaniesHandler(writer http.ResponseWriter, request *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := request.URL.Query()
+
+	q := app.readString(qs, "q", "")
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-rank")
+	filters.SortSafelist = data.CompanySearchSortSafelist
+
+	data.ValidateSearchQuery(v, q)
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
 		return
 	}
-	company := &data.Company{
+	results, metadata, err := app.company.SearchCompanies(request.Context(), q, filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"results": results, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// companyInput is the JSON document describing a new company.
+type companyInput struct {
+	Name        string                  `json:"name"`
+	Description data.CompanyDescription `json:"description"`
+	Employees   int                     `json:"employees"`
+	Registered  *bool                   `json:"registered"`
+	Type        string                  `json:"type"`
+	Attributes  data.CompanyAttributes  `json:"attributes"`
+}
+
+// company returns the company described by the input.
+func (input companyInput) company() *data.Company {
+	return &data.Company{
 		Name:        input.Name,
 		Description: input.Description,
 		Employees:   input.Employees,
 		Registered:  input.Registered,
 		Type:        input.Type,
+		Attributes:  input.Attributes,
 	}
+}
+
+// CreateCompanyHandler creates a new company record in the database based on the data
+// in the POSTed JSON document. If the request body contains invalid data, this method
+// returns an error response, along with a list of validation errors.
+func (app *application) CreateCompanyHandler(writer http.ResponseWriter, request *http.Request) {
+	var UUID uuid.UUID
+	var input companyInput
+	err := app.readJSON(request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	company := input.company()
 
 	v := validator.New()
 
-	if data.ValidateCompany(v, company); !v.IsValid() {
+	if app.validateCompany(v, company); !v.IsValid() {
 		app.failedValidationResponse(writer, request, v.Errors)
 		return
 	}
-	UUID, err = app.company.CreateCompany(company)
+	UUID, err = app.company.CreateCompany(request.Context(), company, app.contextGetActor(request))
 	if err != nil {
-		app.serverErrorResponse(writer, request, err)
+		switch err {
+		case data.ErrDuplicateName:
+			v.AddError("name", "a company with this name already exists")
+			app.failedValidationResponse(writer, request, v.Errors)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
 		return
 	}
 	err = app.writeJSON(writer, http.StatusCreated, envelope{"id": UUID}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
-	app.eventChan <- data.EventRecord{
-		ID:        UUID,
-		Type:      data.CompanyCreated,
-		TimeStamp: time.Now().UTC(),
-	}
 }
 
 // DeleteCompanyHandler deletes a specific company based on the ID provided in the
 // request URL. If no matching company is found, this method returns a 404 Not Found
-// response.
+// response. If the request has an If-Match header which does not match the current
+// version of the company, this method returns a 412 Precondition Failed response, and
+// if the company has subsidiaries, a 409 Conflict response.
 func (app *application) DeleteCompanyHandler(writer http.ResponseWriter, request *http.Request) {
 	id, err := app.readIDParam(request)
 	if err != nil {
@@ -90,7 +281,11 @@ func (app *application) DeleteCompanyHandler(writer http.ResponseWriter, request
 		app.badRequestResponse(writer, request, err)
 		return
 	}
-	err = app.company.DeleteCompany(id)
+	if app.config.requireIfMatch && request.Header.Get("If-Match") == "" {
+		app.preconditionRequiredResponse(writer, request)
+		return
+	}
+	company, err := app.company.GetCompany(request.Context(), id)
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
@@ -100,28 +295,38 @@ func (app *application) DeleteCompanyHandler(writer http.ResponseWriter, request
 		}
 		return
 	}
+	if !app.ifMatch(request, company.Version) {
+		app.preconditionFailedResponse(writer, request)
+		return
+	}
+	err = app.company.DeleteCompany(request.Context(), id, company.Version, app.contextGetActor(request))
+	if err != nil {
+		app.writeConditionalError(writer, request, err)
+		return
+	}
 	err = app.writeJSON(writer, http.StatusOK, envelope{"id": id}, nil)
 	if err != nil {
 		app.logger.Println(err)
 	}
-	app.eventChan <- data.EventRecord{
-		ID:        id,
-		Type:      data.CompanyDeleted,
-		TimeStamp: time.Now().UTC(),
-	}
 }
 
 // UpdateCompanyHandler updates a specific company based on the ID provided in the
 // request URL. If no matching company is found, this method returns a 404 Not Found
-// response.
+// response. The update only succeeds if the company has not been changed since it
+// was read: if the request has an If-Match header which does not match the version
+// of the company, this method returns a 412 Precondition Failed response, and if a
+// concurrent update happens without If-Match, a 409 Conflict response.
 func (app *application) UpdateCompanyHandler(writer http.ResponseWriter, request *http.Request) {
 	id, err := app.readIDParam(request)
 	if err != nil {
 		app.badRequestResponse(writer, request, err)
 		return
 	}
-	app.lock.Lock()
-	company, err := app.company.GetCompany(id)
+	if app.config.requireIfMatch && request.Header.Get("If-Match") == "" {
+		app.preconditionRequiredResponse(writer, request)
+		return
+	}
+	company, err := app.company.GetCompany(request.Context(), id)
 	if err != nil {
 		switch err {
 		case data.ErrRecordNotFound:
@@ -131,12 +336,17 @@ func (app *application) UpdateCompanyHandler(writer http.ResponseWriter, request
 		}
 		return
 	}
+	if !app.ifMatch(request, company.Version) {
+		app.preconditionFailedResponse(writer, request)
+		return
+	}
 	var input struct {
 		Name        *string                 `json:"name"`
 		Description data.CompanyDescription `json:"description"`
 		Employees   *int                    `json:"employees"`
 		Registered  *bool                   `json:"registered"`
 		Type        *string                 `json:"type"`
+		Attributes  *data.CompanyAttributes `json:"attributes"`
 	}
 	err = app.readJSON(request, &input)
 	if err != nil {
@@ -159,33 +369,78 @@ func (app *application) UpdateCompanyHandler(writer http.ResponseWriter, request
 	if input.Type != nil {
 		company.Type = *input.Type
 	}
+	if input.Attributes != nil {
+		company.Attributes = *input.Attributes
+	}
 	v := validator.New()
-	if data.ValidateCompany(v, company); !v.IsValid() {
+	if app.validateCompany(v, company); !v.IsValid() {
 		app.failedValidationResponse(writer, request, v.Errors)
 		return
 	}
-	err = app.company.UpdateCompany(company)
-
+	err = app.company.UpdateCompany(request.Context(), company, app.contextGetActor(request))
 	if err != nil {
-		app.serverErrorResponse(writer, request, err)
+		app.writeConditionalError(writer, request, err)
 		return
 	}
-	app.lock.Unlock()
 
-	err = app.writeJSON(writer, http.StatusOK, envelope{"company": company}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", etag(company.Version))
+	err = app.writeJSON(writer, http.StatusOK, envelope{"company": company}, headers)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
-	app.eventChan <- data.EventRecord{
-		ID:        id,
-		Type:      data.CompanyUpdated,
-		TimeStamp: time.Now().UTC(),
+}
+
+// writeConditionalError writes the error response of a failed conditional write. An
+// edit conflict means that the company changed after it was read: this is reported as
+// a failed precondition when the client asked for a specific version with If-Match.
+// A name already used by another company is reported as a validation error, and a
+// company which cannot be deleted because of its subsidiaries as a conflict.
+func (app *application) writeConditionalError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch {
+	case err == data.ErrRecordNotFound:
+		app.notFoundResponse(writer, request)
+	case err == data.ErrDuplicateName:
+		app.failedValidationResponse(writer, request, map[string]string{"name": "a company with this name already exists"})
+	case err == data.ErrEditConflict && request.Header.Get("If-Match") != "":
+		app.preconditionFailedResponse(writer, request)
+	case err == data.ErrEditConflict:
+		app.editConflictResponse(writer, request)
+	case err == data.ErrHasSubsidiaries:
+		app.subsidiariesConflictResponse(writer, request)
+	default:
+		app.serverErrorResponse(writer, request, err)
 	}
 }
 
 type CompanyRepository interface {
-	GetCompany(id uuid.UUID) (*data.Company, error)
-	CreateCompany(company *data.Company) (uuid.UUID, error)
-	DeleteCompany(id uuid.UUID) error
-	UpdateCompany(company *data.Company) error
+	GetCompany(ctx context.Context, id uuid.UUID) (*data.Company, error)
+	GetCompanyAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (*data.Company, error)
+	CreateCompany(ctx context.Context, company *data.Company, actor string) (uuid.UUID, error)
+	ImportCompanies(ctx context.Context, companies []*data.Company, atomic bool, actor string) ([]data.ImportResult, error)
+	DeleteCompany(ctx context.Context, id uuid.UUID, version int, actor string) error
+	UpdateCompany(ctx context.Context, company *data.Company, actor string) error
+	GetAllCompanies(ctx context.Context, query data.CompanyQuery, filters data.Filters) ([]*data.Company, data.Metadata, error)
+	GetCompaniesByCursor(ctx context.Context, query data.CompanyQuery, filters data.KeysetFilters) ([]*data.Company, data.CursorPage, error)
+	ExportCompanies(ctx context.Context, query data.CompanyQuery, fn func(*data.Company) error) error
+	SearchCompanies(ctx context.Context, q string, filters data.Filters) ([]*data.CompanySearchResult, data.Metadata, error)
+	GetDeletedCompanies(ctx context.Context, filters data.Filters) ([]*data.Company, data.Metadata, error)
+	RestoreCompany(ctx context.Context, id uuid.UUID, actor string) error
+	PurgeDeletedCompanies(ctx context.Context, deletedBefore time.Time) (int64, error)
+	GetCompanyRevisions(ctx context.Context, id uuid.UUID, filters data.Filters) ([]*data.CompanyRevision, data.Metadata, error)
+	GetCompanyRevision(ctx context.Context, id uuid.UUID, revision int) (*data.CompanyRevision, error)
+	RollbackCompany(ctx context.Context, id uuid.UUID, revision, version int, actor string) (*data.Company, error)
+	GetCompanyAddresses(ctx context.Context, companyID uuid.UUID) ([]*data.Address, error)
+	GetCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID) (*data.Address, error)
+	CreateCompanyAddress(ctx context.Context, address *data.Address, actor string) error
+	UpdateCompanyAddress(ctx context.Context, address *data.Address, actor string) error
+	DeleteCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID, actor string) error
+	AddCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) (bool, error)
+	RemoveCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) error
+	GetTags(ctx context.Context) ([]*data.Tag, error)
+	SetCompanyParent(ctx context.Context, id, parentID uuid.UUID, actor string) error
+	ClearCompanyParent(ctx context.Context, id uuid.UUID, actor string) error
+	GetSubsidiaries(ctx context.Context, id uuid.UUID, depth int) ([]*data.CompanyNode, error)
+	GetAncestors(ctx context.Context, id uuid.UUID) ([]*data.CompanyNode, error)
+	GetCompaniesByAttribute(ctx context.Context, key, value string, filters data.Filters) ([]*data.Company, data.Metadata, error)
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"io"
+	"mborgnolo/companyservice/internal/data"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -22,7 +23,101 @@ func TestGetCompany(t *testing.T) {
 	}{
 		{"Valid ID", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", http.StatusOK, []byte("company")},
 		{"Non-existent ID", "/v1/company/5f001b5d-8cd1-4f90-8a6a-5164adee43b5", http.StatusNotFound, nil},
-		{"Empty ID", "/v1/company/", http.StatusNotFound, nil},
+		{"Empty ID", "/v1/company/", http.StatusOK, []byte("companies")},
+		{"As of existing", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c?as_of=2023-06-01T00:00:00Z", http.StatusOK, []byte("as_of")},
+		{"As of before creation", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c?as_of=2022-06-01T00:00:00Z", http.StatusNotFound, nil},
+		{"As of invalid", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c?as_of=yesterday", http.StatusUnprocessableEntity, []byte("as_of")},
+		{"Include addresses", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c?include=addresses", http.StatusOK, []byte(`"addresses":[{"id":"5a0c2f7e-1d3b-4c8a-9e6f-2b7d4a1c8e30"`)},
+		{"Include unknown", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c?include=tags", http.StatusUnprocessableEntity, []byte("include")},
+		{"Include as of", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c?include=addresses&as_of=2023-06-01T00:00:00Z", http.StatusUnprocessableEntity, []byte("as_of")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Get(ts.URL + tt.urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+// TestListCompanies tests the listCompaniesHandler function.
+func TestListCompanies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"No filters", "/v1/company", http.StatusOK, []byte("metadata")},
+		{"All filters", "/v1/company?name=test&type=Corporations&registered=true&min_employees=1&max_employees=10&page=1&page_size=5&sort=-employees", http.StatusOK, []byte("Test Company")},
+		{"Invalid type", "/v1/company?type=Corporate", http.StatusUnprocessableEntity, []byte("type")},
+		{"Invalid registered", "/v1/company?registered=maybe", http.StatusUnprocessableEntity, []byte("registered")},
+		{"Invalid employees range", "/v1/company?min_employees=10&max_employees=1", http.StatusUnprocessableEntity, []byte("max_employees")},
+		{"Invalid page", "/v1/company?page=0", http.StatusUnprocessableEntity, []byte("page")},
+		{"Invalid page size", "/v1/company?page_size=1000", http.StatusUnprocessableEntity, []byte("page_size")},
+		{"Invalid sort", "/v1/company?sort=founded", http.StatusUnprocessableEntity, []byte("sort")},
+		{"First cursor page", "/v1/company?cursor=&page_size=1", http.StatusOK, []byte("next_cursor")},
+		{"Next cursor page", "/v1/company?cursor=" + data.EncodeCursor(data.Cursor{Sort: "name", Value: "Test Company"}, nil), http.StatusOK, []byte("prev_cursor")},
+		{"Tampered cursor", "/v1/company?cursor=eyJzIjoibmFtZSJ9.c2lnbmF0dXJl", http.StatusUnprocessableEntity, []byte("cursor")},
+		{"Cursor with different sort", "/v1/company?sort=-name&cursor=" + data.EncodeCursor(data.Cursor{Sort: "name"}, nil), http.StatusUnprocessableEntity, []byte("cursor")},
+		{"Cursor with page", "/v1/company?cursor=&page=2", http.StatusUnprocessableEntity, []byte("page")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Get(ts.URL + tt.urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+// TestSearchCompanies tests the searchCompaniesHandler function.
+func TestSearchCompanies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid query", "/v1/company/search?q=test", http.StatusOK, []byte("name_snippet")},
+		{"Phrase and prefix query", `/v1/company/search?q=%22test+company%22+desc*&sort=name&page_size=5`, http.StatusOK, []byte("metadata")},
+		{"Missing query", "/v1/company/search", http.StatusUnprocessableEntity, []byte("q")},
+		{"Query without words", "/v1/company/search?q=%26%26", http.StatusUnprocessableEntity, []byte("q")},
+		{"Invalid sort", "/v1/company/search?q=test&sort=employees", http.StatusUnprocessableEntity, []byte("sort")},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -69,6 +164,21 @@ func TestCreateCompany(t *testing.T) {
 			bytes.NewReader([]byte(`{"name":"AWS","registered":true,"type":"Corporate"}`)),
 			http.StatusUnprocessableEntity,
 			nil,
+		}, {"Duplicate name",
+			"/v1/company/",
+			bytes.NewReader([]byte(`{"name":"Test Company","employees":10,"registered":true,"type":"Corporations"}`)),
+			http.StatusUnprocessableEntity,
+			[]byte("already exists"),
+		}, {"Name too long",
+			"/v1/company/",
+			bytes.NewReader([]byte(`{"name":"Sixteen Chars Co","employees":10,"registered":true,"type":"Corporations"}`)),
+			http.StatusUnprocessableEntity,
+			[]byte("must not be more than 15 characters"),
+		}, {"Name of 15 multibyte characters",
+			"/v1/company/",
+			bytes.NewReader([]byte(`{"name":"Crédit Agricolé","employees":10,"registered":true,"type":"Corporations"}`)),
+			http.StatusCreated,
+			[]byte("id"),
 		},
 	}
 	for _, tt := range tests {
@@ -185,3 +295,55 @@ func TestUpdateCompany(t *testing.T) {
 		})
 	}
 }
+
+// TestConditionalRequests tests the ETag and If-Match handling of the company handlers.
+func TestConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		ifMatch        string
+		requireIfMatch bool
+		bodyRequest    []byte
+		wantCode       int
+		wantETag       string
+	}{
+		{"Get returns ETag", http.MethodGet, "", false, nil, http.StatusOK, `"1"`},
+		{"Update with matching If-Match", http.MethodPatch, `"1"`, false, []byte(`{"employees":10,"type":"Corporations"}`), http.StatusOK, `"2"`},
+		{"Update with wildcard If-Match", http.MethodPatch, "*", false, []byte(`{"employees":10,"type":"Corporations"}`), http.StatusOK, `"2"`},
+		{"Update with one of several If-Match", http.MethodPatch, `"3", "1"`, false, []byte(`{"employees":10,"type":"Corporations"}`), http.StatusOK, `"2"`},
+		{"Update with stale If-Match", http.MethodPatch, `"0"`, false, []byte(`{"employees":10,"type":"Corporations"}`), http.StatusPreconditionFailed, ""},
+		{"Update without required If-Match", http.MethodPatch, "", true, []byte(`{"employees":10,"type":"Corporations"}`), http.StatusPreconditionRequired, ""},
+		{"Update without optional If-Match", http.MethodPatch, "", false, []byte(`{"employees":10,"type":"Corporations"}`), http.StatusOK, `"2"`},
+		{"Delete with matching If-Match", http.MethodDelete, `"1"`, true, nil, http.StatusOK, ""},
+		{"Delete with stale If-Match", http.MethodDelete, `"2"`, false, nil, http.StatusPreconditionFailed, ""},
+		{"Delete without required If-Match", http.MethodDelete, "", true, nil, http.StatusPreconditionRequired, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.requireIfMatch = tt.requireIfMatch
+			ts := httptest.NewServer(app.routes())
+			defer ts.Close()
+
+			req, err := http.NewRequest(tt.method, ts.URL+"/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", bytes.NewReader(tt.bodyRequest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if got := rs.Header.Get("ETag"); got != tt.wantETag {
+				t.Errorf("want ETag %q; got %q", tt.wantETag, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// contextKey is the type of the keys used to store values in the request context.
+type contextKey string
+
+const actorContextKey = contextKey("actor")
+
+// contextSetActor returns a copy of the request with the authenticated user added
+// to its context.
+func (app *application) contextSetActor(r *http.Request, actor string) *http.Request {
+	ctx := context.WithValue(r.Context(), actorContextKey, actor)
+	return r.WithContext(ctx)
+}
+
+// contextGetActor returns the authenticated user stored in the request context, or
+// an empty string for requests which have not been authenticated.
+func (app *application) contextGetActor(r *http.Request) string {
+	actor, ok := r.Context().Value(actorContextKey).(string)
+	if !ok {
+		return ""
+	}
+	return actor
+}
@@ -1,6 +1,10 @@
 package main
 
-import "net/http"
+import (
+	"mborgnolo/companyservice/internal/data"
+	"net/http"
+	"strings"
+)
 
 // errorResponse is a helper which writes an error response to the client.
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
@@ -14,6 +18,10 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 // below different error responses func are defined depending on the error type
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if data.IsTimeout(err) {
+		app.timeoutResponse(w, r)
+		return
+	}
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
@@ -36,3 +44,43 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	message := "invalid or missing authentication token"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
+
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to update the record due to an edit conflict, please try again"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) subsidiariesConflictResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the company has subsidiaries, please delete them or change their parent first"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been modified since it was retrieved, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request must be conditional, please provide an If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionRequired, message)
+}
+
+func (app *application) timeoutResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the server could not process your request in time, please try again later"
+	app.errorResponse(w, r, http.StatusGatewayTimeout, message)
+}
+
+func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the " + r.Method + " method is not supported for this resource"
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := "the request body must be one of: " + strings.Join(supported, ", ")
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := "the response can only be one of: " + strings.Join(supported, ", ")
+	app.errorResponse(w, r, http.StatusNotAcceptable, message)
+}
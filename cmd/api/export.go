@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportFormats maps the formats of an export to their media type.
+var exportFormats = map[string]string{
+	"csv":    mediaTypeCSV,
+	"ndjson": mediaTypeNDJSON,
+	"json":   "application/json",
+}
+
+// exportColumns are the columns of a CSV export.
+var exportColumns = []string{"id", "name", "description", "employees", "registered", "type", "version"}
+
+// companyEncoder writes the companies of an export in one of the export formats.
+type companyEncoder interface {
+	begin() error
+	encode(company *data.Company) error
+	end() error
+}
+
+// csvEncoder writes the companies as CSV records, after a header naming the columns.
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin() error {
+	return e.w.Write(exportColumns)
+}
+
+func (e *csvEncoder) encode(company *data.Company) error {
+	registered := ""
+	if company.Registered != nil {
+		registered = strconv.FormatBool(*company.Registered)
+	}
+	return e.w.Write([]string{company.ID.String(), company.Name, company.Description.String, strconv.Itoa(company.Employees),
+		registered, company.Type, strconv.Itoa(company.Version)})
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// ndjsonEncoder writes every company as a JSON document on its own line.
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) encode(company *data.Company) error {
+	return e.enc.Encode(company)
+}
+
+func (e *ndjsonEncoder) end() error {
+	return nil
+}
+
+// jsonEncoder writes the companies as the elements of a JSON array.
+type jsonEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonEncoder) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonEncoder) encode(company *data.Company) error {
+	js, err := json.Marshal(company)
+	if err != nil {
+		return err
+	}
+	if e.count > 0 {
+		js = append([]byte(","), js...)
+	}
+	e.count++
+	_, err = e.w.Write(js)
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	_, err := io.WriteString(e.w, "]\n")
+	return err
+}
+
+// newCompanyEncoder returns the encoder of the given export format.
+func newCompanyEncoder(format string, w io.Writer) companyEncoder {
+	switch format {
+	case "csv":
+		return &csvEncoder{w: csv.NewWriter(w)}
+	case "ndjson":
+		return &ndjsonEncoder{enc: json.NewEncoder(w)}
+	default:
+		return &jsonEncoder{w: w}
+	}
+}
+
+// exportFormat returns the format of an export requested with the given Accept
+// header: the first acceptable media type in the order of the header, JSON if any
+// media type is acceptable, or an empty string if no format is acceptable.
+func exportFormat(accept string) string {
+	if accept == "" {
+		return "json"
+	}
+	for _, value := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(value)
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case "*/*", "application/*":
+			return "json"
+		case "text/*":
+			return "csv"
+		}
+		for format, formatType := range exportFormats {
+			if mediaType == formatType {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// ExportCompaniesHandler streams every company matching the type, registered,
+// min_employees, max_employees, name and tag query string parameters, sorted by name.
+// The format is selected with the format query string parameter (csv, ndjson or
+// json), or else with the Accept header; JSON exports are a single array. The
+// companies are written as they are read from the database, so the response is
+// never held in memory, and written for up to the export timeout. If the export fails
+// once the response has started, the response is aborted so that the client does not
+// mistake it for a complete one.
+func (app *application) ExportCompaniesHandler(writer http.ResponseWriter, request *http.Request) {
+	var query data.CompanyQuery
+	v := validator.New()
+	qs := request.URL.Query()
+
+	query.Name = app.readString(qs, "name", "")
+	query.Type = app.readString(qs, "type", "")
+	query.Registered = app.readBool(qs, "registered", v)
+	query.MinEmployees = app.readInt(qs, "min_employees", 0, v)
+	query.MaxEmployees = app.readInt(qs, "max_employees", 0, v)
+	query.Tag = strings.ToLower(app.readString(qs, "tag", ""))
+	format := app.readString(qs, "format", "")
+	if format != "" {
+		v.Check(exportFormats[format] != "", "format", "must be one of: csv, ndjson, json")
+	}
+
+	if data.ValidateCompanyQuery(v, query); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	if format == "" {
+		format = exportFormat(request.Header.Get("Accept"))
+	}
+	if format == "" {
+		app.notAcceptableResponse(writer, request, exportFormats["csv"], exportFormats["ndjson"], exportFormats["json"])
+		return
+	}
+
+	if err := app.extendWriteDeadline(writer, app.config.exportTimeout); err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	encoder := newCompanyEncoder(format, writer)
+	started := false
+	start := func() error {
+		started = true
+		writer.Header().Set("Content-Type", exportFormats[format])
+		writer.Header().Set("Content-Disposition", `attachment; filename="companies.`+format+`"`)
+		writer.WriteHeader(http.StatusOK)
+		return encoder.begin()
+	}
+	err := app.company.ExportCompanies(request.Context(), query, func(company *data.Company) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return encoder.encode(company)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = encoder.end()
+	}
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(writer, request, err)
+			return
+		}
+		app.logger.Printf("export aborted: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestExportCompanies tests the ExportCompaniesHandler function.
+func TestExportCompanies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		accept          string
+		wantCode        int
+		wantContentType string
+		wantBody        []byte
+	}{
+		{"Default format", "/v1/company/export", "", http.StatusOK, "application/json",
+			[]byte(`[{"id":"dc152cf7-cc4b-4555-8d4c-1878e5b9262c","name":"Test Company",`)},
+		{"CSV format", "/v1/company/export?format=csv", "application/json", http.StatusOK, "text/csv",
+			[]byte("id,name,description,employees,registered,type,version\ndc152cf7-cc4b-4555-8d4c-1878e5b9262c,Test Company,Test Company Description,10,true,Corporations,1\n")},
+		{"NDJSON accepted", "/v1/company/export", "text/html, application/x-ndjson", http.StatusOK, "application/x-ndjson",
+			[]byte("{\"id\":\"dc152cf7-cc4b-4555-8d4c-1878e5b9262c\",\"name\":\"Test Company\",\"description\":\"Test Company Description\",\"employees\":10,\"registered\":true,\"type\":\"Corporations\",\"version\":1}\n")},
+		{"Any format accepted", "/v1/company/export", "*/*", http.StatusOK, "application/json", []byte("Test Company")},
+		{"No match", "/v1/company/export?type=NonProfit", "", http.StatusOK, "application/json", []byte("[]\n")},
+		{"Employee range", "/v1/company/export?format=csv&min_employees=11", "", http.StatusOK, "text/csv",
+			[]byte("id,name,description,employees,registered,type,version\n")},
+		{"Unknown format", "/v1/company/export?format=xml", "", http.StatusUnprocessableEntity, "application/json", []byte("format")},
+		{"Invalid filter", "/v1/company/export?registered=maybe", "", http.StatusUnprocessableEntity, "application/json", []byte("registered")},
+		{"Not acceptable", "/v1/company/export", "text/html", http.StatusNotAcceptable, "application/json", []byte("text/csv")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tt.urlPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if got := rs.Header.Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("want content type %q; got %q", tt.wantContentType, got)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
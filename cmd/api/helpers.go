@@ -3,10 +3,16 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
 	"log"
+	"mborgnolo/companyservice/internal/validator"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // envelope is a generic envelope for API responses.
@@ -57,3 +63,111 @@ func (app *application) readIDParam(r *http.Request) (uuid.UUID, error) {
 	}
 	return id, nil
 }
+
+// readAddressIDParam is a helper that reads the address parameter from the request URL.
+func (app *application) readAddressIDParam(r *http.Request) (uuid.UUID, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := uuid.Parse(params.ByName("address"))
+	if err != nil {
+		return uuid.Nil, errors.New("invalid address parameter")
+	}
+	return id, nil
+}
+
+// readRevisionParam is a helper that reads the revision parameter from the request URL.
+func (app *application) readRevisionParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	revision, err := strconv.Atoi(params.ByName("revision"))
+	if err != nil || revision < 1 {
+		return 0, errors.New("invalid revision parameter")
+	}
+	return revision, nil
+}
+
+// readString is a helper that returns a string value from the query string,
+// or the provided default value if no matching key is found.
+func (app *application) readString(qs url.Values, key string, defaultValue string) string {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	return s
+}
+
+// readCSV is a helper that reads a comma-separated list of values from the query
+// string, or returns the provided default value if no matching key is found.
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+	if csv == "" {
+		return defaultValue
+	}
+	return strings.Split(csv, ",")
+}
+
+// readInt is a helper that reads an integer value from the query string. If the
+// value cannot be converted to an integer an error is recorded in the validator.
+func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		v.AddError(key, "must be an integer value")
+		return defaultValue
+	}
+	return i
+}
+
+// readBool is a helper that reads an optional boolean value from the query string.
+// It returns nil if the key is missing, and records an error in the validator if
+// the value cannot be converted to a boolean.
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+	if s == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+	return &b
+}
+
+// etag returns the entity tag of the given version of a resource.
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch is a helper that reports whether the If-Match header of the request matches
+// the given version of the resource. A missing header or "*" matches any version.
+func (app *application) ifMatch(r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// extendWriteDeadline moves the write deadline of the response, set by the server
+// WriteTimeout, to the end of the timeout, so that a response written for longer than
+// other requests is not cut. A timeout of 0 removes the deadline. Response writers
+// without deadlines, such as the recorders of the tests, are left as they are.
+func (app *application) extendWriteDeadline(w http.ResponseWriter, timeout time.Duration) error {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	err := http.NewResponseController(w).SetWriteDeadline(deadline)
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"net/http"
+)
+
+// writeHierarchyError writes the response of an error returned by a change of the
+// parent of a company.
+func (app *application) writeHierarchyError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch err {
+	case data.ErrRecordNotFound:
+		app.notFoundResponse(writer, request)
+	case data.ErrParentNotFound:
+		app.failedValidationResponse(writer, request, map[string]string{"parent_id": "must be an existing company"})
+	case data.ErrHierarchyCycle:
+		app.failedValidationResponse(writer, request, map[string]string{"parent_id": "must not be the company itself or one of its subsidiaries"})
+	default:
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// writeCompanyAfterParentChange writes the company identified by id after a change
+// of its parent, with its new version as ETag.
+func (app *application) writeCompanyAfterParentChange(writer http.ResponseWriter, request *http.Request, id uuid.UUID) {
+	company, err := app.company.GetCompany(request.Context(), id)
+	if err != nil {
+		app.writeHierarchyError(writer, request, err)
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag(company.Version))
+	err = app.writeJSON(writer, http.StatusOK, envelope{"company": company}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// SetCompanyParentHandler makes the company whose id is in the PUT JSON document the
+// parent of the company identified by the ID provided in the request URL, and returns
+// the company. A company cannot become a subsidiary of itself or of one of its
+// subsidiaries.
+func (app *application) SetCompanyParentHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	var input struct {
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+	err = app.readJSON(request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	v := validator.New()
+	if v.Check(input.ParentID != nil, "parent_id", "is required"); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	err = app.company.SetCompanyParent(request.Context(), id, *input.ParentID, app.contextGetActor(request))
+	if err != nil {
+		app.writeHierarchyError(writer, request, err)
+		return
+	}
+	app.writeCompanyAfterParentChange(writer, request, id)
+}
+
+// ClearCompanyParentHandler makes the company identified by the ID provided in the
+// request URL a top-level company, and returns the company. A 404 Not Found response
+// is returned if the company has no parent.
+func (app *application) ClearCompanyParentHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	err = app.company.ClearCompanyParent(request.Context(), id, app.contextGetActor(request))
+	if err != nil {
+		app.writeHierarchyError(writer, request, err)
+		return
+	}
+	app.writeCompanyAfterParentChange(writer, request, id)
+}
+
+// ListSubsidiariesHandler returns the subsidiaries of the company identified by the ID
+// provided in the request URL, down to the number of levels given by the depth query
+// string parameter (1 by default, for the direct subsidiaries). Every subsidiary has
+// its parent_id and its depth, so that the tree can be rebuilt.
+func (app *application) ListSubsidiariesHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	v := validator.New()
+	depth := app.readInt(request.URL.Query(), "depth", 1, v)
+	if data.ValidateDepth(v, depth); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	subsidiaries, err := app.company.GetSubsidiaries(request.Context(), id, depth)
+	if err != nil {
+		app.writeHierarchyError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"subsidiaries": subsidiaries}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// ListAncestorsHandler returns the ancestors of the company identified by the ID
+// provided in the request URL, from its parent up to the top of its hierarchy.
+func (app *application) ListAncestorsHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	ancestors, err := app.company.GetAncestors(request.Context(), id)
+	if err != nil {
+		app.writeHierarchyError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"ancestors": ancestors}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
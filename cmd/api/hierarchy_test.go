@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCompanyHierarchy tests the hierarchy handlers. The cases share the same
+// application and run in order, each one relying on the hierarchy left by the
+// previous ones.
+func TestCompanyHierarchy(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	registered := true
+	id, err := app.company.CreateCompany(context.Background(), &data.Company{Name: "Subsidiary", Employees: 5, Registered: &registered, Type: "Corporations"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const parentPath = "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c"
+	subsidiaryPath := "/v1/company/" + id.String()
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		body     string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Set parent", http.MethodPut, subsidiaryPath + "/parent", `{"parent_id":"dc152cf7-cc4b-4555-8d4c-1878e5b9262c"}`, http.StatusOK,
+			[]byte(`"parent_id":"dc152cf7-cc4b-4555-8d4c-1878e5b9262c"`)},
+		{"Set subsidiary as parent", http.MethodPut, parentPath + "/parent", `{"parent_id":"` + id.String() + `"}`, http.StatusUnprocessableEntity,
+			[]byte("must not be the company itself or one of its subsidiaries")},
+		{"Set unknown parent", http.MethodPut, subsidiaryPath + "/parent", `{"parent_id":"5f001b5d-8cd1-4f90-8a6a-5164adee43b5"}`, http.StatusUnprocessableEntity,
+			[]byte("must be an existing company")},
+		{"Set deleted parent", http.MethodPut, subsidiaryPath + "/parent", `{"parent_id":"3b1f6a52-8f0e-4a57-9d0c-6b2f7c1e4a90"}`, http.StatusUnprocessableEntity,
+			[]byte("must be an existing company")},
+		{"Set missing parent", http.MethodPut, subsidiaryPath + "/parent", `{}`, http.StatusUnprocessableEntity, []byte(`"parent_id":"is required"`)},
+		{"Set invalid parent", http.MethodPut, subsidiaryPath + "/parent", `{"parent_id":"1"}`, http.StatusBadRequest, nil},
+		{"Set parent of unknown company", http.MethodPut, "/v1/company/5f001b5d-8cd1-4f90-8a6a-5164adee43b5/parent",
+			`{"parent_id":"dc152cf7-cc4b-4555-8d4c-1878e5b9262c"}`, http.StatusNotFound, nil},
+		{"Subsidiaries", http.MethodGet, parentPath + "/subsidiaries", "", http.StatusOK, []byte(`"name":"Subsidiary"`)},
+		{"Subsidiaries with depth", http.MethodGet, parentPath + "/subsidiaries?depth=3", "", http.StatusOK, []byte(`"depth":1`)},
+		{"Subsidiaries invalid depth", http.MethodGet, parentPath + "/subsidiaries?depth=0", "", http.StatusUnprocessableEntity, []byte("depth")},
+		{"Subsidiaries of unknown company", http.MethodGet, "/v1/company/5f001b5d-8cd1-4f90-8a6a-5164adee43b5/subsidiaries", "", http.StatusNotFound, nil},
+		{"Ancestors", http.MethodGet, subsidiaryPath + "/ancestors", "", http.StatusOK, []byte(`"name":"Test Company"`)},
+		{"Ancestors of top-level company", http.MethodGet, parentPath + "/ancestors", "", http.StatusOK, []byte(`"ancestors":[]`)},
+		{"Delete parent", http.MethodDelete, parentPath, "", http.StatusConflict, []byte("subsidiaries")},
+		{"Clear parent", http.MethodDelete, subsidiaryPath + "/parent", "", http.StatusOK, []byte(`"name":"Subsidiary"`)},
+		{"Clear cleared parent", http.MethodDelete, subsidiaryPath + "/parent", "", http.StatusNotFound, nil},
+		{"No subsidiaries", http.MethodGet, parentPath + "/subsidiaries", "", http.StatusOK, []byte(`"subsidiaries":[]`)},
+		{"Delete former parent", http.MethodDelete, parentPath, "", http.StatusOK, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+			// The company whose parent changed is returned with its new ETag.
+			if strings.HasSuffix(tt.urlPath, "/parent") && rs.StatusCode == http.StatusOK {
+				company, err := app.company.GetCompany(context.Background(), id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := rs.Header.Get("ETag"); got != etag(company.Version) {
+					t.Errorf("want ETag %s; got %q", etag(company.Version), got)
+				}
+			}
+		})
+	}
+}
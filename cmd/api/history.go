@@ -0,0 +1,126 @@
+package main
+
+import (
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"net/http"
+)
+
+// ListCompanyRevisionsHandler returns a page of the revisions of the company identified
+// by the ID provided in the request URL, latest first. If the company has no revision,
+// this method returns a 404 Not Found response.
+func (app *application) ListCompanyRevisionsHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	v := validator.New()
+	qs := request.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-revision",
+		SortSafelist: []string{"-revision"},
+	}
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	revisions, metadata, err := app.company.GetCompanyRevisions(request.Context(), id, filters)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// GetCompanyRevisionHandler returns a single revision of the company identified by the
+// ID and revision number provided in the request URL. If no matching revision is found,
+// this method returns a 404 Not Found response.
+func (app *application) GetCompanyRevisionHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	revisionNumber, err := app.readRevisionParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	revision, err := app.company.GetCompanyRevision(request.Context(), id, revisionNumber)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// RollbackCompanyHandler restores the company identified by the ID provided in the
+// request URL to its state at the given revision. The rollback is recorded as a new
+// revision. Like an update, the rollback is conditional on the If-Match header, if
+// provided or required. If the company or the revision is not found, this method
+// returns a 404 Not Found response.
+func (app *application) RollbackCompanyHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	revision, err := app.readRevisionParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	if app.config.requireIfMatch && request.Header.Get("If-Match") == "" {
+		app.preconditionRequiredResponse(writer, request)
+		return
+	}
+	company, err := app.company.GetCompany(request.Context(), id)
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+	if !app.ifMatch(request, company.Version) {
+		app.preconditionFailedResponse(writer, request)
+		return
+	}
+	company, err = app.company.RollbackCompany(request.Context(), id, revision, company.Version, app.contextGetActor(request))
+	if err != nil {
+		switch err {
+		case data.ErrDuplicateName:
+			app.failedValidationResponse(writer, request, map[string]string{"name": "is now used by another company"})
+		default:
+			app.writeConditionalError(writer, request, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag(company.Version))
+	err = app.writeJSON(writer, http.StatusOK, envelope{"company": company}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCompanyHistory tests the company revision history handlers.
+func TestCompanyHistory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"List revisions", http.MethodGet, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/history", http.StatusOK, []byte("revisions")},
+		{"List revisions with paging", http.MethodGet, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/history?page=1&page_size=5", http.StatusOK, []byte("metadata")},
+		{"List revisions with invalid paging", http.MethodGet, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/history?page_size=0", http.StatusUnprocessableEntity, []byte("page_size")},
+		{"List revisions of unknown company", http.MethodGet, "/v1/company/5f001b5d-8cd1-4f90-8a6a-5164adee43b5/history", http.StatusNotFound, nil},
+		{"Get revision", http.MethodGet, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/history/1", http.StatusOK, []byte("john@companyservice.io")},
+		{"Get unknown revision", http.MethodGet, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/history/2", http.StatusNotFound, nil},
+		{"Get invalid revision", http.MethodGet, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/history/zero", http.StatusBadRequest, nil},
+		{"Rollback", http.MethodPost, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/rollback/1", http.StatusOK, []byte(`"version":2`)},
+		{"Rollback to unknown revision", http.MethodPost, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/rollback/5", http.StatusNotFound, nil},
+		{"Rollback to invalid revision", http.MethodPost, "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/rollback/0", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+// TestRollbackConditional tests the If-Match handling of the rollback handler.
+func TestRollbackConditional(t *testing.T) {
+	tests := []struct {
+		name           string
+		ifMatch        string
+		requireIfMatch bool
+		wantCode       int
+		wantETag       string
+	}{
+		{"Rollback with matching If-Match", `"1"`, true, http.StatusOK, `"2"`},
+		{"Rollback with stale If-Match", `"0"`, false, http.StatusPreconditionFailed, ""},
+		{"Rollback without required If-Match", "", true, http.StatusPreconditionRequired, ""},
+		{"Rollback without optional If-Match", "", false, http.StatusOK, `"2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.requireIfMatch = tt.requireIfMatch
+			ts := httptest.NewServer(app.routes())
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/rollback/1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if got := rs.Header.Get("ETag"); got != tt.wantETag {
+				t.Errorf("want ETag %q; got %q", tt.wantETag, got)
+			}
+		})
+	}
+}
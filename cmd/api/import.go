@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Import modes: an all-or-nothing import creates no company if any row is invalid,
+// while a best-effort import creates the companies of all the valid rows.
+const (
+	importAllOrNothing = "all_or_nothing"
+	importBestEffort   = "best_effort"
+)
+
+// Media types accepted by the import.
+const (
+	mediaTypeCSV    = "text/csv"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// maxImportSize is the maximum size of an import request body.
+const maxImportSize = 32 << 20
+
+// importColumns are the columns accepted in the header of a CSV import.
+var importColumns = map[string]bool{"name": true, "description": true, "employees": true, "registered": true, "type": true}
+
+// importRow is a company read from an import, along with its line in the request
+// body and the errors found in it.
+type importRow struct {
+	Line    int
+	Company *data.Company
+	Errors  map[string]string
+}
+
+// importRowReport is the outcome of the import of one row: the id of the created
+// company, or the errors which prevented its creation. Both are omitted for the
+// valid rows of a rejected all-or-nothing import.
+type importRowReport struct {
+	Line   int               `json:"line"`
+	ID     string            `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// importReport is the response of an import.
+type importReport struct {
+	Mode    string            `json:"mode"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Rows    []importRowReport `json:"rows"`
+}
+
+// ImportCompaniesHandler creates the companies of the CSV or NDJSON document in the
+// request body, and reports the outcome of every row. Every row is validated like
+// the document of CreateCompanyHandler. The mode query string parameter selects an
+// all-or-nothing import, the default, or a best-effort one. The response status is
+// 201 Created if any company has been created, and 422 Unprocessable Entity otherwise.
+func (app *application) ImportCompaniesHandler(writer http.ResponseWriter, request *http.Request) {
+	v := validator.New()
+	mode := app.readString(request.URL.Query(), "mode", importAllOrNothing)
+	if v.Check(v.In(mode, importAllOrNothing, importBestEffort), "mode", "must be one of: "+importAllOrNothing+", "+importBestEffort); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	var rows []*importRow
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	body := http.MaxBytesReader(writer, request.Body, maxImportSize)
+	switch {
+	case err != nil:
+		app.unsupportedMediaTypeResponse(writer, request, mediaTypeCSV, mediaTypeNDJSON)
+		return
+	case mediaType == mediaTypeCSV:
+		rows, err = app.readCSVImport(body)
+	case mediaType == mediaTypeNDJSON:
+		rows, err = app.readNDJSONImport(body)
+	default:
+		app.unsupportedMediaTypeResponse(writer, request, mediaTypeCSV, mediaTypeNDJSON)
+		return
+	}
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	if len(rows) == 0 {
+		app.badRequestResponse(writer, request, errors.New("body must contain at least one company"))
+		return
+	}
+
+	report := importReport{Mode: mode, Rows: make([]importRowReport, len(rows))}
+	// valid holds the indexes of the rows without errors.
+	var valid []int
+	var companies []*data.Company
+	for i, row := range rows {
+		report.Rows[i].Line = row.Line
+		if len(row.Errors) > 0 {
+			report.Rows[i].Errors = row.Errors
+			report.Failed++
+			continue
+		}
+		valid = append(valid, i)
+		companies = append(companies, row.Company)
+	}
+	if len(valid) > 0 && (mode == importBestEffort || report.Failed == 0) {
+		results, err := app.company.ImportCompanies(request.Context(), companies, mode == importAllOrNothing, app.contextGetActor(request))
+		if err != nil {
+			app.serverErrorResponse(writer, request, err)
+			return
+		}
+		for i, result := range results {
+			rowReport := &report.Rows[valid[i]]
+			switch {
+			case result.Err == data.ErrDuplicateName:
+				rowReport.Errors = map[string]string{"name": "a company with this name already exists"}
+				report.Failed++
+			case result.Err != nil:
+				app.serverErrorResponse(writer, request, result.Err)
+				return
+			case result.ID != uuid.Nil:
+				rowReport.ID = result.ID.String()
+				report.Created++
+			}
+		}
+	}
+
+	status := http.StatusCreated
+	if report.Created == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	err = app.writeJSON(writer, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// newImportRow validates the company read from the given line. The errors found
+// while reading the company take precedence over the validation errors.
+func (app *application) newImportRow(line int, company *data.Company, readErrors map[string]string) *importRow {
+	v := validator.New()
+	app.validateCompany(v, company)
+	for field, message := range readErrors {
+		v.AddError(field, message)
+	}
+	return &importRow{Line: line, Company: company, Errors: v.Errors}
+}
+
+// readCSVImport reads the companies of a CSV document. The first record is the
+// header naming the columns, in any order, among importColumns. Empty values are
+// treated as missing ones.
+func (app *application) readCSVImport(body io.Reader) ([]*importRow, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if _, ok := columns[column]; ok {
+			return nil, fmt.Errorf("column %q is repeated", column)
+		}
+		if !importColumns[column] {
+			return nil, fmt.Errorf("unknown column %q", column)
+		}
+		columns[column] = i
+	}
+
+	var rows []*importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		value := func(column string) string {
+			if i, ok := columns[column]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		company := &data.Company{Name: value("name"), Type: value("type")}
+		readErrors := map[string]string{}
+		if description := value("description"); description != "" {
+			company.Description = data.CompanyDescription{String: description, Valid: true}
+		}
+		if employees := value("employees"); employees != "" {
+			company.Employees, err = strconv.Atoi(employees)
+			if err != nil {
+				readErrors["employees"] = "must be an integer"
+			}
+		}
+		if registered := value("registered"); registered != "" {
+			b, err := strconv.ParseBool(registered)
+			if err != nil {
+				readErrors["registered"] = "must be a boolean"
+			} else {
+				company.Registered = &b
+			}
+		}
+		rows = append(rows, app.newImportRow(line, company, readErrors))
+	}
+}
+
+// readNDJSONImport reads the companies of a newline delimited JSON document, made of
+// one JSON document per line like the one of CreateCompanyHandler. Blank lines are
+// skipped.
+func (app *application) readNDJSONImport(body io.Reader) ([]*importRow, error) {
+	var rows []*importRow
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxImportSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var input companyInput
+		dec := json.NewDecoder(bytes.NewReader(text))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("line must only contain a single JSON document")
+		}
+		if err != nil {
+			rows = append(rows, &importRow{Line: line, Errors: map[string]string{"body": err.Error()}})
+			continue
+		}
+		rows = append(rows, app.newImportRow(line, input.company(), nil))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestImportCompanies tests the ImportCompaniesHandler function.
+func TestImportCompanies(t *testing.T) {
+	csvBody := "name,employees,registered,type,description\n" +
+		"Alpha,10,true,Corporations,First\n" +
+		"Beta,ten,true,NonProfit,\n" +
+		"Test Company,5,false,Cooperative,\n"
+	ndjsonBody := `{"name":"Gamma","employees":3,"registered":true,"type":"Cooperative"}` + "\n\n" +
+		`{"name":"Gamma","employees":4,"registered":true,"type":"Cooperative"}` + "\n" +
+		`{"name":"Delta","unknown":1}` + "\n"
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		body        string
+		wantCode    int
+		wantCreated int
+		wantErrors  map[int]string
+	}{
+		{"CSV all or nothing", "", "text/csv", csvBody, http.StatusUnprocessableEntity, 0,
+			map[int]string{3: "employees"}},
+		{"CSV best effort", "?mode=best_effort", "text/csv; charset=utf-8", csvBody, http.StatusCreated, 1,
+			map[int]string{3: "employees", 4: "name"}},
+		{"CSV columns in any order", "?mode=best_effort", "text/csv", "type,name,registered,employees\nCooperative,Epsilon,yes,2\nCooperative,Zeta,1,2\n", http.StatusCreated, 1,
+			map[int]string{2: "registered"}},
+		{"NDJSON all or nothing", "", "application/x-ndjson", `{"name":"Gamma","employees":3,"registered":true,"type":"Cooperative"}` + "\n" +
+			`{"name":"Test Company","employees":3,"registered":true,"type":"Cooperative"}`, http.StatusUnprocessableEntity, 0,
+			map[int]string{2: "name"}},
+		{"NDJSON best effort", "?mode=best_effort", "application/x-ndjson", ndjsonBody, http.StatusCreated, 1,
+			map[int]string{3: "name", 4: "body"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := httptest.NewServer(app.routes())
+			defer ts.Close()
+
+			rs, err := ts.Client().Post(ts.URL+"/v1/company/import"+tt.query, tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			var response struct {
+				Import importReport `json:"import"`
+			}
+			if err := json.NewDecoder(rs.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Import.Created != tt.wantCreated {
+				t.Errorf("want %d created; got %+v", tt.wantCreated, response.Import)
+			}
+			for _, row := range response.Import.Rows {
+				field, ok := tt.wantErrors[row.Line]
+				if !ok && len(row.Errors) > 0 {
+					t.Errorf("line %d: want no errors; got %v", row.Line, row.Errors)
+				}
+				if ok && row.Errors[field] == "" {
+					t.Errorf("line %d: want an error on %s; got %v", row.Line, field, row.Errors)
+				}
+			}
+		})
+	}
+}
+
+// TestImportCompaniesRequest tests the requests rejected by ImportCompaniesHandler.
+func TestImportCompaniesRequest(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name        string
+		urlPath     string
+		contentType string
+		body        string
+		wantCode    int
+		wantBody    []byte
+	}{
+		{"Unsupported media type", "/v1/company/import", "application/json", `{"name":"Alpha"}`, http.StatusUnsupportedMediaType, []byte("text/csv")},
+		{"Unknown mode", "/v1/company/import?mode=some", "text/csv", "name\nAlpha\n", http.StatusUnprocessableEntity, []byte("mode")},
+		{"Unknown column", "/v1/company/import", "text/csv", "name,size\nAlpha,1\n", http.StatusBadRequest, nil},
+		{"No companies", "/v1/company/import", "application/x-ndjson", "\n", http.StatusBadRequest, nil},
+		{"Company id", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", "text/csv", "", http.StatusMethodNotAllowed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Post(ts.URL+tt.urlPath, tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
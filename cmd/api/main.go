@@ -3,17 +3,25 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
-	"github.com/google/uuid"
 	_ "github.com/lib/pq"
-	"github.com/twmb/franz-go/pkg/kgo"
 	"log"
+	"mborgnolo/companyservice/internal/cache"
+	"mborgnolo/companyservice/internal/changes"
 	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/jsonschema"
+	"mborgnolo/companyservice/internal/publisher"
+	"mborgnolo/companyservice/internal/stream"
+	"mborgnolo/companyservice/pkg/cloudevents"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -23,65 +31,243 @@ const version = "1.0.0"
 
 // config holds the application configuration.
 type config struct {
-	port string
-	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
+	port           string
+	env            string
+	requireIfMatch bool
+	requestTimeout time.Duration
+	exportTimeout  time.Duration
+	storage        string
+	memory         struct {
+		snapshot string
+	}
+	db struct {
+		dsn              string
+		maxOpenConns     int
+		maxIdleConns     int
+		maxIdleTime      string
+		statementTimeout time.Duration
 	}
 	jwt struct {
 		secret string
 	}
+	cursor struct {
+		secret string
+	}
 	kafka struct {
 		brokers string
 		topic   string
 	}
+	events struct {
+		sinks       string
+		mode        cloudevents.Mode
+		source      string
+		file        string
+		fileMaxSize int64
+		fileBackups int
+	}
+	outbox struct {
+		pollInterval  time.Duration
+		batchSize     int
+		minBackoff    time.Duration
+		maxBackoff    time.Duration
+		maxAttempts   int
+		retention     time.Duration
+		pruneInterval time.Duration
+	}
+	stream struct {
+		bufferSize   int
+		heartbeat    time.Duration
+		maxDuration  time.Duration
+		pollInterval time.Duration
+	}
+	webhooks struct {
+		pollInterval  time.Duration
+		batchSize     int
+		timeout       time.Duration
+		maxFailures   int
+		minBackoff    time.Duration
+		maxBackoff    time.Duration
+		allowPrivate  bool
+		retention     time.Duration
+		pruneInterval time.Duration
+	}
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+	cache struct {
+		size int
+		ttl  time.Duration
+	}
+	attributes struct {
+		schema string
+	}
+}
+
+// validate reports the first invalid setting of the configuration, which would
+// otherwise only fail once it is used.
+func (cfg config) validate() error {
+	switch {
+	case cfg.outbox.maxAttempts <= 0:
+		return errors.New("-outbox-max-attempts must be positive")
+	case cfg.outbox.retention < 0:
+		return errors.New("-outbox-retention must not be negative")
+	case cfg.outbox.retention > 0 && cfg.outbox.pruneInterval <= 0:
+		return errors.New("-outbox-prune-interval must be positive")
+	case cfg.stream.heartbeat <= 0:
+		return errors.New("-events-stream-heartbeat must be positive")
+	case cfg.stream.maxDuration < 0:
+		return errors.New("-events-stream-max-duration must not be negative")
+	case cfg.stream.pollInterval <= 0:
+		return errors.New("-events-stream-poll-interval must be positive")
+	case cfg.webhooks.pollInterval <= 0:
+		return errors.New("-webhook-poll-interval must be positive")
+	case cfg.webhooks.batchSize <= 0:
+		return errors.New("-webhook-batch-size must be positive")
+	case cfg.webhooks.timeout <= 0:
+		return errors.New("-webhook-timeout must be positive")
+	case cfg.webhooks.retention < 0:
+		return errors.New("-webhook-delivery-retention must not be negative")
+	case cfg.webhooks.retention > 0 && cfg.webhooks.pruneInterval <= 0:
+		return errors.New("-webhook-prune-interval must be positive")
+	}
+	return nil
 }
 
 // application holds the dependencies for HTTP handlers.
 type application struct {
-	config      config
-	logger      *log.Logger
-	company     CompanyRepository
-	eventChan   chan data.EventRecord
-	KafkaClient *kgo.Client
-	locks       map[uuid.UUID]*sync.Mutex
-	lock        sync.Mutex
+	config    config
+	logger    *log.Logger
+	company   CompanyRepository
+	outbox    OutboxRepository
+	publisher publisher.Publisher
+	webhooks  WebhookRepository
+	// stream delivers the events to the clients of the event stream.
+	stream *stream.Broker
+	// webhookClient sends the webhook deliveries.
+	webhookClient *http.Client
+	changes       *changes.Hub
+	// attributesSchema validates the attributes of the companies, which are not
+	// restricted if it is nil.
+	attributesSchema *jsonschema.Schema
+	wg               sync.WaitGroup
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	var cfg config
 	flag.StringVar(&cfg.port, "port", os.Getenv("CMPSRV_PORT"), "API server port")
 	flag.StringVar(&cfg.env, "env", os.Getenv("CMPSRV_ENV"), "Environment (development|testing|production)")
+	flag.BoolVar(&cfg.requireIfMatch, "require-if-match", false, "Reject updates and deletions without an If-Match header")
+	flag.StringVar(&cfg.storage, "storage", "postgres", "Storage backend (postgres|memory)")
+	flag.StringVar(&cfg.memory.snapshot, "memory-snapshot", "", "JSON file the memory storage is loaded from on start and saved to on shutdown")
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DB_DSN"), "PostgreSQL DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.db.statementTimeout, "db-statement-timeout", 10*time.Second, "PostgreSQL statement timeout (0 disables it)")
+	flag.DurationVar(&cfg.requestTimeout, "request-timeout", 10*time.Second, "Maximum duration of the database work of a request (0 disables it)")
+	flag.DurationVar(&cfg.exportTimeout, "export-timeout", 10*time.Minute, "Maximum duration of an export, instead of the request timeout (0 disables it)")
 	flag.StringVar(&cfg.jwt.secret, "jwt-secret", os.Getenv("JWT_SECRET"), "JWT secret")
+	flag.StringVar(&cfg.cursor.secret, "cursor-secret", os.Getenv("CURSOR_SECRET"), "Secret used to sign pagination cursors (defaults to the JWT secret)")
 	flag.StringVar(&cfg.kafka.brokers, "kafka-brokers", os.Getenv("KAFKA_BROKERS"), "Kafka brokers")
 	flag.StringVar(&cfg.kafka.topic, "kafka-topic", os.Getenv("KAFKA_TOPIC"), "Kafka topic")
+	flag.StringVar(&cfg.events.sinks, "events-sinks", os.Getenv("CMPSRV_EVENTS_SINKS"), "Comma separated sinks of the events (kafka|file|stdout|none), kafka if brokers are provided and none otherwise by default")
+	eventsMode := flag.String("events-mode", "structured", "CloudEvents content mode of the Kafka messages (structured|binary)")
+	flag.StringVar(&cfg.events.source, "events-source", "/companyservice", "CloudEvents source of the events")
+	flag.StringVar(&cfg.events.file, "events-file", "events.ndjson", "NDJSON file of the file sink of the events")
+	flag.Int64Var(&cfg.events.fileMaxSize, "events-file-max-size", 100<<20, "Size in bytes beyond which the events file is rotated")
+	flag.IntVar(&cfg.events.fileBackups, "events-file-backups", 5, "Number of rotated events files kept")
+	flag.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", time.Second, "Interval between polls of the event outbox")
+	flag.IntVar(&cfg.outbox.batchSize, "outbox-batch-size", 100, "Maximum number of outbox events relayed per poll")
+	flag.DurationVar(&cfg.outbox.minBackoff, "outbox-min-backoff", time.Second, "Delay before retrying a failed event delivery")
+	flag.DurationVar(&cfg.outbox.maxBackoff, "outbox-max-backoff", 5*time.Minute, "Maximum delay between retries of a failed event delivery")
+	flag.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 20, "Failed deliveries after which an event is dead and no longer holds back the following ones")
+	flag.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "Time sent events are kept in the outbox before being pruned (0 disables pruning)")
+	flag.DurationVar(&cfg.outbox.pruneInterval, "outbox-prune-interval", time.Hour, "Interval between prunes of the sent outbox events")
+	flag.IntVar(&cfg.stream.bufferSize, "events-stream-buffer", 1000, "Number of recent events replayed to the event stream clients which reconnect")
+	flag.DurationVar(&cfg.stream.heartbeat, "events-stream-heartbeat", 15*time.Second, "Interval between heartbeats of an idle event stream")
+	flag.DurationVar(&cfg.stream.maxDuration, "events-stream-max-duration", 5*time.Minute, "Maximum duration of an event stream, after which the client reconnects (0 disables it)")
+	flag.DurationVar(&cfg.stream.pollInterval, "events-stream-poll-interval", time.Second, "Interval between polls of the events sent by the relay, with postgres storage")
+	flag.DurationVar(&cfg.webhooks.pollInterval, "webhook-poll-interval", time.Second, "Interval between polls of the pending webhook deliveries")
+	flag.IntVar(&cfg.webhooks.batchSize, "webhook-batch-size", 50, "Maximum number of webhook deliveries attempted per poll")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Maximum duration of a webhook delivery request")
+	flag.IntVar(&cfg.webhooks.maxFailures, "webhook-max-failures", 10, "Consecutive failed deliveries after which a webhook is disabled")
+	flag.DurationVar(&cfg.webhooks.minBackoff, "webhook-min-backoff", 5*time.Second, "Delay before retrying a failed webhook delivery")
+	flag.DurationVar(&cfg.webhooks.maxBackoff, "webhook-max-backoff", time.Hour, "Maximum delay between retries of a failed webhook delivery")
+	flag.BoolVar(&cfg.webhooks.allowPrivate, "webhook-allow-private", false, "Allow webhook deliveries to loopback, private and link-local addresses")
+	flag.DurationVar(&cfg.webhooks.retention, "webhook-delivery-retention", 30*24*time.Hour, "Time delivered webhook deliveries are kept before being pruned (0 disables pruning)")
+	flag.DurationVar(&cfg.webhooks.pruneInterval, "webhook-prune-interval", time.Hour, "Interval between prunes of the delivered webhook deliveries")
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "Time deleted companies are kept in the trash before being purged (0 disables purging)")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "Interval between purges of the trash")
+	flag.IntVar(&cfg.cache.size, "cache-size", 10000, "Maximum number of companies in the read cache (0 disables the cache)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 30*time.Second, "Time companies are kept in the read cache")
+	flag.StringVar(&cfg.attributes.schema, "attributes-schema", os.Getenv("CMPSRV_ATTRIBUTES_SCHEMA"), "JSON Schema file validating the company attributes (empty accepts any attributes)")
 	flag.Parse()
+	if cfg.cursor.secret == "" {
+		cfg.cursor.secret = cfg.jwt.secret
+	}
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	db, err := openDB(cfg)
+	if err := cfg.validate(); err != nil {
+		logger.Fatal(err)
+	}
+	mode, err := cloudevents.ParseMode(*eventsMode)
 	if err != nil {
 		logger.Fatal(err)
 	}
-	defer db.Close()
-	logger.Printf("database connection pool established")
-	// Initialize a new instance of application containing the dependencies.
-	kafkaClient, err := initKafkaClient(cfg.kafka.brokers, cfg.kafka.topic)
+	cfg.events.mode = mode
+	var attributesSchema *jsonschema.Schema
+	if cfg.attributes.schema != "" {
+		schema, err := jsonschema.Load(cfg.attributes.schema)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		attributesSchema = schema
+	}
+	company, outbox, webhooks, closeStorage, err := openStorage(cfg, logger)
 	if err != nil {
-		logger.Println(err)
+		logger.Fatal(err)
 	}
+	hub := changes.NewHub()
+	if cfg.cache.size > 0 {
+		companyCache := cache.New(cfg.cache.size, cfg.cache.ttl)
+		company = newCachedCompanyRepository(company, companyCache)
+		hub.Subscribe(invalidateOnChange(companyCache))
+		expvar.Publish("company_cache", expvar.Func(func() interface{} {
+			return companyCache.Stats()
+		}))
+	}
+	eventPublisher, err := openPublisher(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	// The events are always relayed to the event stream and to the webhooks, which are
+	// subscribed to through the API. With the postgres storage, the relay runs on a
+	// single instance at a time, so the event stream of every instance is fed with the
+	// events sent by the relay instead.
+	broker := stream.NewBroker(cfg.stream.bufferSize)
+	sinks := publisher.FanOut{&webhookPublisher{webhooks: webhooks}, eventPublisher}
+	if cfg.storage != "postgres" {
+		sinks = append(publisher.FanOut{broker}, sinks...)
+	}
+	eventPublisher = sinks
+	// Initialize a new instance of application containing the dependencies.
 	app := &application{
-		config:      cfg,
-		logger:      logger,
-		company:     data.NewCompanyModel(db),
-		eventChan:   make(chan data.EventRecord, 100),
-		KafkaClient: kafkaClient,
-		lock:        sync.Mutex{},
+		config:           cfg,
+		logger:           logger,
+		company:          company,
+		outbox:           outbox,
+		publisher:        eventPublisher,
+		webhooks:         webhooks,
+		stream:           broker,
+		webhookClient:    newWebhookClient(cfg.webhooks.timeout, cfg.webhooks.allowPrivate),
+		changes:          hub,
+		attributesSchema: attributesSchema,
 	}
 	// Initialize a new HTTP server.
 	srv := &http.Server{
@@ -92,8 +278,12 @@ func main() {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	// The event streams never become idle, so they are ended for the shutdown to
+	// complete.
+	srv.RegisterOnShutdown(func() { broker.Close() })
 
 	shutdownError := make(chan error)
+	done := make(chan struct{})
 	// Start a background goroutine that listens for SIGINT and SIGTERM signals
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -105,24 +295,110 @@ func main() {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		shutdownError <- srv.Shutdown(ctx)
-		close(app.eventChan)
+		err := srv.Shutdown(ctx)
+		// Stop the background goroutines and wait for them to return.
+		close(done)
+		app.wg.Wait()
+		shutdownError <- err
 	}()
 
 	logger.Println("starting server", map[string]string{
 		"addr": srv.Addr,
 		"env":  cfg.env,
 	})
-	// Start a background goroutine that processes events.
-	// FIXME: close all goroutines and channels properly on shutdown. Use WaitGroup.
-	go app.processEvents()
+	// Start a background goroutine that relays the outbox events to their sinks.
+	app.wg.Add(1)
+	go app.relayOutbox(done)
+	// Start a background goroutine that delivers the events to the webhooks.
+	app.wg.Add(1)
+	go app.deliverWebhooks(done)
+	if cfg.outbox.retention > 0 {
+		// Start a background goroutine that prunes the sent outbox events.
+		app.wg.Add(1)
+		go app.pruneOutbox(done)
+	}
+	if cfg.webhooks.retention > 0 {
+		// Start a background goroutine that prunes the delivered webhook deliveries.
+		app.wg.Add(1)
+		go app.pruneWebhookDeliveries(done)
+	}
+	if cfg.trash.retention > 0 {
+		// Start a background goroutine that purges the trash.
+		app.wg.Add(1)
+		go app.purgeDeletedCompanies(done)
+	}
+	if cfg.storage == "postgres" {
+		// Start a background goroutine that receives the company changes notified by
+		// the database.
+		listener, err := changes.NewListener(cfg.db.dsn, logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer listener.Close()
+		app.wg.Add(1)
+		go app.listenChanges(listener, done)
+		// Start a background goroutine that feeds the event stream with the events sent
+		// by the relay of any instance.
+		app.wg.Add(1)
+		go app.feedStream(outbox.(SentEventRepository), done)
+	}
 	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.Fatal(err)
+	}
+	err = <-shutdownError
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if err := eventPublisher.Close(); err != nil {
+		logger.Println(err)
+	}
+	if err := closeStorage(); err != nil {
+		logger.Fatal(err)
+	}
+	logger.Printf("stopped server: %s", srv.Addr)
+}
 
-	logger.Fatal(err)
+// openStorage returns the company, outbox and webhook repositories of the configured
+// storage backend, along with the function releasing it on shutdown. The memory
+// storage is loaded from its snapshot file if one is configured, and saved back to it
+// on close.
+func openStorage(cfg config, logger *log.Logger) (CompanyRepository, OutboxRepository, WebhookRepository, func() error, error) {
+	switch cfg.storage {
+	case "postgres":
+		db, err := openDB(cfg)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		logger.Printf("database connection pool established")
+		if err := checkSchema(db); err != nil {
+			db.Close()
+			return nil, nil, nil, nil, err
+		}
+		return data.NewCompanyModel(db), data.NewOutboxModel(db), data.NewWebhookModel(db), db.Close, nil
+	case "memory":
+		if cfg.memory.snapshot == "" {
+			logger.Printf("using memory storage")
+			model := data.NewMemoryModel()
+			return model, model, model, func() error { return nil }, nil
+		}
+		model, err := data.LoadMemoryModel(cfg.memory.snapshot)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		logger.Printf("using memory storage with snapshot %s", cfg.memory.snapshot)
+		return model, model, model, func() error { return model.Save(cfg.memory.snapshot) }, nil
+	default:
+		return nil, nil, nil, nil, fmt.Errorf("invalid storage %q: must be postgres or memory", cfg.storage)
+	}
 }
 
 func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	dsn, err := withStatementTimeout(cfg.db.dsn, cfg.db.statementTimeout)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -142,46 +418,61 @@ func openDB(cfg config) (*sql.DB, error) {
 	return db, nil
 }
 
-// processEvents is a background goroutine that processes events.
-func (app *application) processEvents() {
-	var t string
-	for {
-		select {
-		case event := <-app.eventChan:
-			switch event.Type {
-			case data.CompanyCreated:
-				t = "created"
-			case data.CompanyDeleted:
-				t = "deleted"
-			case data.CompanyUpdated:
-				t = "updated"
-			}
-			message := fmt.Sprintf("company with id:[%s] %s at %s", event.ID, t, event.TimeStamp.Format(time.RFC3339))
-			app.logger.Println(message)
-			kafkaMessage, err := json.Marshal(event)
-			if err != nil {
-				app.logger.Println(err)
-			}
-			record := &kgo.Record{Value: kafkaMessage}
-			ctx := context.Background()
-			app.KafkaClient.Produce(ctx, record, func(_ *kgo.Record, err error) {
-				if err != nil {
-					fmt.Printf("record had a produce error: %v\n", err)
-				}
-			})
+// withStatementTimeout returns the DSN with the statement_timeout run-time parameter set,
+// so that Postgres cancels the statements running for longer than the timeout on every
+// connection of the pool. Both URL and key=value DSNs are supported.
+func withStatementTimeout(dsn string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return dsn, nil
+	}
+	value := strconv.FormatInt(timeout.Milliseconds(), 10)
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", err
 		}
+		q := u.Query()
+		q.Set("statement_timeout", value)
+		u.RawQuery = q.Encode()
+		return u.String(), nil
 	}
+	return strings.TrimSpace(dsn + " statement_timeout=" + value), nil
 }
 
-// initKafkaClient initializes a new kafka client.
-func initKafkaClient(kafkaBrokers string, topic string) (*kgo.Client, error) {
-	seeds := []string{kafkaBrokers}
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(seeds...),
-		kgo.DefaultProduceTopic(topic),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create kafka client: %v", err)
+// openPublisher returns the publisher of the configured sinks of the events, fanning
+// the events out when there are several.
+func openPublisher(cfg config) (publisher.Publisher, error) {
+	sinks := cfg.events.sinks
+	if sinks == "" {
+		sinks = "none"
+		if cfg.kafka.brokers != "" {
+			sinks = "kafka"
+		}
+	}
+	var publishers publisher.FanOut
+	for _, sink := range strings.Split(sinks, ",") {
+		var p publisher.Publisher
+		var err error
+		switch strings.TrimSpace(sink) {
+		case "kafka":
+			p, err = publisher.NewKafka(cfg.kafka.brokers, cfg.kafka.topic, cfg.events.mode)
+		case "file":
+			p, err = publisher.NewFile(cfg.events.file, cfg.events.fileMaxSize, cfg.events.fileBackups)
+		case "stdout":
+			p = publisher.NewWriter(os.Stdout)
+		case "none":
+			p = publisher.Nop{}
+		default:
+			err = fmt.Errorf("invalid events sink %q: must be kafka, file, stdout or none", sink)
+		}
+		if err != nil {
+			publishers.Close()
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	if len(publishers) == 1 {
+		return publishers[0], nil
 	}
-	return cl, nil
+	return publishers, nil
 }
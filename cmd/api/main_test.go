@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWithStatementTimeout tests the withStatementTimeout function.
+func TestWithStatementTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		timeout time.Duration
+		want    string
+	}{
+		{"URL DSN", "postgres://user:pass@db:5432/companysrv?sslmode=disable", 5 * time.Second,
+			"postgres://user:pass@db:5432/companysrv?sslmode=disable&statement_timeout=5000"},
+		{"Key value DSN", "host=db dbname=companysrv", 1500 * time.Millisecond, "host=db dbname=companysrv statement_timeout=1500"},
+		{"Disabled", "postgres://db/companysrv", 0, "postgres://db/companysrv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withStatementTimeout(tt.dsn, tt.timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestConfigValidate tests that the settings which would fail once used are rejected.
+func TestConfigValidate(t *testing.T) {
+	valid := func() config {
+		var cfg config
+		cfg.outbox.maxAttempts = 20
+		cfg.outbox.retention = 7 * 24 * time.Hour
+		cfg.outbox.pruneInterval = time.Hour
+		cfg.stream.heartbeat = 15 * time.Second
+		cfg.stream.maxDuration = 5 * time.Minute
+		cfg.stream.pollInterval = time.Second
+		cfg.webhooks.pollInterval = time.Second
+		cfg.webhooks.batchSize = 50
+		cfg.webhooks.timeout = 10 * time.Second
+		cfg.webhooks.retention = 30 * 24 * time.Hour
+		cfg.webhooks.pruneInterval = time.Hour
+		return cfg
+	}
+	tests := []struct {
+		name    string
+		modify  func(*config)
+		wantErr string
+	}{
+		{"Valid", func(cfg *config) {}, ""},
+		{"Unlimited stream", func(cfg *config) { cfg.stream.maxDuration = 0 }, ""},
+		{"No outbox attempts", func(cfg *config) { cfg.outbox.maxAttempts = 0 }, "-outbox-max-attempts"},
+		{"Negative outbox retention", func(cfg *config) { cfg.outbox.retention = -time.Hour }, "-outbox-retention"},
+		{"No outbox prune interval", func(cfg *config) { cfg.outbox.pruneInterval = 0 }, "-outbox-prune-interval"},
+		{"No outbox pruning", func(cfg *config) { cfg.outbox.retention, cfg.outbox.pruneInterval = 0, 0 }, ""},
+		{"No heartbeat", func(cfg *config) { cfg.stream.heartbeat = 0 }, "-events-stream-heartbeat"},
+		{"Negative heartbeat", func(cfg *config) { cfg.stream.heartbeat = -time.Second }, "-events-stream-heartbeat"},
+		{"Negative stream duration", func(cfg *config) { cfg.stream.maxDuration = -time.Second }, "-events-stream-max-duration"},
+		{"No stream poll interval", func(cfg *config) { cfg.stream.pollInterval = 0 }, "-events-stream-poll-interval"},
+		{"No webhook poll interval", func(cfg *config) { cfg.webhooks.pollInterval = 0 }, "-webhook-poll-interval"},
+		{"No webhook batch", func(cfg *config) { cfg.webhooks.batchSize = 0 }, "-webhook-batch-size"},
+		{"No webhook timeout", func(cfg *config) { cfg.webhooks.timeout = 0 }, "-webhook-timeout"},
+		{"Negative webhook delivery retention", func(cfg *config) { cfg.webhooks.retention = -time.Hour }, "-webhook-delivery-retention"},
+		{"No webhook prune interval", func(cfg *config) { cfg.webhooks.pruneInterval = 0 }, "-webhook-prune-interval"},
+		{"No webhook pruning", func(cfg *config) { cfg.webhooks.retention, cfg.webhooks.pruneInterval = 0, 0 }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+			err := cfg.validate()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("want no error; got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("want an error about %s; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestRunMigrateUsage tests that invalid migrate commands are rejected before
+// connecting to the database.
+func TestRunMigrateUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"No command", []string{}},
+		{"Unknown command", []string{"sideways"}},
+		{"Goto without version", []string{"goto"}},
+		{"Goto with invalid version", []string{"goto", "latest"}},
+		{"Up with version", []string{"up", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			if err := runMigrate(tt.args, &out); err == nil {
+				t.Error("want error; got nil")
+			}
+		})
+	}
+}
+
+// TestOpenStorage tests the selection of the storage backend.
+func TestOpenStorage(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	var cfg config
+	cfg.storage = "memory"
+	cfg.memory.snapshot = filepath.Join(t.TempDir(), "companies.json")
+	company, outbox, webhooks, closeStorage, err := openStorage(cfg, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if company == nil || outbox == nil || webhooks == nil {
+		t.Fatal("want the memory repositories")
+	}
+	if err := closeStorage(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cfg.memory.snapshot); err != nil {
+		t.Errorf("want the snapshot to be saved on close; got %v", err)
+	}
+
+	cfg.storage = "sqlite"
+	if _, _, _, _, err := openStorage(cfg, logger); err == nil {
+		t.Error("want error for an unknown storage; got nil")
+	}
+}
+
+// TestOpenPublisher tests the selection of the sinks of the events.
+func TestOpenPublisher(t *testing.T) {
+	var cfg config
+	cfg.events.file = filepath.Join(t.TempDir(), "events.ndjson")
+	cfg.events.fileMaxSize = 1 << 20
+	tests := []struct {
+		sinks   string
+		brokers string
+		want    string
+		wantErr bool
+	}{
+		{"", "", "publisher.Nop", false},
+		{"", "kafka:9092", "*publisher.Kafka", false},
+		{"stdout", "", "*publisher.Writer", false},
+		{"file, stdout", "", "publisher.FanOut", false},
+		{"kafka", "", "", true},
+		{"stdout,queue", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sinks, func(t *testing.T) {
+			cfg.events.sinks, cfg.kafka.brokers, cfg.kafka.topic = tt.sinks, tt.brokers, "companies"
+			p, err := openPublisher(cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("want error; got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if got := fmt.Sprintf("%T", p); got != tt.want {
+				t.Errorf("want %s; got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/justinas/alice"
 	"net/http"
+	"time"
 )
 
 // authenticate is a middleware function which will be used to authenticate requests
@@ -43,8 +46,30 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// If JWT token is valid, call next handler
+		// If JWT token is valid, record the subject as the acting user and call next handler
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if subject, ok := claims["sub"].(string); ok {
+				r = app.contextSetActor(r, subject)
+			}
+		}
 		next.ServeHTTP(w, r)
 
 	})
 }
+
+// timeout returns a middleware function which sets a deadline on the request context,
+// so that the database queries made for a request are cancelled once the timeout has
+// elapsed or the client has gone away. A timeout of 0 sets no deadline.
+func (app *application) timeout(timeout time.Duration) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestTimeout tests that the timeout middleware sets the request deadline.
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		wantDeadline bool
+	}{
+		{"Timeout configured", time.Second, true},
+		{"Timeout disabled", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			var hasDeadline bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, hasDeadline = r.Context().Deadline()
+			})
+			app.timeout(tt.timeout)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if hasDeadline != tt.wantDeadline {
+				t.Errorf("want deadline %t; got %t", tt.wantDeadline, hasDeadline)
+			}
+		})
+	}
+}
+
+// TestServerErrorResponse tests that timeouts are reported with a distinct status code.
+func TestServerErrorResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{"Deadline exceeded", context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{"Wrapped deadline exceeded", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"Other error", errors.New("connection refused"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			rr := httptest.NewRecorder()
+			app.serverErrorResponse(rr, httptest.NewRequest(http.MethodGet, "/", nil), tt.err)
+			if rr.Code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rr.Code)
+			}
+		})
+	}
+}
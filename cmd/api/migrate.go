@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mborgnolo/companyservice/internal/migrate"
+	"mborgnolo/companyservice/migrations"
+	"os"
+	"strconv"
+	"time"
+)
+
+const migrateUsage = `usage: companysrv migrate [flags] up|down|status|goto VERSION
+
+  up          apply all the pending migrations
+  down        revert the last applied migration
+  status      list the migrations and when they have been applied
+  goto N      apply or revert the migrations to reach version N
+
+flags:
+`
+
+// runMigrate runs the migrate subcommand with the given arguments, writing
+// its output to w.
+func runMigrate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(w)
+	dsn := fs.String("db-dsn", os.Getenv("DB_DSN"), "PostgreSQL DSN")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Maximum duration of the migration")
+	fs.Usage = func() {
+		fmt.Fprint(w, migrateUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	command := fs.Arg(0)
+	var target int64
+	switch {
+	case command == "goto" && fs.NArg() == 2:
+		var err error
+		target, err = strconv.ParseInt(fs.Arg(1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid version %q", fs.Arg(1))
+		}
+	case (command == "up" || command == "down" || command == "status") && fs.NArg() == 1:
+	default:
+		fs.Usage()
+		return errors.New("invalid migrate command")
+	}
+
+	db, err := sql.Open("postgres", *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var n int
+	switch command {
+	case "up":
+		n, err = m.Up(ctx)
+	case "down":
+		n, err = m.Down(ctx)
+	case "goto":
+		n, err = m.Goto(ctx, target)
+	case "status":
+		return printMigrationStatus(ctx, m, w)
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Fprintln(w, "no change")
+	} else {
+		fmt.Fprintf(w, "%d migrations run\n", n)
+	}
+	return printMigrationStatus(ctx, m, w)
+}
+
+func printMigrationStatus(ctx context.Context, m *migrate.Migrator, w io.Writer) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%06d  %-30s  %s\n", s.Version, s.Name, appliedAt)
+	}
+	return nil
+}
+
+// checkSchema returns an error if migrations are pending, so that the service
+// does not serve requests against a schema older than the one it expects.
+func checkSchema(db *sql.DB) error {
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind: %d migrations pending starting from %06d_%s, run `companysrv migrate up`",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
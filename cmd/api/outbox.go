@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"strconv"
+	"time"
+)
+
+// OutboxRepository gives access to the events recorded in the outbox by the
+// company mutations.
+type OutboxRepository interface {
+	LockRelay(ctx context.Context) (unlock func(), ok bool, err error)
+	GetPendingEvents(ctx context.Context, limit int) ([]*data.OutboxEvent, error)
+	MarkEventSent(ctx context.Context, id int64) error
+	MarkEventFailed(ctx context.Context, id int64, nextAttempt time.Time, cause error, maxAttempts int) (bool, error)
+	DeleteSentEvents(ctx context.Context, sentBefore time.Time) (int64, error)
+}
+
+// relayOutbox is a background goroutine that relays the events recorded in the
+// outbox to the publisher until done is closed. Events are published in the order
+// they were recorded: when the delivery of an event fails, the following events wait
+// for it to be retried, with an exponential backoff, until it is dead after the maximum
+// number of attempts and set aside. An event is only marked as sent once
+// the publisher has accepted it, which gives at-least-once delivery across restarts. A
+// single instance relays the events at a time, the one holding the lock of the relay.
+func (app *application) relayOutbox(done <-chan struct{}) {
+	defer app.wg.Done()
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			app.relayPendingEvents()
+		}
+	}
+}
+
+// relayPendingEvents publishes the batch of pending outbox events which are due.
+func (app *application) relayPendingEvents() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	unlock, ok, err := app.outbox.LockRelay(ctx)
+	if err != nil {
+		app.logger.Println(err)
+		return
+	}
+	if !ok {
+		// Another instance relays the events.
+		return
+	}
+	defer unlock()
+	events, err := app.outbox.GetPendingEvents(ctx, app.config.outbox.batchSize)
+	if err != nil {
+		app.logger.Println(err)
+		return
+	}
+	for _, event := range events {
+		if event.NextAttemptAt.After(time.Now()) {
+			return
+		}
+		err = app.publishEvent(ctx, event)
+		if err != nil {
+			nextAttempt := time.Now().Add(app.outboxBackoff(event.Attempts))
+			app.logger.Printf("event %d for company with id:[%s] could not be published (attempt %d, next at %s): %v",
+				event.ID, event.CompanyID, event.Attempts+1, nextAttempt.Format(time.RFC3339), err)
+			dead, err := app.outbox.MarkEventFailed(ctx, event.ID, nextAttempt, err, app.config.outbox.maxAttempts)
+			if err != nil {
+				app.logger.Println(err)
+				return
+			}
+			if !dead {
+				return
+			}
+			// The dead event no longer holds back the following ones.
+			app.logger.Printf("event %d for company with id:[%s] is dead after %d attempts", event.ID, event.CompanyID, app.config.outbox.maxAttempts)
+			continue
+		}
+		if err := app.outbox.MarkEventSent(ctx, event.ID); err != nil {
+			app.logger.Println(err)
+			return
+		}
+		app.logger.Printf("event %s for company with id:[%s] recorded at %s sent", event.Type, event.CompanyID, event.CreatedAt.Format(time.RFC3339))
+	}
+}
+
+// pruneOutbox is a background goroutine that permanently removes the events sent for
+// longer than the retention period until done is closed, so that the outbox does not
+// grow without limit. The sent events are kept for a while for the event streams of
+// the instances, which are fed with them.
+func (app *application) pruneOutbox(done <-chan struct{}) {
+	defer app.wg.Done()
+	ticker := time.NewTicker(app.config.outbox.pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := app.outbox.DeleteSentEvents(ctx, time.Now().Add(-app.config.outbox.retention))
+			cancel()
+			if err != nil {
+				app.logger.Println(err)
+				continue
+			}
+			if n > 0 {
+				app.logger.Printf("pruned %d outbox events sent more than %s ago", n, app.config.outbox.retention)
+			}
+		}
+	}
+}
+
+// cloudEventTypes are the CloudEvents types of the outbox event types.
+var cloudEventTypes = map[string]string{
+	data.EventType(data.CompanyCreated).String():  cloudevents.TypeCompanyCreated,
+	data.EventType(data.CompanyUpdated).String():  cloudevents.TypeCompanyUpdated,
+	data.EventType(data.CompanyDeleted).String():  cloudevents.TypeCompanyDeleted,
+	data.EventType(data.CompanyRestored).String(): cloudevents.TypeCompanyRestored,
+}
+
+// eventTypeName returns the outbox event type of a CloudEvents type.
+func eventTypeName(cloudEventType string) (string, bool) {
+	for eventType, t := range cloudEventTypes {
+		if t == cloudEventType {
+			return eventType, true
+		}
+	}
+	return "", false
+}
+
+// cloudEvent returns the CloudEvent of an outbox event, whose data is the JSON encoded
+// EventRecord. The outbox ID of the event identifies it, so that the consumers can
+// recognise the events delivered more than once.
+func (app *application) cloudEvent(event *data.OutboxEvent) (*cloudevents.Event, error) {
+	eventType, ok := cloudEventTypes[event.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", event.Type)
+	}
+	return &cloudevents.Event{
+		ID:              strconv.FormatInt(event.ID, 10),
+		Source:          app.config.events.source,
+		SpecVersion:     cloudevents.SpecVersion,
+		Type:            eventType,
+		Subject:         event.CompanyID.String(),
+		Time:            event.CreatedAt,
+		DataContentType: "application/json",
+		Data:            event.Payload,
+	}, nil
+}
+
+// publishEvent synchronously publishes the event as a CloudEvent.
+func (app *application) publishEvent(ctx context.Context, event *data.OutboxEvent) error {
+	ce, err := app.cloudEvent(event)
+	if err != nil {
+		return err
+	}
+	return app.publisher.Publish(ctx, ce)
+}
+
+// outboxBackoff returns the delay before the next delivery attempt of an event
+// which already failed the given number of times.
+func (app *application) outboxBackoff(attempts int) time.Duration {
+	return backoff(app.config.outbox.minBackoff, app.config.outbox.maxBackoff, attempts)
+}
+
+// backoff returns the exponential backoff delay, from min up to max, before the next
+// attempt of an operation which already failed the given number of times.
+func backoff(min, max time.Duration, attempts int) time.Duration {
+	delay := min
+	for i := 0; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/mocks"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"testing"
+	"time"
+)
+
+// TestRelayPendingEvents tests that the events are marked as sent once published,
+// and kept in the outbox when they cannot be published.
+func TestRelayPendingEvents(t *testing.T) {
+	app := newTestApplication(t)
+	outbox := app.outbox.(*mocks.OutboxModel)
+	publisher := app.publisher.(*mocks.Publisher)
+
+	// The events are not relayed while another instance holds the lock.
+	outbox.Locked = true
+	app.relayPendingEvents()
+	if len(outbox.Sent)+len(outbox.Failed) != 0 || len(publisher.Events) != 0 {
+		t.Errorf("want no event relayed; got %v sent, %v failed", outbox.Sent, outbox.Failed)
+	}
+	outbox.Locked = false
+
+	publisher.Err = errors.New("broker unavailable")
+	app.relayPendingEvents()
+	if len(outbox.Sent) != 0 {
+		t.Errorf("want no event sent; got %v", outbox.Sent)
+	}
+	if len(outbox.Failed) != 1 || outbox.Failed[0] != 1 {
+		t.Errorf("want event 1 failed; got %v", outbox.Failed)
+	}
+
+	publisher.Err = nil
+	app.relayPendingEvents()
+	if len(outbox.Sent) != 1 || outbox.Sent[0] != 1 {
+		t.Errorf("want event 1 sent; got %v", outbox.Sent)
+	}
+	if len(publisher.Events) != 1 || publisher.Events[0].Type != cloudevents.TypeCompanyCreated {
+		t.Errorf("want the CompanyCreated event published; got %+v", publisher.Events)
+	}
+}
+
+// rejectingPublisher is a publisher rejecting the event with the given ID, and
+// recording the IDs of the other events it publishes.
+type rejectingPublisher struct {
+	rejected  string
+	published []string
+}
+
+func (p *rejectingPublisher) Publish(ctx context.Context, event *cloudevents.Event) error {
+	if event.ID == p.rejected {
+		return errors.New("event rejected")
+	}
+	p.published = append(p.published, event.ID)
+	return nil
+}
+
+func (p *rejectingPublisher) Close() error {
+	return nil
+}
+
+// TestRelayDeadEvents tests that an event which is always rejected holds back the
+// following events until it is dead after the maximum number of attempts.
+func TestRelayDeadEvents(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.outbox.minBackoff = 0
+	app.config.outbox.maxBackoff = 0
+	outbox := data.NewMemoryModel()
+	app.outbox = outbox
+	for _, name := range []string{"Rejected", "Held Back"} {
+		registered := true
+		if _, err := outbox.CreateCompany(context.Background(), &data.Company{Name: name, Employees: 1, Registered: &registered, Type: "NonProfit"}, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publisher := &rejectingPublisher{rejected: "1"}
+	app.publisher = publisher
+
+	for i := 1; i < app.config.outbox.maxAttempts; i++ {
+		app.relayPendingEvents()
+		if len(publisher.published) != 0 {
+			t.Fatalf("attempt %d: want the following events held back; got %v published", i, publisher.published)
+		}
+	}
+	app.relayPendingEvents()
+	if len(publisher.published) != 1 || publisher.published[0] != "2" {
+		t.Errorf("want event 2 published once event 1 is dead; got %v", publisher.published)
+	}
+	if events, _ := outbox.GetPendingEvents(context.Background(), 10); len(events) != 0 {
+		t.Errorf("want no pending event; got %+v", events)
+	}
+}
+
+// TestCloudEvent tests that the outbox events are encoded as CloudEvents which the
+// consumers can decode in both content modes.
+func TestCloudEvent(t *testing.T) {
+	app := newTestApplication(t)
+	events, err := app.outbox.GetPendingEvents(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []cloudevents.Mode{cloudevents.Structured, cloudevents.Binary} {
+		t.Run(string(mode), func(t *testing.T) {
+			ce, err := app.cloudEvent(events[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			record, err := ce.Record(mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cloudevents.Decode(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != "1" || got.Source != "/companyservice" || got.Type != cloudevents.TypeCompanyCreated ||
+				got.Subject != "dc152cf7-cc4b-4555-8d4c-1878e5b9262c" || got.DataContentType != "application/json" {
+				t.Errorf("unexpected event %+v", got)
+			}
+			var payload struct{ ID string }
+			if err := got.DecodeData(&payload); err != nil || payload.ID != got.Subject {
+				t.Errorf("want the event record as data; got %+v, %v", payload, err)
+			}
+		})
+	}
+}
+
+// TestOutboxBackoff tests the outboxBackoff function.
+func TestOutboxBackoff(t *testing.T) {
+	app := newTestApplication(t)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{3, 8 * time.Second},
+		{6, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := app.outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("attempts %d: want %s; got %s", tt.attempts, tt.want, got)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"expvar"
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	"net/http"
@@ -8,16 +9,72 @@ import (
 
 // This function is used to create a new router instance and register all the application routes.
 // It also registers a middleware function (app.authenticate) that will be called before any of the
-// handlers used for mutating operation are executed.
+// handlers used for mutating operation are executed, and a middleware function (app.timeout) that
+// sets the deadline of every request, according to its route. The trash, the export and the
+// webhooks are also restricted to authenticated users.
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
-	standardMiddleware := alice.New()
+	standardMiddleware := alice.New(app.timeout(app.config.requestTimeout))
+	// Exports, which stream the whole company table, get the export timeout instead of
+	// the request timeout, and event streams their maximum duration.
+	exportMiddleware := alice.New(app.timeout(app.config.exportTimeout))
+	streamMiddleware := alice.New(app.timeout(app.config.stream.maxDuration))
 
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/company/:id", app.GetCompanyHandler)
+	router.Handler(http.MethodGet, "/v1/healthcheck", standardMiddleware.ThenFunc(app.healthcheckHandler))
+	router.Handler(http.MethodGet, "/debug/vars", standardMiddleware.Then(expvar.Handler()))
+	router.Handler(http.MethodGet, "/v1/company", standardMiddleware.ThenFunc(app.ListCompaniesHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id", app.staticSegments(standardMiddleware.ThenFunc(app.GetCompanyHandler), map[string]http.Handler{
+		"search":       standardMiddleware.ThenFunc(app.SearchCompaniesHandler),
+		"trash":        standardMiddleware.Append(app.authenticate).ThenFunc(app.ListDeletedCompaniesHandler),
+		"export":       exportMiddleware.Append(app.authenticate).ThenFunc(app.ExportCompaniesHandler),
+		"by-attribute": standardMiddleware.ThenFunc(app.ListCompaniesByAttributeHandler),
+	}))
 	router.Handler(http.MethodPost, "/v1/company", standardMiddleware.Append(app.authenticate).ThenFunc(app.CreateCompanyHandler))
+	router.Handler(http.MethodPost, "/v1/company/:id", app.staticSegments(standardMiddleware.ThenFunc(app.methodNotAllowedResponse), map[string]http.Handler{
+		"import": standardMiddleware.Append(app.authenticate).ThenFunc(app.ImportCompaniesHandler),
+	}))
 	router.Handler(http.MethodPatch, "/v1/company/:id", standardMiddleware.Append(app.authenticate).ThenFunc(app.UpdateCompanyHandler))
 	router.Handler(http.MethodDelete, "/v1/company/:id", standardMiddleware.Append(app.authenticate).ThenFunc(app.DeleteCompanyHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	return standardMiddleware.Then(router)
+	router.Handler(http.MethodPost, "/v1/company/:id/restore", standardMiddleware.Append(app.authenticate).ThenFunc(app.RestoreCompanyHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id/history", standardMiddleware.ThenFunc(app.ListCompanyRevisionsHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id/history/:revision", standardMiddleware.ThenFunc(app.GetCompanyRevisionHandler))
+	router.Handler(http.MethodPost, "/v1/company/:id/rollback/:revision", standardMiddleware.Append(app.authenticate).ThenFunc(app.RollbackCompanyHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id/addresses", standardMiddleware.ThenFunc(app.ListCompanyAddressesHandler))
+	router.Handler(http.MethodPost, "/v1/company/:id/addresses", standardMiddleware.Append(app.authenticate).ThenFunc(app.CreateCompanyAddressHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id/addresses/:address", standardMiddleware.ThenFunc(app.GetCompanyAddressHandler))
+	router.Handler(http.MethodPatch, "/v1/company/:id/addresses/:address", standardMiddleware.Append(app.authenticate).ThenFunc(app.UpdateCompanyAddressHandler))
+	router.Handler(http.MethodDelete, "/v1/company/:id/addresses/:address", standardMiddleware.Append(app.authenticate).ThenFunc(app.DeleteCompanyAddressHandler))
+	router.Handler(http.MethodPut, "/v1/company/:id/tags/:tag", standardMiddleware.Append(app.authenticate).ThenFunc(app.PutCompanyTagHandler))
+	router.Handler(http.MethodDelete, "/v1/company/:id/tags/:tag", standardMiddleware.Append(app.authenticate).ThenFunc(app.DeleteCompanyTagHandler))
+	router.Handler(http.MethodPut, "/v1/company/:id/parent", standardMiddleware.Append(app.authenticate).ThenFunc(app.SetCompanyParentHandler))
+	router.Handler(http.MethodDelete, "/v1/company/:id/parent", standardMiddleware.Append(app.authenticate).ThenFunc(app.ClearCompanyParentHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id/subsidiaries", standardMiddleware.ThenFunc(app.ListSubsidiariesHandler))
+	router.Handler(http.MethodGet, "/v1/company/:id/ancestors", standardMiddleware.ThenFunc(app.ListAncestorsHandler))
+	router.Handler(http.MethodGet, "/v1/tags", standardMiddleware.ThenFunc(app.ListTagsHandler))
+	router.Handler(http.MethodGet, "/v1/tags/:tag/companies", standardMiddleware.ThenFunc(app.ListTagCompaniesHandler))
+	router.Handler(http.MethodGet, "/v1/events/stream", streamMiddleware.ThenFunc(app.StreamEventsHandler))
+	router.Handler(http.MethodGet, "/v1/webhooks", standardMiddleware.Append(app.authenticate).ThenFunc(app.ListWebhooksHandler))
+	router.Handler(http.MethodPost, "/v1/webhooks", standardMiddleware.Append(app.authenticate).ThenFunc(app.CreateWebhookHandler))
+	router.Handler(http.MethodGet, "/v1/webhooks/:id", standardMiddleware.Append(app.authenticate).ThenFunc(app.GetWebhookHandler))
+	router.Handler(http.MethodPatch, "/v1/webhooks/:id", standardMiddleware.Append(app.authenticate).ThenFunc(app.UpdateWebhookHandler))
+	router.Handler(http.MethodDelete, "/v1/webhooks/:id", standardMiddleware.Append(app.authenticate).ThenFunc(app.DeleteWebhookHandler))
+	router.Handler(http.MethodGet, "/v1/webhooks/:id/deliveries", standardMiddleware.Append(app.authenticate).ThenFunc(app.ListWebhookDeliveriesHandler))
+	router.Handler(http.MethodPost, "/v1/tokens/authentication", standardMiddleware.ThenFunc(app.createAuthenticationTokenHandler))
+	return router
+}
+
+// staticSegments dispatches the requests whose id parameter matches one of the static
+// path segments to the corresponding handler, and all the other requests to next.
+// httprouter does not allow a static segment such as /v1/company/search to be
+// registered next to the /v1/company/:id parameter, so static routes at that
+// position are registered through this function.
+func (app *application) staticSegments(next http.Handler, static map[string]http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if handler, ok := static[params.ByName("id")]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
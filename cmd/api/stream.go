@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"net/http"
+	"time"
+)
+
+// streamRetry is the delay after which the clients reconnect to an ended stream.
+const streamRetry = time.Second
+
+// SentEventRepository gives access to the outbox events sent by the relay, whichever
+// instance of the service holds it.
+type SentEventRepository interface {
+	GetSentEvents(ctx context.Context, after *data.OutboxEvent, limit int) ([]*data.OutboxEvent, error)
+}
+
+// feedStream is a background goroutine that publishes the events sent by the relay to
+// the event stream until done is closed, so that the clients of every instance receive
+// every event, in the same order, whichever instance relays them. It starts with the
+// last events sent, which are buffered for the clients which reconnect.
+func (app *application) feedStream(sent SentEventRepository, done <-chan struct{}) {
+	defer app.wg.Done()
+	ticker := time.NewTicker(app.config.stream.pollInterval)
+	defer ticker.Stop()
+	var last *data.OutboxEvent
+	for {
+		last = app.feedSentEvents(sent, last)
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// feedSentEvents publishes the events sent after last to the event stream, or the
+// last events sent up to the size of its buffer if last is nil, and returns the last
+// event published.
+func (app *application) feedSentEvents(sent SentEventRepository, last *data.OutboxEvent) *data.OutboxEvent {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for {
+		limit := app.config.outbox.batchSize
+		if last == nil && app.config.stream.bufferSize > 0 {
+			limit = app.config.stream.bufferSize
+		}
+		events, err := sent.GetSentEvents(ctx, last, limit)
+		if err != nil {
+			app.logger.Println(err)
+			return last
+		}
+		for _, event := range events {
+			last = event
+			ce, err := app.cloudEvent(event)
+			if err != nil {
+				app.logger.Println(err)
+				continue
+			}
+			if err := app.stream.Publish(ctx, ce); err != nil {
+				app.logger.Println(err)
+			}
+		}
+		if len(events) < limit {
+			return last
+		}
+	}
+}
+
+// eventFilter returns the filter of the events of the given types, every type if
+// empty, and of the given company, every company if uuid.Nil.
+func eventFilter(eventTypes []string, companyID uuid.UUID) func(*cloudevents.Event) bool {
+	types := make(map[string]bool)
+	for _, eventType := range eventTypes {
+		types[cloudEventTypes[eventType]] = true
+	}
+	return func(event *cloudevents.Event) bool {
+		return (len(types) == 0 || types[event.Type]) && (companyID == uuid.Nil || event.Subject == companyID.String())
+	}
+}
+
+// writeServerSentEvent writes the event in the text/event-stream format, with its
+// outbox ID, its event type and its EventRecord as data.
+func writeServerSentEvent(w io.Writer, event *cloudevents.Event) error {
+	eventType, _ := eventTypeName(event.Type)
+	if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\n", event.ID, eventType); err != nil {
+		return err
+	}
+	// Every line of the data gets its own field, although JSON payloads are usually on
+	// a single line.
+	for _, line := range bytes.Split(event.Data, []byte("\n")) {
+		if _, err := fmt.Fprintf(w, "data: %s\n", line); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// StreamEventsHandler streams the company events as Server-Sent Events, optionally
+// filtered by a comma separated list of event types and by company_id. A client
+// reconnecting with the Last-Event-ID header, or the last_event_id query parameter,
+// first receives the buffered events it missed; if they are no longer buffered, it
+// receives a resync event, telling it to reload the companies. A comment is sent as a
+// heartbeat when no event has been sent for a while, so that idle connections are not
+// closed by proxies; it also moves the last event ID of the client past the events it
+// filtered out, so that it resumes from there. The stream ends after the maximum
+// stream duration, when the client lags too far behind or when the server shuts down;
+// the client then reconnects.
+func (app *application) StreamEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(writer, request, errors.New("response writer does not support streaming"))
+		return
+	}
+	v := validator.New()
+	qs := request.URL.Query()
+	eventTypes := app.readCSV(qs, "type", []string{})
+	for _, eventType := range eventTypes {
+		v.Check(v.In(eventType, data.EventTypeNames...), "type", "must only contain: CompanyCreated, CompanyUpdated, CompanyDeleted, CompanyRestored")
+	}
+	var companyID uuid.UUID
+	if s := qs.Get("company_id"); s != "" {
+		id, err := uuid.Parse(s)
+		v.Check(err == nil, "company_id", "must be a valid UUID")
+		companyID = id
+	}
+	if !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	filter := eventFilter(eventTypes, companyID)
+	lastEventID := request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = qs.Get("last_event_id")
+	}
+	subscription, err := app.stream.Subscribe(lastEventID)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	defer subscription.Close()
+	if err := app.extendWriteDeadline(writer, app.config.stream.maxDuration); err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	// Proxies such as nginx must not buffer the stream.
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+
+	// sent is the last event ID known to the client, and position the ID of the last
+	// event it has been streamed, whether filtered out or not.
+	sent, position := lastEventID, lastEventID
+	if lastEventID == "" || subscription.Missed {
+		position = subscription.LatestEventID
+	}
+	// writeEvent writes the event if the client is interested in it.
+	writeEvent := func(event *cloudevents.Event) (bool, error) {
+		position = event.ID
+		if !filter(event) {
+			return false, nil
+		}
+		sent = event.ID
+		return true, writeServerSentEvent(writer, event)
+	}
+	// writePosition moves the last event ID of the client to the position, with a
+	// block without data, which updates it without dispatching an event.
+	writePosition := func() error {
+		if position == sent {
+			return nil
+		}
+		sent = position
+		_, err := fmt.Fprintf(writer, "id: %s\n\n", position)
+		return err
+	}
+
+	// The errors of the writes are those of a client which has gone away.
+	if _, err := fmt.Fprintf(writer, "retry: %d\n\n", streamRetry.Milliseconds()); err != nil {
+		return
+	}
+	if subscription.Missed {
+		if _, err := io.WriteString(writer, "event: resync\ndata: {}\n\n"); err != nil {
+			return
+		}
+	}
+	for _, event := range subscription.Replay {
+		if _, err := writeEvent(event); err != nil {
+			return
+		}
+	}
+	if err := writePosition(); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(app.config.stream.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event, ok := <-subscription.Events():
+			if !ok {
+				if subscription.Dropped() {
+					app.logger.Printf("event stream of %s dropped: too far behind", request.RemoteAddr)
+				}
+				return
+			}
+			written, err := writeEvent(event)
+			if err != nil {
+				return
+			}
+			if !written {
+				continue
+			}
+			heartbeat.Reset(app.config.stream.heartbeat)
+		case <-heartbeat.C:
+			if _, err := io.WriteString(writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := writePosition(); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
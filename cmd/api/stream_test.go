@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"github.com/google/uuid"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publishStreamEvent publishes an event of the company to the event stream.
+func publishStreamEvent(t *testing.T, app *application, id int64, eventType data.EventType, companyID uuid.UUID) {
+	t.Helper()
+	event, err := app.cloudEvent(&data.OutboxEvent{ID: id, CompanyID: companyID, Type: eventType.String(), Payload: []byte(`{"ID":"` + companyID.String() + `"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.stream.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openStream opens the event stream, and returns the channel of its blocks of lines,
+// closed when the stream ends.
+func openStream(t *testing.T, ts *httptest.Server, urlPath, lastEventID string) <-chan string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.StatusCode != http.StatusOK || rs.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("want a %d event stream; got %d %s", http.StatusOK, rs.StatusCode, rs.Header.Get("Content-Type"))
+	}
+	blocks := make(chan string, 100)
+	go func() {
+		defer close(blocks)
+		defer rs.Body.Close()
+		r := bufio.NewReader(rs.Body)
+		var block []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line = strings.TrimSuffix(line, "\n"); line != "" {
+				block = append(block, line)
+				continue
+			}
+			blocks <- strings.Join(block, "\n")
+			block = nil
+		}
+	}()
+	return blocks
+}
+
+// nextBlock returns the next block of the stream, skipping the heartbeats unless
+// heartbeat is true.
+func nextBlock(t *testing.T, blocks <-chan string, heartbeat bool) string {
+	t.Helper()
+	for {
+		select {
+		case block, ok := <-blocks:
+			if !ok {
+				t.Fatal("want a block; got the end of the stream")
+			}
+			if block == ": heartbeat" && !heartbeat {
+				continue
+			}
+			return block
+		case <-time.After(2 * time.Second):
+			t.Fatal("want a block; got none")
+		}
+	}
+}
+
+// TestStreamEvents tests that the events are streamed, filtered, resumed after the
+// last event ID and ended on shutdown.
+func TestStreamEvents(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	a, b := uuid.New(), uuid.New()
+	publishStreamEvent(t, app, 1, data.CompanyCreated, a)
+	publishStreamEvent(t, app, 2, data.CompanyCreated, b)
+
+	blocks := openStream(t, ts, "/v1/events/stream?type=CompanyDeleted,CompanyRestored&company_id="+a.String(), "1")
+	if got := nextBlock(t, blocks, false); got != "retry: 1000" {
+		t.Errorf("want the retry delay; got %q", got)
+	}
+	// The replayed event is filtered out, but the client moves past it.
+	if got := nextBlock(t, blocks, false); got != "id: 2" {
+		t.Errorf("want the position moved to 2; got %q", got)
+	}
+	publishStreamEvent(t, app, 3, data.CompanyDeleted, a)
+	want := "id: 3\nevent: CompanyDeleted\ndata: {\"ID\":\"" + a.String() + "\"}"
+	if got := nextBlock(t, blocks, false); got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+	publishStreamEvent(t, app, 4, data.CompanyCreated, a)
+	if got := nextBlock(t, blocks, true); got != ": heartbeat" {
+		t.Errorf("want a heartbeat; got %q", got)
+	}
+	if got := nextBlock(t, blocks, true); got != "id: 4" {
+		t.Errorf("want the position moved to 4 with the heartbeat; got %q", got)
+	}
+
+	// A client whose last event is no longer buffered is told to resynchronise.
+	resync := openStream(t, ts, "/v1/events/stream", "999")
+	nextBlock(t, resync, false)
+	if got := nextBlock(t, resync, false); got != "event: resync\ndata: {}" {
+		t.Errorf("want a resync event; got %q", got)
+	}
+	if got := nextBlock(t, resync, false); got != "id: 4" {
+		t.Errorf("want the position moved to the latest event; got %q", got)
+	}
+
+	// The streams end on shutdown.
+	app.stream.Close()
+	for _, stream := range []<-chan string{blocks, resync} {
+		select {
+		case <-closed(stream):
+		case <-time.After(2 * time.Second):
+			t.Error("want the stream ended on shutdown")
+		}
+	}
+}
+
+// closed returns a channel closed once the blocks have been drained.
+func closed(blocks <-chan string) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range blocks {
+		}
+		close(done)
+	}()
+	return done
+}
+
+// TestStreamEventsValidation tests that invalid filters are rejected.
+func TestStreamEventsValidation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantBody string
+	}{
+		{"Unknown type", "/v1/events/stream?type=CompanyCreated,CompanyMerged", "type"},
+		{"Invalid company", "/v1/events/stream?company_id=123", "company_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Get(ts.URL + tt.urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != http.StatusUnprocessableEntity {
+				t.Errorf("want %d; got %d", http.StatusUnprocessableEntity, rs.StatusCode)
+			}
+			if !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+// sentEvents is a stand-in for the outbox events sent by the relay, in the order they
+// were sent.
+type sentEvents []*data.OutboxEvent
+
+func (s *sentEvents) GetSentEvents(ctx context.Context, after *data.OutboxEvent, limit int) ([]*data.OutboxEvent, error) {
+	events := *s
+	if after == nil {
+		if len(events) > limit {
+			events = events[len(events)-limit:]
+		}
+		return events, nil
+	}
+	for i, event := range events {
+		if event.ID == after.ID {
+			events = events[i+1:]
+			break
+		}
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// TestFeedStream tests that the event stream is fed with the last events sent, then
+// with the events sent afterwards, in the order they were sent.
+func TestFeedStream(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.stream.bufferSize = 2
+	app.config.outbox.batchSize = 1
+	sent := &sentEvents{}
+	for _, id := range []int64{1, 2, 3} {
+		*sent = append(*sent, &data.OutboxEvent{ID: id, CompanyID: uuid.New(), Type: data.EventType(data.CompanyCreated).String()})
+	}
+
+	last := app.feedSentEvents(sent, nil)
+	if last == nil || last.ID != 3 {
+		t.Fatalf("want the last event fed to be 3; got %+v", last)
+	}
+	subscription, err := app.stream.Subscribe("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subscription.Close()
+	if subscription.Missed || len(subscription.Replay) != 1 || subscription.Replay[0].ID != "3" {
+		t.Errorf("want the last 2 events buffered; got missed %t, replay %+v", subscription.Missed, subscription.Replay)
+	}
+
+	// The events sent afterwards are fed in batches, after event 3 whatever their ID.
+	*sent = append(*sent, &data.OutboxEvent{ID: 5, CompanyID: uuid.New(), Type: data.EventType(data.CompanyDeleted).String()},
+		&data.OutboxEvent{ID: 4, CompanyID: uuid.New(), Type: data.EventType(data.CompanyUpdated).String()})
+	if last = app.feedSentEvents(sent, last); last.ID != 4 {
+		t.Errorf("want the last event fed to be 4; got %d", last.ID)
+	}
+	for _, want := range []string{"5", "4"} {
+		select {
+		case event := <-subscription.Events():
+			if event.ID != want {
+				t.Errorf("want event %s; got %s", want, event.ID)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("want event %s; got none", want)
+		}
+	}
+}
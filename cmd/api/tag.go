@@ -0,0 +1,126 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// readTagParam is a helper that reads the tag parameter from the request URL. Tags
+// are case insensitive, so the tag is returned in lower case.
+func (app *application) readTagParam(r *http.Request) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return strings.ToLower(params.ByName("tag"))
+}
+
+// writeTaggedCompany writes the company after a change of its tags, with its new version
+// as ETag, or the response of the error returned by the change.
+func (app *application) writeTaggedCompany(writer http.ResponseWriter, request *http.Request, status int, company *data.Company, err error) {
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+	headers := make(http.Header)
+	headers.Set("ETag", etag(company.Version))
+	err = app.writeJSON(writer, status, envelope{"company": company}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// PutCompanyTagHandler attaches the tag provided in the request URL to the company
+// identified by the ID provided in the request URL, and returns the company. The
+// response status is 201 Created if the tag has been added, and 200 OK if the
+// company already carried it.
+func (app *application) PutCompanyTagHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	tag := app.readTagParam(request)
+	v := validator.New()
+	if data.ValidateTag(v, tag); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	added, err := app.company.AddCompanyTag(request.Context(), id, tag, app.contextGetActor(request))
+	if err != nil {
+		app.writeTaggedCompany(writer, request, 0, nil, err)
+		return
+	}
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	company, err := app.company.GetCompany(request.Context(), id)
+	app.writeTaggedCompany(writer, request, status, company, err)
+}
+
+// DeleteCompanyTagHandler detaches the tag provided in the request URL from the
+// company identified by the ID provided in the request URL, and returns the company.
+// A 404 Not Found response is returned if the company does not carry the tag.
+func (app *application) DeleteCompanyTagHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	err = app.company.RemoveCompanyTag(request.Context(), id, app.readTagParam(request), app.contextGetActor(request))
+	if err != nil {
+		app.writeTaggedCompany(writer, request, 0, nil, err)
+		return
+	}
+	company, err := app.company.GetCompany(request.Context(), id)
+	app.writeTaggedCompany(writer, request, http.StatusOK, company, err)
+}
+
+// ListTagsHandler returns the tags carried by at least one company, sorted by name,
+// along with the number of companies carrying them.
+func (app *application) ListTagsHandler(writer http.ResponseWriter, request *http.Request) {
+	tags, err := app.company.GetTags(request.Context())
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"tags": tags}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// ListTagCompaniesHandler returns a page of the companies carrying the tag provided
+// in the request URL, sorted and paginated like by ListCompaniesHandler.
+func (app *application) ListTagCompaniesHandler(writer http.ResponseWriter, request *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := request.URL.Query()
+
+	query := data.CompanyQuery{Tag: app.readTagParam(request)}
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "name")
+	filters.SortSafelist = data.CompanySortSafelist
+
+	data.ValidateTag(v, query.Tag)
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	companies, metadata, err := app.company.GetAllCompanies(request.Context(), query, filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"companies": companies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
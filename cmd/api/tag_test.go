@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestCompanyTags tests the tag handlers. The cases share the same application and
+// run in order, each one relying on the tags left by the previous ones.
+func TestCompanyTags(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	const companyPath = "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c"
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"No tags", http.MethodGet, "/v1/tags", http.StatusOK, []byte(`"tags":[]`)},
+		{"Tag", http.MethodPut, companyPath + "/tags/strategic", http.StatusCreated, []byte(`"tags":["strategic"]`)},
+		{"Tag again in upper case", http.MethodPut, companyPath + "/tags/Strategic", http.StatusOK, []byte(`"tags":["strategic"]`)},
+		{"Second tag", http.MethodPut, companyPath + "/tags/eu-pilot", http.StatusCreated, []byte(`"tags":["eu-pilot","strategic"]`)},
+		{"Invalid tag", http.MethodPut, companyPath + "/tags/eu_pilot", http.StatusUnprocessableEntity, []byte("tag")},
+		{"Tag unknown company", http.MethodPut, "/v1/company/5f001b5d-8cd1-4f90-8a6a-5164adee43b5/tags/strategic", http.StatusNotFound, nil},
+		{"Tag deleted company", http.MethodPut, "/v1/company/3b1f6a52-8f0e-4a57-9d0c-6b2f7c1e4a90/tags/strategic", http.StatusNotFound, nil},
+		{"Tag invalid id", http.MethodPut, "/v1/company/1/tags/strategic", http.StatusBadRequest, nil},
+		{"Get tagged company", http.MethodGet, companyPath, http.StatusOK, []byte(`"tags":["eu-pilot","strategic"]`)},
+		{"Tags", http.MethodGet, "/v1/tags", http.StatusOK, []byte(`{"name":"eu-pilot","companies":1},{"name":"strategic","companies":1}`)},
+		{"Tag companies", http.MethodGet, "/v1/tags/strategic/companies", http.StatusOK, []byte(`"name":"Test Company"`)},
+		{"Tag companies invalid tag", http.MethodGet, "/v1/tags/eu_pilot/companies", http.StatusUnprocessableEntity, []byte("tag")},
+		{"Tag companies invalid sort", http.MethodGet, "/v1/tags/strategic/companies?sort=rank", http.StatusUnprocessableEntity, []byte("sort")},
+		{"List by tag", http.MethodGet, "/v1/company?tag=eu-pilot", http.StatusOK, []byte(`"name":"Test Company"`)},
+		{"List by unused tag", http.MethodGet, "/v1/company?tag=unused", http.StatusOK, []byte(`"companies":[]`)},
+		{"List by invalid tag", http.MethodGet, "/v1/company?tag=eu_pilot", http.StatusUnprocessableEntity, []byte("tag")},
+		{"Untag", http.MethodDelete, companyPath + "/tags/strategic", http.StatusOK, []byte(`"tags":["eu-pilot"]`)},
+		{"Untag again", http.MethodDelete, companyPath + "/tags/strategic", http.StatusNotFound, nil},
+		{"Untagged companies", http.MethodGet, "/v1/tags/strategic/companies", http.StatusOK, []byte(`"companies":[]`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+			// The changed company is returned with its new ETag.
+			if tt.method != http.MethodGet && rs.StatusCode < 300 {
+				company, err := app.company.GetCompany(context.Background(), uuid.MustParse("dc152cf7-cc4b-4555-8d4c-1878e5b9262c"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := rs.Header.Get("ETag"); got != etag(company.Version) {
+					t.Errorf("want ETag %s; got %q", etag(company.Version), got)
+				}
+			}
+		})
+	}
+}
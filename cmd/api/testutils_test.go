@@ -2,19 +2,46 @@ package main
 
 import (
 	"log"
+	"mborgnolo/companyservice/internal/conformance"
 	"mborgnolo/companyservice/internal/data"
 	"mborgnolo/companyservice/internal/mocks"
+	"mborgnolo/companyservice/internal/stream"
+	"mborgnolo/companyservice/pkg/cloudevents"
 	"os"
 	"testing"
+	"time"
+)
+
+// The conformance suite checks the same contract as the CompanyRepository of the API.
+var (
+	_ conformance.CompanyRepository = CompanyRepository(nil)
+	_ CompanyRepository             = conformance.CompanyRepository(nil)
 )
 
 // newTestApplication returns an instance of application configured for testing
 func newTestApplication(t *testing.T) *application {
 
+	cfg := config{env: "test"}
+	cfg.outbox.minBackoff = time.Second
+	cfg.outbox.maxBackoff = time.Minute
+	cfg.outbox.batchSize = 100
+	cfg.outbox.maxAttempts = 3
+	cfg.events.mode = cloudevents.Structured
+	cfg.events.source = "/companyservice"
+	cfg.stream.heartbeat = 50 * time.Millisecond
+	cfg.webhooks.batchSize = 50
+	cfg.webhooks.timeout = 5 * time.Second
+	cfg.webhooks.maxFailures = 3
+	cfg.webhooks.minBackoff = time.Second
+	cfg.webhooks.maxBackoff = time.Minute
 	return &application{
-		config:    config{env: "test"},
-		logger:    log.New(os.Stdout, "", log.Ldate|log.Ltime),
-		company:   &mocks.CompanyModel{},
-		eventChan: make(chan data.EventRecord, 100),
+		config:        cfg,
+		logger:        log.New(os.Stdout, "", log.Ldate|log.Ltime),
+		company:       mocks.NewCompanyModel(),
+		outbox:        &mocks.OutboxModel{},
+		publisher:     &mocks.Publisher{},
+		webhooks:      data.NewMemoryModel(),
+		webhookClient: newWebhookClient(cfg.webhooks.timeout, true),
+		stream:        stream.NewBroker(10),
 	}
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"net/http"
+	"time"
+)
+
+// ListDeletedCompaniesHandler returns a page of the companies in the trash.
+func (app *application) ListDeletedCompaniesHandler(writer http.ResponseWriter, request *http.Request) {
+	var filters data.Filters
+	v := validator.New()
+	qs := request.URL.Query()
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	filters.SortSafelist = data.CompanyTrashSortSafelist
+
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	companies, metadata, err := app.company.GetDeletedCompanies(request.Context(), filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"companies": companies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// RestoreCompanyHandler moves the company identified by the ID provided in the
+// request URL out of the trash. If no matching deleted company is found, this
+// method returns a 404 Not Found response, and if its name has been taken by another
+// company, a 422 Unprocessable Entity response.
+func (app *application) RestoreCompanyHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	err = app.company.RestoreCompany(request.Context(), id, app.contextGetActor(request))
+	if err != nil {
+		switch err {
+		case data.ErrRecordNotFound:
+			app.notFoundResponse(writer, request)
+		case data.ErrDuplicateName:
+			app.failedValidationResponse(writer, request, map[string]string{"name": "is now used by another company"})
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"id": id}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// purgeDeletedCompanies is a background goroutine that permanently removes the
+// companies which have been in the trash for longer than the retention period,
+// until done is closed.
+func (app *application) purgeDeletedCompanies(done <-chan struct{}) {
+	defer app.wg.Done()
+	ticker := time.NewTicker(app.config.trash.purgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := app.company.PurgeDeletedCompanies(ctx, time.Now().Add(-app.config.trash.retention))
+			cancel()
+			if err != nil {
+				app.logger.Println(err)
+				continue
+			}
+			if n > 0 {
+				app.logger.Printf("purged %d companies deleted more than %s ago", n, app.config.trash.retention)
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestListDeletedCompanies tests the listDeletedCompaniesHandler function.
+func TestListDeletedCompanies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Default sort", "/v1/company/trash", http.StatusOK, []byte("deleted_at")},
+		{"Sort by name", "/v1/company/trash?sort=name&page=1&page_size=10", http.StatusOK, []byte("Old Company")},
+		{"Invalid sort", "/v1/company/trash?sort=employees", http.StatusUnprocessableEntity, []byte("sort")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Get(ts.URL + tt.urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+// TestRestoreCompany tests the restoreCompanyHandler function.
+func TestRestoreCompany(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Deleted company", "/v1/company/3b1f6a52-8f0e-4a57-9d0c-6b2f7c1e4a90/restore", http.StatusOK, []byte("3b1f6a52-8f0e-4a57-9d0c-6b2f7c1e4a90")},
+		{"Company not in trash", "/v1/company/dc152cf7-cc4b-4555-8d4c-1878e5b9262c/restore", http.StatusNotFound, nil},
+		{"Invalid ID", "/v1/company/123/restore", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs, err := ts.Client().Post(ts.URL+tt.urlPath, "application/json", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/validator"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// WebhookRepository gives access to the webhooks and to their deliveries.
+type WebhookRepository interface {
+	CreateWebhook(ctx context.Context, webhook *data.Webhook) error
+	GetWebhooks(ctx context.Context) ([]*data.Webhook, error)
+	GetWebhook(ctx context.Context, id uuid.UUID) (*data.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook *data.Webhook) error
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	EnqueueWebhookDeliveries(ctx context.Context, eventID int64, eventType string, companyID uuid.UUID, payload []byte) error
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*data.PendingWebhookDelivery, error)
+	MarkWebhookDelivered(ctx context.Context, id int64, status int) error
+	MarkWebhookDeliveryFailed(ctx context.Context, id int64, status int, cause error, nextAttempt time.Time, maxFailures int) (bool, error)
+	GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, filters data.Filters) ([]*data.WebhookDelivery, data.Metadata, error)
+	DeleteWebhookDeliveries(ctx context.Context, deliveredBefore time.Time) (int64, error)
+}
+
+// Headers of the webhook deliveries.
+const (
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+	webhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// webhookSignature returns the signature of a delivery: the hex encoded HMAC-SHA256,
+// keyed by the secret of the webhook, of its timestamp and its body joined by a dot.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errPrivateAddress is the error of a webhook delivery to an address which is not
+// public.
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, private but not reported by
+// net.IP.IsPrivate.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// newWebhookClient returns the HTTP client sending the webhook deliveries. Redirects
+// are not followed: a delivery must be answered by the URL of its webhook. Unless
+// allowPrivate is set, the client refuses to connect to the loopback, private,
+// link-local and other non-public addresses, so that a webhook cannot reach the
+// internal network. The address is checked when dialing, once the host name is
+// resolved, so that a name resolving to a private address is refused too. No proxy is
+// used, as it would be dialed instead of the webhook.
+func newWebhookClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// isPublicIP reports whether ip is a public unicast address.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+// webhookPublisher is the sink of the events scheduling their delivery to the
+// webhooks subscribed to their type.
+type webhookPublisher struct {
+	webhooks WebhookRepository
+}
+
+// Publish enqueues the deliveries of the event, whose data is the EventRecord posted
+// to the webhooks.
+func (p *webhookPublisher) Publish(ctx context.Context, event *cloudevents.Event) error {
+	eventID, err := strconv.ParseInt(event.ID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid event id %q", event.ID)
+	}
+	companyID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return fmt.Errorf("invalid event subject %q", event.Subject)
+	}
+	eventType, ok := eventTypeName(event.Type)
+	if !ok {
+		return fmt.Errorf("unknown event type %q", event.Type)
+	}
+	return p.webhooks.EnqueueWebhookDeliveries(ctx, eventID, eventType, companyID, event.Data)
+}
+
+// Close does nothing: the repository is owned by the application.
+func (p *webhookPublisher) Close() error {
+	return nil
+}
+
+// deliverWebhooks is a background goroutine that attempts the pending webhook
+// deliveries until done is closed.
+func (app *application) deliverWebhooks(done <-chan struct{}) {
+	defer app.wg.Done()
+	ticker := time.NewTicker(app.config.webhooks.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			app.deliverPendingWebhooks()
+		}
+	}
+}
+
+// webhookQueryTimeout is the longest a query of the webhook deliveries may take, such
+// as the one recording the result of an attempt.
+const webhookQueryTimeout = 5 * time.Second
+
+// webhookLease returns how long the deliveries claimed by an instance are held back
+// from the other instances: the longest a batch of deliveries is attempted for, every
+// attempt taking up to the webhook timeout and the recording of its result.
+func (app *application) webhookLease() time.Duration {
+	return time.Duration(app.config.webhooks.batchSize) * (app.config.webhooks.timeout + webhookQueryTimeout)
+}
+
+// deliverPendingWebhooks attempts the batch of pending webhook deliveries which are
+// due, claimed so that every delivery is attempted by a single instance at a time. Every
+// attempt has its own timeout, and its result is recorded before the claim expires. A
+// failed delivery is retried with an exponential backoff, and the webhook is disabled
+// after too many consecutive failures. The deliveries of a webhook are not ordered: a
+// failed delivery does not hold back the following ones.
+func (app *application) deliverPendingWebhooks() {
+	lease := app.webhookLease()
+	expiry := time.Now().Add(lease)
+	ctx, cancel := context.WithTimeout(context.Background(), webhookQueryTimeout)
+	deliveries, err := app.webhooks.ClaimWebhookDeliveries(ctx, app.config.webhooks.batchSize, lease)
+	cancel()
+	if err != nil {
+		app.logger.Println(err)
+		return
+	}
+	disabled := make(map[uuid.UUID]bool)
+	for _, delivery := range deliveries {
+		if disabled[delivery.WebhookID] {
+			continue
+		}
+		// The deliveries which could not be attempted and recorded before the claim
+		// expires are left to the next claim.
+		if time.Until(expiry) < app.config.webhooks.timeout+webhookQueryTimeout {
+			return
+		}
+		app.attemptWebhookDelivery(delivery, disabled)
+	}
+}
+
+// attemptWebhookDelivery sends the delivery and records the result of the attempt,
+// marking its webhook in disabled if it is disabled after the failure.
+func (app *application) attemptWebhookDelivery(delivery *data.PendingWebhookDelivery, disabled map[uuid.UUID]bool) {
+	sendCtx, cancel := context.WithTimeout(context.Background(), app.config.webhooks.timeout)
+	status, sendErr := app.sendWebhook(sendCtx, delivery)
+	cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), webhookQueryTimeout)
+	defer cancel()
+	if sendErr == nil {
+		if err := app.webhooks.MarkWebhookDelivered(ctx, delivery.ID, status); err != nil {
+			app.logger.Println(err)
+		}
+		return
+	}
+	nextAttempt := time.Now().Add(backoff(app.config.webhooks.minBackoff, app.config.webhooks.maxBackoff, delivery.Attempts))
+	app.logger.Printf("delivery %d of event %d to webhook %s failed (attempt %d, next at %s): %v",
+		delivery.ID, delivery.EventID, delivery.WebhookID, delivery.Attempts+1, nextAttempt.Format(time.RFC3339), sendErr)
+	var err error
+	disabled[delivery.WebhookID], err = app.webhooks.MarkWebhookDeliveryFailed(ctx, delivery.ID, status, sendErr, nextAttempt,
+		app.config.webhooks.maxFailures)
+	if err != nil {
+		app.logger.Println(err)
+	}
+	if disabled[delivery.WebhookID] {
+		app.logger.Printf("webhook %s disabled after %d consecutive failed deliveries", delivery.WebhookID, app.config.webhooks.maxFailures)
+	}
+}
+
+// sendWebhook posts the payload of a delivery to its webhook, and returns the status of
+// the response, 0 if none was received. A response status other than 2xx is an error.
+func (app *application) sendWebhook(ctx context.Context, delivery *data.PendingWebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "companyservice/"+version)
+	req.Header.Set(webhookEventHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, webhookSignature(delivery.Secret, timestamp, delivery.Payload))
+	rs, err := app.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Body.Close()
+	// The body is drained so that the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(rs.Body, 64<<10))
+	if rs.StatusCode < 200 || rs.StatusCode > 299 {
+		return rs.StatusCode, fmt.Errorf("unexpected response status %s", rs.Status)
+	}
+	return rs.StatusCode, nil
+}
+
+// pruneWebhookDeliveries is a background goroutine that permanently removes the
+// deliveries delivered for longer than the retention period until done is closed, so
+// that the deliveries do not grow without limit.
+func (app *application) pruneWebhookDeliveries(done <-chan struct{}) {
+	defer app.wg.Done()
+	ticker := time.NewTicker(app.config.webhooks.pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			n, err := app.webhooks.DeleteWebhookDeliveries(ctx, time.Now().Add(-app.config.webhooks.retention))
+			cancel()
+			if err != nil {
+				app.logger.Println(err)
+				continue
+			}
+			if n > 0 {
+				app.logger.Printf("pruned %d webhook deliveries delivered more than %s ago", n, app.config.webhooks.retention)
+			}
+		}
+	}
+}
+
+// writeWebhookError writes the response of an error returned by the webhook repository.
+func (app *application) writeWebhookError(writer http.ResponseWriter, request *http.Request, err error) {
+	switch err {
+	case data.ErrRecordNotFound:
+		app.notFoundResponse(writer, request)
+	default:
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// ListWebhooksHandler returns the webhooks, without their secret.
+func (app *application) ListWebhooksHandler(writer http.ResponseWriter, request *http.Request) {
+	webhooks, err := app.webhooks.GetWebhooks(request.Context())
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// GetWebhookHandler returns the webhook identified by the ID provided in the request URL.
+func (app *application) GetWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	webhook, err := app.webhooks.GetWebhook(request.Context(), id)
+	if err != nil {
+		app.writeWebhookError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// CreateWebhookHandler creates the webhook in the POSTed JSON document, which is
+// enabled. The secret is never returned.
+func (app *application) CreateWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	var input struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+	}
+	err := app.readJSON(request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	webhook := &data.Webhook{URL: input.URL, EventTypes: input.EventTypes, Secret: input.Secret}
+
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	if err = app.webhooks.CreateWebhook(request.Context(), webhook); err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusCreated, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// UpdateWebhookHandler updates the webhook identified by the ID provided in the
+// request URL with the fields of the PATCHed JSON document. Enabling a disabled
+// webhook resets its consecutive failures and resumes its pending deliveries.
+func (app *application) UpdateWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	webhook, err := app.webhooks.GetWebhook(request.Context(), id)
+	if err != nil {
+		app.writeWebhookError(writer, request, err)
+		return
+	}
+	var input struct {
+		URL        *string   `json:"url"`
+		EventTypes *[]string `json:"event_types"`
+		Secret     *string   `json:"secret"`
+		Enabled    *bool     `json:"enabled"`
+	}
+	err = app.readJSON(request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+	if input.EventTypes != nil {
+		webhook.EventTypes = *input.EventTypes
+	}
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+	}
+	if input.Enabled != nil {
+		webhook.Enabled = *input.Enabled
+	}
+
+	v := validator.New()
+	if data.ValidateWebhook(v, webhook); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	if err = app.webhooks.UpdateWebhook(request.Context(), webhook); err != nil {
+		app.writeWebhookError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// DeleteWebhookHandler deletes the webhook identified by the ID provided in the
+// request URL, along with its deliveries.
+func (app *application) DeleteWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	if err = app.webhooks.DeleteWebhook(request.Context(), id); err != nil {
+		app.writeWebhookError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"id": id}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// ListWebhookDeliveriesHandler returns a page of the deliveries of the webhook
+// identified by the ID provided in the request URL, from the most recent.
+func (app *application) ListWebhookDeliveriesHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIDParam(request)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+	v := validator.New()
+	qs := request.URL.Query()
+	filters := data.Filters{
+		Page:         app.readInt(qs, "page", 1, v),
+		PageSize:     app.readInt(qs, "page_size", 20, v),
+		Sort:         "-id",
+		SortSafelist: data.WebhookDeliveriesSafelist,
+	}
+	if data.ValidateFilters(v, filters); !v.IsValid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+	deliveries, metadata, err := app.webhooks.GetWebhookDeliveries(request.Context(), id, filters)
+	if err != nil {
+		app.writeWebhookError(writer, request, err)
+		return
+	}
+	err = app.writeJSON(writer, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mborgnolo/companyservice/internal/data"
+	"mborgnolo/companyservice/internal/mocks"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testWebhookSecret = "0123456789abcdef0123"
+
+// webhookReceiver is a stand-in partner system recording the webhook deliveries it
+// receives, and answering them with its status after its delay.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	delay    time.Duration
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, request)
+	r.bodies = append(r.bodies, body)
+	r.mu.Unlock()
+	time.Sleep(r.delay)
+	writer.WriteHeader(r.status)
+}
+
+// createTestWebhook creates a webhook through the API, and returns it.
+func createTestWebhook(t *testing.T, ts *httptest.Server, url string, eventTypes ...string) *data.Webhook {
+	t.Helper()
+	input, err := json.Marshal(map[string]interface{}{"url": url, "event_types": eventTypes, "secret": testWebhookSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := ts.Client().Post(ts.URL+"/v1/webhooks", "application/json", bytes.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	if rs.StatusCode != http.StatusCreated {
+		t.Fatalf("want %d; got %d", http.StatusCreated, rs.StatusCode)
+	}
+	var output struct {
+		Webhook *data.Webhook `json:"webhook"`
+	}
+	if err := json.NewDecoder(rs.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	return output.Webhook
+}
+
+// publishTestEvents publishes to the webhooks the pending event of the mock outbox,
+// as count events of distinct ids.
+func publishTestEvents(t *testing.T, app *application, count int) {
+	t.Helper()
+	events, err := app.outbox.GetPendingEvents(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher := &webhookPublisher{webhooks: app.webhooks}
+	for i := 1; i <= count; i++ {
+		events[0].ID = int64(i)
+		event, err := app.cloudEvent(events[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWebhookHandlers tests the creation, retrieval, update and deletion of webhooks.
+func TestWebhookHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	webhook := createTestWebhook(t, ts, "https://partner.example.com/hooks", "CompanyCreated")
+	path := "/v1/webhooks/" + webhook.ID.String()
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		body     string
+		wantCode int
+		wantBody []byte
+	}{
+		{"List", http.MethodGet, "/v1/webhooks", "", http.StatusOK, []byte("https://partner.example.com/hooks")},
+		{"Get", http.MethodGet, path, "", http.StatusOK, []byte(`"event_types":["CompanyCreated"]`)},
+		{"Get unknown", http.MethodGet, "/v1/webhooks/dc152cf7-cc4b-4555-8d4c-1878e5b9262c", "", http.StatusNotFound, nil},
+		{"Get invalid ID", http.MethodGet, "/v1/webhooks/123", "", http.StatusBadRequest, nil},
+		{"Create invalid", http.MethodPost, "/v1/webhooks", `{"url":"ftp://partner.example.com","event_types":["CompanyMerged"],"secret":"short"}`,
+			http.StatusUnprocessableEntity, []byte(`"secret"`)},
+		{"Create unknown field", http.MethodPost, "/v1/webhooks", `{"url":"https://partner.example.com","active":true}`, http.StatusBadRequest, nil},
+		{"Update", http.MethodPatch, path, `{"event_types":["CompanyDeleted","CompanyRestored"],"enabled":false}`,
+			http.StatusOK, []byte(`"enabled":false`)},
+		{"Update duplicate types", http.MethodPatch, path, `{"event_types":["CompanyDeleted","CompanyDeleted"]}`,
+			http.StatusUnprocessableEntity, []byte("duplicate")},
+		{"Deliveries", http.MethodGet, path + "/deliveries", "", http.StatusOK, []byte(`"deliveries":[]`)},
+		{"Deliveries invalid page", http.MethodGet, path + "/deliveries?page=0", "", http.StatusUnprocessableEntity, []byte("page")},
+		{"Delete", http.MethodDelete, path, "", http.StatusOK, []byte(webhook.ID.String())},
+		{"Delete again", http.MethodDelete, path, "", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, ts.URL+tt.urlPath, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rs.Body.Close()
+			body, err := io.ReadAll(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rs.StatusCode != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, rs.StatusCode)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+			if bytes.Contains(body, []byte(testWebhookSecret)) {
+				t.Errorf("want the secret not to be returned; got %q", body)
+			}
+		})
+	}
+}
+
+// TestDeliverWebhooks tests that the events are posted to the subscribed webhooks
+// with a signature the receiver can verify, and logged as delivered.
+func TestDeliverWebhooks(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	rts := httptest.NewServer(receiver)
+	defer rts.Close()
+
+	webhook := createTestWebhook(t, ts, rts.URL, "CompanyCreated")
+	createTestWebhook(t, ts, rts.URL+"/deleted", "CompanyDeleted")
+	publishTestEvents(t, app, 1)
+	app.deliverPendingWebhooks()
+
+	if len(receiver.requests) != 1 {
+		t.Fatalf("want 1 delivery; got %d", len(receiver.requests))
+	}
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if got := req.Header.Get(webhookEventHeader); got != "CompanyCreated" {
+		t.Errorf("want event CompanyCreated; got %q", got)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(webhookTimestampHeader), 10, 64)
+	if err != nil || time.Since(time.Unix(timestamp, 0)) > time.Minute {
+		t.Errorf("want a current timestamp; got %q", req.Header.Get(webhookTimestampHeader))
+	}
+	want := webhookSignature(testWebhookSecret, req.Header.Get(webhookTimestampHeader), body)
+	if got := req.Header.Get(webhookSignatureHeader); got != want {
+		t.Errorf("want signature %q; got %q", want, got)
+	}
+	var record data.EventRecord
+	if err := json.Unmarshal(body, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.ID.String() != "dc152cf7-cc4b-4555-8d4c-1878e5b9262c" {
+		t.Errorf("want the event of company dc152cf7-cc4b-4555-8d4c-1878e5b9262c; got %s", record.ID)
+	}
+
+	// A delivered event is not posted again, even when it is published again.
+	publishTestEvents(t, app, 1)
+	app.deliverPendingWebhooks()
+	if len(receiver.requests) != 1 {
+		t.Errorf("want 1 delivery; got %d", len(receiver.requests))
+	}
+
+	rs, err := ts.Client().Get(ts.URL + "/v1/webhooks/" + webhook.ID.String() + "/deliveries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	var output struct {
+		Deliveries []*data.WebhookDelivery `json:"deliveries"`
+	}
+	if err := json.NewDecoder(rs.Body).Decode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if len(output.Deliveries) != 1 {
+		t.Fatalf("want 1 delivery logged; got %d", len(output.Deliveries))
+	}
+	delivery := output.Deliveries[0]
+	if delivery.Status != data.DeliveryDelivered || delivery.Attempts != 1 || delivery.ResponseStatus == nil ||
+		*delivery.ResponseStatus != http.StatusNoContent || delivery.DeliveredAt == nil {
+		t.Errorf("want a delivery delivered at the first attempt; got %+v", delivery)
+	}
+}
+
+// TestDeliverWebhooksFailures tests that failed deliveries are retried after a
+// backoff, and that the webhook is disabled after too many consecutive failures.
+func TestDeliverWebhooksFailures(t *testing.T) {
+	app := newTestApplication(t)
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	receiver := &webhookReceiver{status: http.StatusInternalServerError}
+	rts := httptest.NewServer(receiver)
+	defer rts.Close()
+
+	webhook := createTestWebhook(t, ts, rts.URL)
+	publishTestEvents(t, app, 1)
+	app.deliverPendingWebhooks()
+	app.deliverPendingWebhooks()
+	if len(receiver.requests) != 1 {
+		t.Errorf("want the failed delivery to wait for its backoff; got %d attempts", len(receiver.requests))
+	}
+
+	publishTestEvents(t, app, app.config.webhooks.maxFailures+1)
+	app.deliverPendingWebhooks()
+	if want := app.config.webhooks.maxFailures; len(receiver.requests) != want {
+		t.Errorf("want %d attempts before the webhook is disabled; got %d", want, len(receiver.requests))
+	}
+	got, err := app.webhooks.GetWebhook(context.Background(), webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Enabled || got.ConsecutiveFailures != app.config.webhooks.maxFailures {
+		t.Errorf("want the webhook disabled after %d failures; got %+v", app.config.webhooks.maxFailures, got)
+	}
+	deliveries, _, err := app.webhooks.GetWebhookDeliveries(context.Background(), webhook.ID, data.Filters{Page: 1, PageSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := deliveries[len(deliveries)-1]
+	if failed.Status != data.DeliveryPending || failed.ResponseStatus == nil || *failed.ResponseStatus != http.StatusInternalServerError ||
+		!strings.Contains(failed.LastError, "500") || !failed.NextAttemptAt.After(time.Now()) {
+		t.Errorf("want the failed delivery logged and scheduled for a retry; got %+v", failed)
+	}
+
+	// Enabling the webhook again resets its failures.
+	req, err := http.NewRequest(http.MethodPatch, ts.URL+"/v1/webhooks/"+webhook.ID.String(), strings.NewReader(`{"enabled":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(body, []byte(`"enabled":true,"consecutive_failures":0`)) {
+		t.Errorf("want the webhook enabled without failures; got %q", body)
+	}
+}
+
+// TestDeliverWebhooksTimeout tests that every attempt has its own timeout, and that the
+// attempts which timed out are recorded.
+func TestDeliverWebhooksTimeout(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.webhooks.timeout = 100 * time.Millisecond
+	ts := httptest.NewServer(app.routes())
+	defer ts.Close()
+	receiver := &webhookReceiver{status: http.StatusNoContent, delay: 300 * time.Millisecond}
+	rts := httptest.NewServer(receiver)
+	defer rts.Close()
+
+	webhook := createTestWebhook(t, ts, rts.URL)
+	publishTestEvents(t, app, 2)
+	app.deliverPendingWebhooks()
+	receiver.mu.Lock()
+	attempts := len(receiver.requests)
+	receiver.mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("want 2 attempts; got %d", attempts)
+	}
+	deliveries, _, err := app.webhooks.GetWebhookDeliveries(context.Background(), webhook.ID, data.Filters{Page: 1, PageSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status != data.DeliveryPending || delivery.Attempts != 1 || !strings.Contains(delivery.LastError, "deadline exceeded") {
+			t.Errorf("want the attempt which timed out recorded; got %+v", delivery)
+		}
+	}
+}
+
+// TestWebhookPublisher tests that the events of unknown types are not enqueued.
+func TestWebhookPublisher(t *testing.T) {
+	app := newTestApplication(t)
+	events, err := (&mocks.OutboxModel{}).GetPendingEvents(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := app.cloudEvent(events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Type = "io.companyservice.company.merged"
+	if err := (&webhookPublisher{webhooks: app.webhooks}).Publish(context.Background(), event); err == nil {
+		t.Error("want an error for an unknown event type")
+	}
+}
+
+// TestBackoff tests that the backoff doubles from its minimum up to its maximum.
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 5 * time.Second},
+		{1, 10 * time.Second},
+		{3, 40 * time.Second},
+		{10, time.Minute},
+		{100, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := backoff(5*time.Second, time.Minute, tt.attempts); got != tt.want {
+			t.Errorf("attempts %d: want %s; got %s", tt.attempts, tt.want, got)
+		}
+	}
+}
+
+// TestWebhookClientPrivateAddresses tests that the webhook client refuses to connect to
+// addresses which are not public unless they are allowed.
+func TestWebhookClientPrivateAddresses(t *testing.T) {
+	rts := httptest.NewServer(&webhookReceiver{status: http.StatusNoContent})
+	defer rts.Close()
+
+	_, err := newWebhookClient(5*time.Second, false).Post(rts.URL, "application/json", nil)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("want %v; got %v", errPrivateAddress, err)
+	}
+	rs, err := newWebhookClient(5*time.Second, true).Post(rts.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("want the private address allowed; got %v", err)
+	}
+	rs.Body.Close()
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s: want public %t; got %t", tt.ip, tt.want, got)
+		}
+	}
+}
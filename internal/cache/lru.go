@@ -0,0 +1,161 @@
+// Package cache provides a bounded in-memory cache of companies.
+package cache
+
+import (
+	"container/list"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/data"
+	"sync"
+	"time"
+)
+
+// Stats holds the counters of a cache.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+// entry is a cached company, along with its expiry time.
+type entry struct {
+	company   *data.Company
+	expiresAt time.Time
+}
+
+// LRU is a cache of companies bounded in size, evicting the least recently used
+// company once it is full, whose companies expire after a time to live. It is safe
+// for concurrent use. Cached companies are copied in and out of the cache, so that
+// callers are free to modify them.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List // front is the most recently used
+	items map[uuid.UUID]*list.Element
+	stats Stats
+	// generation is incremented by every invalidation, so that a company loaded
+	// while an invalidation happened is not cached.
+	generation uint64
+	now        func() time.Time
+}
+
+// New returns a cache holding up to size companies for the given time to live.
+func New(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: make(map[uuid.UUID]*list.Element, size),
+		now:   time.Now,
+	}
+}
+
+// Get returns the cached company with the given id, or calls load and caches the
+// company it returns on a miss. The errors of load are returned and not cached.
+func (c *LRU) Get(id uuid.UUID, load func() (*data.Company, error)) (*data.Company, error) {
+	c.mu.Lock()
+	if element, ok := c.items[id]; ok {
+		e := element.Value.(*entry)
+		if c.now().Before(e.expiresAt) {
+			c.order.MoveToFront(element)
+			c.stats.Hits++
+			company := copyCompany(e.company)
+			c.mu.Unlock()
+			return company, nil
+		}
+		c.remove(element)
+	}
+	c.stats.Misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	company, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.add(company)
+	}
+	return company, nil
+}
+
+// Invalidate removes the company with the given id from the cache, and prevents the
+// companies being loaded from being cached, since they may be older than the change
+// which caused the invalidation.
+func (c *LRU) Invalidate(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if element, ok := c.items[id]; ok {
+		c.remove(element)
+	}
+}
+
+// Purge removes every company from the cache, and prevents the companies being
+// loaded from being cached.
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.order.Init()
+	c.items = make(map[uuid.UUID]*list.Element, c.size)
+}
+
+// Stats returns the counters of the cache.
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
+
+// add caches the company, evicting the least recently used company if the cache is
+// full. The caller must hold the lock.
+func (c *LRU) add(company *data.Company) {
+	if element, ok := c.items[company.ID]; ok {
+		c.remove(element)
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		if oldest == nil {
+			return
+		}
+		c.remove(oldest)
+		c.stats.Evictions++
+	}
+	e := &entry{company: copyCompany(company), expiresAt: c.now().Add(c.ttl)}
+	c.items[company.ID] = c.order.PushFront(e)
+}
+
+// remove removes the element from the cache. The caller must hold the lock.
+func (c *LRU) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*entry).company.ID)
+}
+
+// copyCompany returns a copy of the company which does not share its pointers.
+func copyCompany(company *data.Company) *data.Company {
+	c := *company
+	if company.Registered != nil {
+		registered := *company.Registered
+		c.Registered = &registered
+	}
+	if company.DeletedAt != nil {
+		deletedAt := *company.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	if company.ParentID != nil {
+		parentID := *company.ParentID
+		c.ParentID = &parentID
+	}
+	if company.Tags != nil {
+		c.Tags = append([]string{}, company.Tags...)
+	}
+	c.Attributes = company.Attributes.Clone()
+	return &c
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/data"
+	"testing"
+	"time"
+)
+
+// loader returns a load function returning the company, which counts its calls.
+func loader(company *data.Company, calls *int) func() (*data.Company, error) {
+	return func() (*data.Company, error) {
+		*calls++
+		return company, nil
+	}
+}
+
+func TestLRU(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New(2, time.Minute)
+	c.now = func() time.Time { return now }
+	companies := []*data.Company{{ID: uuid.New(), Name: "One"}, {ID: uuid.New(), Name: "Two"}, {ID: uuid.New(), Name: "Three"}}
+	calls := 0
+
+	got, err := c.Get(companies[0].ID, loader(companies[0], &calls))
+	if err != nil || got.Name != "One" || calls != 1 {
+		t.Fatalf("miss: want One loaded once; got %+v, %v, %d calls", got, err, calls)
+	}
+	got.Name = "Changed"
+	got, _ = c.Get(companies[0].ID, loader(companies[0], &calls))
+	if got.Name != "One" || calls != 1 {
+		t.Errorf("hit: want the cached One; got %s, %d calls", got.Name, calls)
+	}
+
+	// Two is the least recently used company once One has been read again.
+	c.Get(companies[1].ID, loader(companies[1], &calls))
+	c.Get(companies[0].ID, loader(companies[0], &calls))
+	c.Get(companies[2].ID, loader(companies[2], &calls))
+	calls = 0
+	c.Get(companies[0].ID, loader(companies[0], &calls))
+	c.Get(companies[1].ID, loader(companies[1], &calls))
+	if calls != 1 {
+		t.Errorf("eviction: want only Two to be loaded again; got %d calls", calls)
+	}
+
+	now = now.Add(time.Minute)
+	calls = 0
+	c.Get(companies[1].ID, loader(companies[1], &calls))
+	if calls != 1 {
+		t.Errorf("expiry: want Two to be loaded again; got %d calls", calls)
+	}
+
+	want := Stats{Hits: 3, Misses: 5, Evictions: 2, Size: 2}
+	if stats := c.Stats(); stats != want {
+		t.Errorf("want stats %+v; got %+v", want, stats)
+	}
+}
+
+func TestLRUInvalidate(t *testing.T) {
+	c := New(10, time.Minute)
+	company := &data.Company{ID: uuid.New(), Name: "One"}
+	calls := 0
+	c.Get(company.ID, loader(company, &calls))
+	c.Invalidate(company.ID)
+	c.Get(company.ID, loader(company, &calls))
+	if calls != 2 {
+		t.Errorf("want the invalidated company to be loaded again; got %d calls", calls)
+	}
+
+	// A company loaded while it is invalidated may be stale, and is not cached.
+	other := &data.Company{ID: uuid.New(), Name: "Two"}
+	c.Get(other.ID, func() (*data.Company, error) {
+		c.Invalidate(other.ID)
+		return other, nil
+	})
+	calls = 0
+	c.Get(other.ID, loader(other, &calls))
+	if calls != 1 {
+		t.Errorf("want the company loaded during an invalidation not to be cached; got %d calls", calls)
+	}
+
+	// A purge removes every company.
+	c.Purge()
+	calls = 0
+	c.Get(company.ID, loader(company, &calls))
+	if calls != 1 || c.Stats().Size != 1 {
+		t.Errorf("want the purged company to be loaded again; got %d calls, %+v", calls, c.Stats())
+	}
+
+	// Errors are not cached.
+	missing := uuid.New()
+	_, err := c.Get(missing, func() (*data.Company, error) { return nil, data.ErrRecordNotFound })
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		t.Errorf("want %v; got %v", data.ErrRecordNotFound, err)
+	}
+	calls = 0
+	c.Get(missing, loader(company, &calls))
+	if calls != 1 {
+		t.Errorf("want the errors not to be cached; got %d calls", calls)
+	}
+}
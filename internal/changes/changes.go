@@ -0,0 +1,84 @@
+// Package changes delivers the notifications of the changes of companies, sent by
+// the database to every instance of the service, to the subscribers in the service.
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/data"
+	"sync"
+)
+
+// Channel is the Postgres notification channel of the company changes.
+const Channel = "company_changes"
+
+// Operations notified in addition to the ones of the company revisions.
+const (
+	// OperationPurged is the permanent removal of a company from the trash.
+	OperationPurged = "purged"
+	// OperationResync is notified when changes may have been missed, after the
+	// connection to the database has been lost: any state derived from the companies
+	// must be considered stale. Its CompanyID is uuid.Nil.
+	OperationResync = "resync"
+)
+
+// Change is the notification of a change of a company.
+type Change struct {
+	CompanyID uuid.UUID `json:"id"`
+	Operation string    `json:"operation"`
+}
+
+// Parse returns the change notified with the given payload.
+func Parse(payload string) (Change, error) {
+	var change Change
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return Change{}, err
+	}
+	switch change.Operation {
+	case data.OperationCreated, data.OperationUpdated, data.OperationDeleted, data.OperationRestored, OperationPurged:
+	default:
+		return Change{}, errors.New("unknown change operation: " + change.Operation)
+	}
+	if change.CompanyID == uuid.Nil {
+		return Change{}, errors.New("change without company id")
+	}
+	return change, nil
+}
+
+// Hub fans the changes out to its subscribers. It is safe for concurrent use.
+type Hub struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Change)
+	nextID      int
+}
+
+// NewHub returns a Hub without subscribers.
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[int]func(Change))}
+}
+
+// Subscribe calls fn with every change published after it returns, until the
+// returned function is called. fn is called from the publishing goroutine, so it
+// must not block.
+func (h *Hub) Subscribe(fn func(Change)) (unsubscribe func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id := h.nextID
+	h.nextID++
+	h.subscribers[id] = fn
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers, id)
+	}
+}
+
+// Publish delivers the change to every subscriber.
+func (h *Hub) Publish(change Change) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, fn := range h.subscribers {
+		fn(change)
+	}
+}
@@ -0,0 +1,52 @@
+package changes
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	id := uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6")
+	tests := []struct {
+		name    string
+		payload string
+		want    Change
+		wantErr bool
+	}{
+		{"Updated", `{"id":"f1203d76-0491-47fe-9640-0aeda76ad3f6","operation":"updated"}`, Change{CompanyID: id, Operation: "updated"}, false},
+		{"Purged", `{"id":"f1203d76-0491-47fe-9640-0aeda76ad3f6","operation":"purged"}`, Change{CompanyID: id, Operation: OperationPurged}, false},
+		{"Unknown operation", `{"id":"f1203d76-0491-47fe-9640-0aeda76ad3f6","operation":"resync"}`, Change{}, true},
+		{"Missing id", `{"operation":"created"}`, Change{}, true},
+		{"Invalid JSON", `updated`, Change{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %t; got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want %+v; got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	var first, second []Change
+	unsubscribe := hub.Subscribe(func(change Change) { first = append(first, change) })
+	hub.Subscribe(func(change Change) { second = append(second, change) })
+
+	change := Change{CompanyID: uuid.New(), Operation: "created"}
+	hub.Publish(change)
+	unsubscribe()
+	hub.Publish(Change{Operation: OperationResync})
+
+	if len(first) != 1 || first[0] != change {
+		t.Errorf("want the change published before unsubscribing; got %+v", first)
+	}
+	if len(second) != 2 {
+		t.Errorf("want both changes; got %+v", second)
+	}
+}
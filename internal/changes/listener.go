@@ -0,0 +1,76 @@
+package changes
+
+import (
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"log"
+	"time"
+)
+
+// Reconnection intervals of the listener, doubled after every failed attempt.
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
+
+// pingInterval is the time without notifications after which the connection of the
+// listener is checked, so that a lost connection is noticed and re-established.
+const pingInterval = 90 * time.Second
+
+// Listener receives the changes notified by the database on Channel.
+type Listener struct {
+	listener *pq.Listener
+	logger   *log.Logger
+}
+
+// NewListener connects to the database with the given DSN and listens to Channel.
+// The connection is re-established automatically after it has been lost.
+func NewListener(dsn string, logger *log.Logger) (*Listener, error) {
+	listener := pq.NewListener(dsn, minReconnectInterval, maxReconnectInterval, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected:
+			logger.Printf("change listener disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			logger.Println("change listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Printf("change listener could not reconnect: %v", err)
+		}
+	})
+	if err := listener.Listen(Channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Listener{listener: listener, logger: logger}, nil
+}
+
+// Run publishes the notified changes to hub until done is closed. Once the
+// connection has been re-established after a loss, an OperationResync change is
+// published, since the changes made in the meantime have not been notified.
+func (l *Listener) Run(done <-chan struct{}, hub *Hub) {
+	for {
+		select {
+		case <-done:
+			return
+		case notification := <-l.listener.Notify:
+			if notification == nil {
+				hub.Publish(Change{CompanyID: uuid.Nil, Operation: OperationResync})
+				continue
+			}
+			change, err := Parse(notification.Extra)
+			if err != nil {
+				l.logger.Printf("invalid change notification %q: %v", notification.Extra, err)
+				continue
+			}
+			hub.Publish(change)
+		case <-time.After(pingInterval):
+			if err := l.listener.Ping(); err != nil {
+				l.logger.Printf("change listener ping: %v", err)
+			}
+		}
+	}
+}
+
+// Close closes the connection of the listener.
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
@@ -0,0 +1,981 @@
+// Package conformance holds the test suites checking that the implementations of
+// the repositories fulfil the same contract, whatever their storage.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/data"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CompanyRepository is the contract checked by RunCompanyRepositoryTests. It has
+// the same methods as the CompanyRepository used by the API.
+type CompanyRepository interface {
+	GetCompany(ctx context.Context, id uuid.UUID) (*data.Company, error)
+	GetCompanyAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (*data.Company, error)
+	CreateCompany(ctx context.Context, company *data.Company, actor string) (uuid.UUID, error)
+	ImportCompanies(ctx context.Context, companies []*data.Company, atomic bool, actor string) ([]data.ImportResult, error)
+	DeleteCompany(ctx context.Context, id uuid.UUID, version int, actor string) error
+	UpdateCompany(ctx context.Context, company *data.Company, actor string) error
+	GetAllCompanies(ctx context.Context, query data.CompanyQuery, filters data.Filters) ([]*data.Company, data.Metadata, error)
+	GetCompaniesByCursor(ctx context.Context, query data.CompanyQuery, filters data.KeysetFilters) ([]*data.Company, data.CursorPage, error)
+	ExportCompanies(ctx context.Context, query data.CompanyQuery, fn func(*data.Company) error) error
+	SearchCompanies(ctx context.Context, q string, filters data.Filters) ([]*data.CompanySearchResult, data.Metadata, error)
+	GetDeletedCompanies(ctx context.Context, filters data.Filters) ([]*data.Company, data.Metadata, error)
+	RestoreCompany(ctx context.Context, id uuid.UUID, actor string) error
+	PurgeDeletedCompanies(ctx context.Context, deletedBefore time.Time) (int64, error)
+	GetCompanyRevisions(ctx context.Context, id uuid.UUID, filters data.Filters) ([]*data.CompanyRevision, data.Metadata, error)
+	GetCompanyRevision(ctx context.Context, id uuid.UUID, revision int) (*data.CompanyRevision, error)
+	RollbackCompany(ctx context.Context, id uuid.UUID, revision, version int, actor string) (*data.Company, error)
+	GetCompanyAddresses(ctx context.Context, companyID uuid.UUID) ([]*data.Address, error)
+	GetCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID) (*data.Address, error)
+	CreateCompanyAddress(ctx context.Context, address *data.Address, actor string) error
+	UpdateCompanyAddress(ctx context.Context, address *data.Address, actor string) error
+	DeleteCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID, actor string) error
+	AddCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) (bool, error)
+	RemoveCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) error
+	GetTags(ctx context.Context) ([]*data.Tag, error)
+	SetCompanyParent(ctx context.Context, id, parentID uuid.UUID, actor string) error
+	ClearCompanyParent(ctx context.Context, id uuid.UUID, actor string) error
+	GetSubsidiaries(ctx context.Context, id uuid.UUID, depth int) ([]*data.CompanyNode, error)
+	GetAncestors(ctx context.Context, id uuid.UUID) ([]*data.CompanyNode, error)
+	GetCompaniesByAttribute(ctx context.Context, key, value string, filters data.Filters) ([]*data.Company, data.Metadata, error)
+}
+
+// unknownID is the id of a company which does not exist in any repository.
+var unknownID = uuid.MustParse("00000000-0000-4000-8000-00000000c0f0")
+
+// RunCompanyRepositoryTests checks that the repositories returned by newRepository
+// fulfil the CompanyRepository contract. Every test gets a new repository, which may
+// already hold companies: the suite only relies on the companies it creates, whose
+// names start with "Conf".
+func RunCompanyRepositoryTests(t *testing.T, newRepository func(t *testing.T) CompanyRepository) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, r CompanyRepository)
+	}{
+		{"NotFound", testNotFound},
+		{"CreateAndGet", testCreateAndGet},
+		{"DuplicateName", testDuplicateName},
+		{"Import", testImport},
+		{"PartialUpdate", testPartialUpdate},
+		{"DeleteAndRestore", testDeleteAndRestore},
+		{"Purge", testPurge},
+		{"List", testList},
+		{"Cursor", testCursor},
+		{"Export", testExport},
+		{"Search", testSearch},
+		{"RevisionsAndRollback", testRevisionsAndRollback},
+		{"AsOf", testAsOf},
+		{"Addresses", testAddresses},
+		{"Tags", testTags},
+		{"Hierarchy", testHierarchy},
+		{"Attributes", testAttributes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newRepository(t))
+		})
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// newCompany returns a valid company with the given name.
+func newCompany(name string) *data.Company {
+	return &data.Company{
+		Name:        name,
+		Description: data.CompanyDescription{String: name + " description", Valid: true},
+		Employees:   10,
+		Registered:  boolPtr(true),
+		Type:        "Corporations",
+	}
+}
+
+// create creates the company and returns it as read back from the repository.
+func create(t *testing.T, r CompanyRepository, company *data.Company) *data.Company {
+	t.Helper()
+	id, err := r.CreateCompany(context.Background(), company, "conformance")
+	if err != nil {
+		t.Fatalf("create %s: %v", company.Name, err)
+	}
+	created, err := r.GetCompany(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get %s: %v", company.Name, err)
+	}
+	return created
+}
+
+func names(companies []*data.Company) string {
+	var names []string
+	for _, company := range companies {
+		names = append(names, company.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func sortedNames(companies []*data.Company) string {
+	var names []string
+	for _, company := range companies {
+		names = append(names, company.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// outOfOrder reports whether the companies a and b, listed in this order, are not
+// sorted by the given sort value.
+func outOfOrder(sortValue string, a, b *data.Company) bool {
+	switch sortValue {
+	case "name":
+		return a.Name > b.Name
+	case "-name":
+		return a.Name < b.Name
+	case "employees":
+		return a.Employees > b.Employees
+	case "-employees":
+		return a.Employees < b.Employees
+	}
+	return false
+}
+
+func wantError(t *testing.T, operation string, got, want error) {
+	t.Helper()
+	if got != want {
+		t.Errorf("%s: want %v; got %v", operation, want, got)
+	}
+}
+
+func testNotFound(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	_, err := r.GetCompany(ctx, unknownID)
+	wantError(t, "get", err, data.ErrRecordNotFound)
+	_, err = r.GetCompanyAsOf(ctx, unknownID, time.Now())
+	wantError(t, "get as of", err, data.ErrRecordNotFound)
+	company := newCompany("Conf Ghost")
+	company.ID, company.Version = unknownID, 1
+	wantError(t, "update", r.UpdateCompany(ctx, company, "conformance"), data.ErrRecordNotFound)
+	wantError(t, "delete", r.DeleteCompany(ctx, unknownID, 1, "conformance"), data.ErrRecordNotFound)
+	wantError(t, "restore", r.RestoreCompany(ctx, unknownID, "conformance"), data.ErrRecordNotFound)
+	_, _, err = r.GetCompanyRevisions(ctx, unknownID, data.Filters{Page: 1, PageSize: 20})
+	wantError(t, "get revisions", err, data.ErrRecordNotFound)
+	_, err = r.GetCompanyRevision(ctx, unknownID, 1)
+	wantError(t, "get revision", err, data.ErrRecordNotFound)
+	_, err = r.RollbackCompany(ctx, unknownID, 1, 1, "conformance")
+	wantError(t, "rollback", err, data.ErrRecordNotFound)
+}
+
+func testCreateAndGet(t *testing.T, r CompanyRepository) {
+	company := newCompany("Conf Create")
+	got := create(t, r, company)
+	if got.ID == uuid.Nil || got.Version != 1 || got.DeletedAt != nil {
+		t.Errorf("want a new id, version 1 and no deletion time; got %+v", got)
+	}
+	if got.Name != company.Name || got.Description.String != company.Description.String || got.Employees != company.Employees ||
+		got.Registered == nil || *got.Registered != *company.Registered || got.Type != company.Type {
+		t.Errorf("want %+v; got %+v", company, got)
+	}
+
+	// Without a description the company gets an empty one.
+	company = newCompany("Conf NoDesc")
+	company.Description = data.CompanyDescription{}
+	if got := create(t, r, company); got.Description.String != "" {
+		t.Errorf("want an empty description; got %q", got.Description.String)
+	}
+
+	// Registered is mandatory.
+	company = newCompany("Conf NoReg")
+	company.Registered = nil
+	if _, err := r.CreateCompany(context.Background(), company, "conformance"); err == nil {
+		t.Error("create without registered: want error; got nil")
+	}
+
+	// The returned company is a copy.
+	got.Name = "Conf Changed"
+	again, err := r.GetCompany(context.Background(), got.ID)
+	if err != nil || again.Name == got.Name {
+		t.Errorf("want the stored company to be unchanged; got %+v, %v", again, err)
+	}
+}
+
+func testDuplicateName(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	first := create(t, r, newCompany("Conf Dup"))
+	second := create(t, r, newCompany("Conf Other"))
+
+	_, err := r.CreateCompany(ctx, newCompany("Conf Dup"), "conformance")
+	wantError(t, "create with used name", err, data.ErrDuplicateName)
+
+	second.Name = "Conf Dup"
+	wantError(t, "update with used name", r.UpdateCompany(ctx, second, "conformance"), data.ErrDuplicateName)
+
+	// Keeping its own name is not a duplicate.
+	first.Employees = 11
+	wantError(t, "update keeping the name", r.UpdateCompany(ctx, first, "conformance"), nil)
+
+	// The name of a deleted company can be reused, and the company can then no longer
+	// be restored.
+	wantError(t, "delete", r.DeleteCompany(ctx, first.ID, first.Version, "conformance"), nil)
+	_, err = r.CreateCompany(ctx, newCompany("Conf Dup"), "conformance")
+	wantError(t, "create with the name of a deleted company", err, nil)
+	wantError(t, "restore with a used name", r.RestoreCompany(ctx, first.ID, "conformance"), data.ErrDuplicateName)
+}
+
+func testImport(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	create(t, r, newCompany("Conf Taken"))
+	companies := []*data.Company{newCompany("Conf Import A"), newCompany("Conf Taken"), newCompany("Conf Import B"), newCompany("Conf Import A")}
+	wantErrs := []error{nil, data.ErrDuplicateName, nil, data.ErrDuplicateName}
+
+	// An atomic import with duplicates creates nothing.
+	results, err := r.ImportCompanies(ctx, companies, true, "conformance")
+	if err != nil || len(results) != len(companies) {
+		t.Fatalf("atomic import: want %d results; got %v, %v", len(companies), results, err)
+	}
+	for i, result := range results {
+		wantError(t, "atomic import of "+companies[i].Name, result.Err, wantErrs[i])
+		if result.ID != uuid.Nil {
+			t.Errorf("atomic import of %s: want no id; got %s", companies[i].Name, result.ID)
+		}
+	}
+	matches, _, err := r.GetAllCompanies(ctx, data.CompanyQuery{Name: "Conf Import"}, data.Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: data.CompanySortSafelist})
+	if err != nil || len(matches) != 0 {
+		t.Fatalf("want no company after a rejected atomic import; got %s, %v", names(matches), err)
+	}
+
+	// A best-effort import creates the other companies, with their first revision.
+	results, err = r.ImportCompanies(ctx, companies, false, "conformance")
+	if err != nil || len(results) != len(companies) {
+		t.Fatalf("best-effort import: want %d results; got %v, %v", len(companies), results, err)
+	}
+	for i, result := range results {
+		wantError(t, "best-effort import of "+companies[i].Name, result.Err, wantErrs[i])
+		if result.Err != nil {
+			continue
+		}
+		company, err := r.GetCompany(ctx, result.ID)
+		if err != nil || company.Name != companies[i].Name || company.Version != 1 {
+			t.Errorf("get imported %s: got %+v, %v", companies[i].Name, company, err)
+		}
+		revision, err := r.GetCompanyRevision(ctx, result.ID, 1)
+		if err != nil || revision.Operation != data.OperationCreated || revision.Actor != "conformance" {
+			t.Errorf("first revision of imported %s: got %+v, %v", companies[i].Name, revision, err)
+		}
+	}
+}
+
+func testPartialUpdate(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	company := create(t, r, newCompany("Conf Update"))
+	update := *company
+	update.Name = "Conf Updated"
+	update.Description = data.CompanyDescription{String: "New description", Valid: true}
+	update.Employees = 42
+	update.Type = "NonProfit"
+	// A nil Registered keeps the current value.
+	update.Registered = nil
+	if err := r.UpdateCompany(ctx, &update, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+	if update.Version != 2 {
+		t.Errorf("want the version of the updated company to be set to 2; got %d", update.Version)
+	}
+	got, err := r.GetCompany(ctx, company.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Conf Updated" || got.Description.String != "New description" || got.Employees != 42 || got.Type != "NonProfit" || got.Version != 2 {
+		t.Errorf("want the updated fields; got %+v", got)
+	}
+	if got.Registered == nil || !*got.Registered {
+		t.Errorf("want registered to be kept; got %v", got.Registered)
+	}
+
+	update.Registered = boolPtr(false)
+	if err := r.UpdateCompany(ctx, &update, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.GetCompany(ctx, company.ID); got.Registered == nil || *got.Registered {
+		t.Errorf("want registered to be updated to false; got %v", got.Registered)
+	}
+
+	// The company has changed since it was read.
+	wantError(t, "update with stale version", r.UpdateCompany(ctx, company, "conformance"), data.ErrEditConflict)
+}
+
+func testDeleteAndRestore(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	company := create(t, r, newCompany("Conf Delete"))
+	wantError(t, "delete with stale version", r.DeleteCompany(ctx, company.ID, company.Version+1, "conformance"), data.ErrEditConflict)
+	wantError(t, "delete", r.DeleteCompany(ctx, company.ID, company.Version, "conformance"), nil)
+	_, err := r.GetCompany(ctx, company.ID)
+	wantError(t, "get deleted", err, data.ErrRecordNotFound)
+	wantError(t, "delete deleted", r.DeleteCompany(ctx, company.ID, company.Version+1, "conformance"), data.ErrRecordNotFound)
+	company.Version++
+	wantError(t, "update deleted", r.UpdateCompany(ctx, company, "conformance"), data.ErrRecordNotFound)
+
+	filters := data.Filters{Page: 1, PageSize: 100, Sort: "-deleted_at", SortSafelist: data.CompanyTrashSortSafelist}
+	deleted, _, err := r.GetDeletedCompanies(ctx, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, d := range deleted {
+		if d.ID == company.ID {
+			found = d.DeletedAt != nil && d.Version == 2
+		}
+	}
+	if !found {
+		t.Errorf("want the company in the trash with its deletion time and version 2; got %s", names(deleted))
+	}
+	companies, _, err := r.GetAllCompanies(ctx, data.CompanyQuery{Name: "Conf Delete"}, data.Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: data.CompanySortSafelist})
+	if err != nil || len(companies) != 0 {
+		t.Errorf("want deleted companies excluded from listings; got %s, %v", names(companies), err)
+	}
+
+	wantError(t, "restore", r.RestoreCompany(ctx, company.ID, "conformance"), nil)
+	got, err := r.GetCompany(ctx, company.ID)
+	if err != nil || got.Version != 3 || got.DeletedAt != nil {
+		t.Errorf("want the restored company with version 3; got %+v, %v", got, err)
+	}
+	wantError(t, "restore not deleted", r.RestoreCompany(ctx, company.ID, "conformance"), data.ErrRecordNotFound)
+}
+
+func testPurge(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	kept := create(t, r, newCompany("Conf Kept"))
+	purged := create(t, r, newCompany("Conf Purged"))
+	wantError(t, "delete", r.DeleteCompany(ctx, purged.ID, purged.Version, "conformance"), nil)
+
+	// Companies deleted after the given time are kept.
+	if _, err := r.PurgeDeletedCompanies(ctx, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	wantError(t, "restore before purge", r.RestoreCompany(ctx, purged.ID, "conformance"), nil)
+	wantError(t, "delete again", r.DeleteCompany(ctx, purged.ID, purged.Version+2, "conformance"), nil)
+
+	n, err := r.PurgeDeletedCompanies(ctx, time.Now().Add(time.Hour))
+	if err != nil || n < 1 {
+		t.Fatalf("want at least the deleted company to be purged; got %d, %v", n, err)
+	}
+	wantError(t, "restore purged", r.RestoreCompany(ctx, purged.ID, "conformance"), data.ErrRecordNotFound)
+	if _, err := r.GetCompany(ctx, kept.ID); err != nil {
+		t.Errorf("want the other companies to be kept; got %v", err)
+	}
+	// The name of a purged company can be used again.
+	create(t, r, newCompany("Conf Purged"))
+}
+
+func testList(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	for _, c := range []struct {
+		name       string
+		employees  int
+		registered bool
+		kind       string
+	}{
+		{"Conf List A", 5, true, "Corporations"},
+		{"Conf List B", 50, false, "NonProfit"},
+		{"Conf List C", 500, true, "Cooperative"},
+		{"Conf_Wild%", 5, true, "Corporations"},
+	} {
+		company := newCompany(c.name)
+		company.Employees, company.Registered, company.Type = c.employees, boolPtr(c.registered), c.kind
+		create(t, r, company)
+	}
+	tests := []struct {
+		name      string
+		query     data.CompanyQuery
+		filters   data.Filters
+		want      string
+		wantTotal int
+	}{
+		{"Name case insensitive", data.CompanyQuery{Name: "conf list"}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "Conf List A,Conf List B,Conf List C", 3},
+		{"Descending", data.CompanyQuery{Name: "Conf List"}, data.Filters{Page: 1, PageSize: 20, Sort: "-employees"}, "Conf List C,Conf List B,Conf List A", 3},
+		{"Paged", data.CompanyQuery{Name: "Conf List"}, data.Filters{Page: 2, PageSize: 2, Sort: "name"}, "Conf List C", 3},
+		{"Type", data.CompanyQuery{Name: "Conf List", Type: "NonProfit"}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "Conf List B", 1},
+		{"Registered", data.CompanyQuery{Name: "Conf List", Registered: boolPtr(true)}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "Conf List A,Conf List C", 2},
+		{"Employees range", data.CompanyQuery{Name: "Conf List", MinEmployees: 10, MaxEmployees: 100}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "Conf List B", 1},
+		{"No match", data.CompanyQuery{Name: "Conf Nothing"}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "", 0},
+		{"Literal percent", data.CompanyQuery{Name: "%"}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "Conf_Wild%", 1},
+		{"Literal underscore", data.CompanyQuery{Name: "conf_"}, data.Filters{Page: 1, PageSize: 20, Sort: "name"}, "Conf_Wild%", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.SortSafelist = data.CompanySortSafelist
+			companies, metadata, err := r.GetAllCompanies(ctx, tt.query, tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(companies); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+			if companies == nil {
+				t.Error("want an empty slice; got nil")
+			}
+			if metadata.TotalRecords != tt.wantTotal {
+				t.Errorf("want %d records; got %d", tt.wantTotal, metadata.TotalRecords)
+			}
+		})
+	}
+}
+
+func testCursor(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	for i, name := range []string{"Conf Page A", "Conf Page B", "Conf Page C", "Conf Page D", "Conf Page E"} {
+		company := newCompany(name)
+		company.Employees = 10 * (i%2 + 1)
+		create(t, r, company)
+	}
+	for _, sortValue := range []string{"name", "-name", "employees", "-employees"} {
+		sortValue := sortValue
+		t.Run(sortValue, func(t *testing.T) {
+			query := data.CompanyQuery{Name: "Conf Page"}
+			all, _, err := r.GetAllCompanies(ctx, query, data.Filters{Page: 1, PageSize: 20, Sort: sortValue, SortSafelist: data.CompanySortSafelist})
+			if err != nil {
+				t.Fatal(err)
+			}
+			filters := data.KeysetFilters{Sort: sortValue, SortSafelist: data.CompanySortSafelist, PageSize: 2}
+			var walked []*data.Company
+			var pages []string
+			var prev *data.Cursor
+			for {
+				page, cursors, err := r.GetCompaniesByCursor(ctx, query, filters)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (filters.Cursor == nil) != (cursors.Prev == nil) {
+					t.Errorf("want a prev cursor on every page but the first; got %+v", cursors.Prev)
+				}
+				walked = append(walked, page...)
+				pages = append(pages, names(page))
+				prev = cursors.Prev
+				if cursors.Next == nil || len(pages) > len(all) {
+					break
+				}
+				filters.Cursor = cursors.Next
+			}
+			// Companies with the same sort value may come in any order.
+			if sortedNames(walked) != sortedNames(all) {
+				t.Fatalf("want the pages to hold %q; got %q", names(all), names(walked))
+			}
+			for i := 1; i < len(walked); i++ {
+				if outOfOrder(sortValue, walked[i-1], walked[i]) {
+					t.Errorf("want the pages sorted by %s; got %q", sortValue, names(walked))
+				}
+			}
+			// Walk back from the last page.
+			filters.Cursor = prev
+			page, cursors, err := r.GetCompaniesByCursor(ctx, query, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := names(page); got != pages[len(pages)-2] {
+				t.Errorf("want the previous page %q; got %q", pages[len(pages)-2], got)
+			}
+			if cursors.Next == nil {
+				t.Error("want a next cursor on the previous page; got nil")
+			}
+		})
+	}
+}
+
+func testExport(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	for i, name := range []string{"Conf Export C", "Conf Export A", "Conf Export B", "Conf Export D"} {
+		company := newCompany(name)
+		company.Employees = 10 * (i + 1)
+		if i == 3 {
+			company.Type = "NonProfit"
+		}
+		created := create(t, r, company)
+		if i == 2 {
+			wantError(t, "delete", r.DeleteCompany(ctx, created.ID, created.Version, "conformance"), nil)
+		}
+	}
+	export := func(query data.CompanyQuery) ([]*data.Company, error) {
+		var companies []*data.Company
+		err := r.ExportCompanies(ctx, query, func(company *data.Company) error {
+			companies = append(companies, company)
+			return nil
+		})
+		return companies, err
+	}
+
+	companies, err := export(data.CompanyQuery{Name: "Conf Export", Type: "Corporations"})
+	if err != nil || names(companies) != "Conf Export A,Conf Export C" {
+		t.Errorf("export by type: want Conf Export A,Conf Export C sorted by name; got %s, %v", names(companies), err)
+	}
+	companies, err = export(data.CompanyQuery{Name: "Conf Export", MinEmployees: 20, MaxEmployees: 40})
+	if err != nil || names(companies) != "Conf Export A,Conf Export D" {
+		t.Errorf("export by employees: want Conf Export A,Conf Export D; got %s, %v", names(companies), err)
+	}
+	if len(companies) > 0 && (companies[0].Version != 1 || companies[0].Description.String != "Conf Export A description") {
+		t.Errorf("want the exported companies to be complete; got %+v", companies[0])
+	}
+
+	// The export stops at the first error of fn.
+	stop := errors.New("stop")
+	calls := 0
+	err = r.ExportCompanies(ctx, data.CompanyQuery{Name: "Conf Export"}, func(company *data.Company) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("want the export to stop after the first company; got %d calls, %v", calls, err)
+	}
+}
+
+func testSearch(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	inName := newCompany("Conf Quasar")
+	inName.Description = data.CompanyDescription{String: "Telescopes and mirrors", Valid: true}
+	inDescription := newCompany("Conf Optics")
+	inDescription.Description = data.CompanyDescription{String: "Lenses to observe a quasar", Valid: true}
+	deleted := newCompany("Conf Quasar Two")
+	create(t, r, inName)
+	create(t, r, inDescription)
+	d := create(t, r, deleted)
+	wantError(t, "delete", r.DeleteCompany(ctx, d.ID, d.Version, "conformance"), nil)
+
+	filters := data.Filters{Page: 1, PageSize: 20, Sort: "-rank", SortSafelist: data.CompanySearchSortSafelist}
+	results, metadata, err := r.SearchCompanies(ctx, "quasar", filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || metadata.TotalRecords != 2 {
+		t.Fatalf("want 2 results, deleted companies excluded; got %d", len(results))
+	}
+	if results[0].Company.Name != "Conf Quasar" || results[0].Rank <= results[1].Rank {
+		t.Errorf("want the match on the name ranked first; got %s (%v), %s (%v)",
+			results[0].Company.Name, results[0].Rank, results[1].Company.Name, results[1].Rank)
+	}
+	if !strings.Contains(results[0].NameSnippet, "<mark>Quasar</mark>") || !strings.Contains(results[1].DescriptionSnippet, "<mark>quasar</mark>") {
+		t.Errorf("want the matching words highlighted; got %q, %q", results[0].NameSnippet, results[1].DescriptionSnippet)
+	}
+
+	tests := []struct {
+		name string
+		q    string
+		want int
+	}{
+		{"All words must match", "quasar telescopes", 1},
+		{"Phrase", `"observe a quasar"`, 1},
+		{"Prefix", "telesc*", 1},
+		{"No match", "quasar submarine", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, _, err := r.SearchCompanies(ctx, tt.q, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != tt.want {
+				t.Errorf("want %d results; got %d", tt.want, len(results))
+			}
+		})
+	}
+}
+
+func testRevisionsAndRollback(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	company := create(t, r, newCompany("Conf History"))
+	update := *company
+	update.Name = "Conf History 2"
+	update.Employees = 20
+	if err := r.UpdateCompany(ctx, &update, "jane"); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, metadata, err := r.GetCompanyRevisions(ctx, company.ID, data.Filters{Page: 1, PageSize: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || metadata.TotalRecords != 2 {
+		t.Fatalf("want 2 revisions; got %d", len(revisions))
+	}
+	latest := revisions[0]
+	if latest.Revision != 2 || latest.Operation != data.OperationUpdated || latest.Actor != "jane" || latest.Snapshot.Name != "Conf History 2" {
+		t.Errorf("want the update as latest revision; got %+v", latest)
+	}
+	changed := map[string]bool{}
+	for _, change := range latest.Changes {
+		changed[change.Field] = true
+	}
+	if len(changed) != 2 || !changed["name"] || !changed["employees"] {
+		t.Errorf("want the name and employees changes; got %+v", latest.Changes)
+	}
+	first, err := r.GetCompanyRevision(ctx, company.ID, 1)
+	if err != nil || first.Operation != data.OperationCreated || first.Snapshot.Name != "Conf History" {
+		t.Errorf("want the creation as first revision; got %+v, %v", first, err)
+	}
+	_, err = r.GetCompanyRevision(ctx, company.ID, 3)
+	wantError(t, "get unknown revision", err, data.ErrRecordNotFound)
+
+	// The name of the revision is used by another company.
+	other := create(t, r, newCompany("Conf Taken"))
+	rename := *other
+	rename.Name = "Conf History"
+	if err := r.UpdateCompany(ctx, &rename, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = r.RollbackCompany(ctx, company.ID, 1, 2, "jane")
+	wantError(t, "rollback to a used name", err, data.ErrDuplicateName)
+	rename.Name = "Conf Renamed"
+	if err := r.UpdateCompany(ctx, &rename, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.RollbackCompany(ctx, company.ID, 1, 1, "jane")
+	wantError(t, "rollback a stale version", err, data.ErrEditConflict)
+	rolledBack, err := r.RollbackCompany(ctx, company.ID, 1, 2, "jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack.Name != "Conf History" || rolledBack.Employees != 10 || rolledBack.Version != 3 {
+		t.Errorf("want the company at revision 1 with version 3; got %+v", rolledBack)
+	}
+	revision, err := r.GetCompanyRevision(ctx, company.ID, 3)
+	if err != nil || revision.Operation != data.OperationRolledBack {
+		t.Errorf("want the rollback recorded as revision 3; got %+v, %v", revision, err)
+	}
+	_, err = r.RollbackCompany(ctx, company.ID, 9, 3, "jane")
+	wantError(t, "rollback to unknown revision", err, data.ErrRecordNotFound)
+
+	// The revisions of deleted companies remain available, but they cannot be rolled back.
+	wantError(t, "delete", r.DeleteCompany(ctx, company.ID, 3, "conformance"), nil)
+	if _, _, err := r.GetCompanyRevisions(ctx, company.ID, data.Filters{Page: 1, PageSize: 20}); err != nil {
+		t.Errorf("want the revisions of a deleted company; got %v", err)
+	}
+	_, err = r.RollbackCompany(ctx, company.ID, 1, 4, "jane")
+	wantError(t, "rollback deleted", err, data.ErrRecordNotFound)
+}
+
+func testAsOf(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	beforeCreate := time.Now().Add(-time.Minute)
+	company := create(t, r, newCompany("Conf AsOf"))
+	time.Sleep(10 * time.Millisecond)
+	afterCreate := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	update := *company
+	update.Employees = 99
+	if err := r.UpdateCompany(ctx, &update, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := r.GetCompanyAsOf(ctx, company.ID, beforeCreate)
+	wantError(t, "before creation", err, data.ErrRecordNotFound)
+	got, err := r.GetCompanyAsOf(ctx, company.ID, afterCreate)
+	if err != nil || got.Employees != 10 || got.Version != 1 {
+		t.Errorf("want the company before the update; got %+v, %v", got, err)
+	}
+	got, err = r.GetCompanyAsOf(ctx, company.ID, time.Now().Add(time.Minute))
+	if err != nil || got.Employees != 99 || got.Version != 2 {
+		t.Errorf("want the current company; got %+v, %v", got, err)
+	}
+
+	wantError(t, "delete", r.DeleteCompany(ctx, company.ID, 2, "conformance"), nil)
+	_, err = r.GetCompanyAsOf(ctx, company.ID, time.Now().Add(time.Minute))
+	wantError(t, "after deletion", err, data.ErrRecordNotFound)
+}
+
+// newAddress returns a valid address of the company.
+func newAddress(companyID uuid.UUID, addressType string) *data.Address {
+	return &data.Address{CompanyID: companyID, Type: addressType, Lines: []string{"1 Main Street", "Floor 2"}, City: "Lisbon", PostalCode: "1100-148", Country: "PT"}
+}
+
+func testAddresses(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	company := create(t, r, newCompany("Conf Address"))
+	_, err := r.GetCompanyAddresses(ctx, unknownID)
+	wantError(t, "list addresses of unknown company", err, data.ErrRecordNotFound)
+	wantError(t, "create address of unknown company", r.CreateCompanyAddress(ctx, newAddress(unknownID, data.AddressOperating), "conformance"), data.ErrRecordNotFound)
+
+	registered := newAddress(company.ID, data.AddressRegistered)
+	wantError(t, "create registered address", r.CreateCompanyAddress(ctx, registered, "conformance"), nil)
+	if registered.ID == uuid.Nil || registered.CreatedAt.IsZero() {
+		t.Fatalf("want the id and the timestamps of the created address to be set; got %+v", registered)
+	}
+	operating := newAddress(company.ID, data.AddressOperating)
+	wantError(t, "create operating address", r.CreateCompanyAddress(ctx, operating, "conformance"), nil)
+	wantError(t, "create second registered address", r.CreateCompanyAddress(ctx, newAddress(company.ID, data.AddressRegistered), "conformance"), data.ErrDuplicateAddress)
+
+	got, err := r.GetCompanyAddress(ctx, company.ID, registered.ID)
+	if err != nil || got.City != "Lisbon" || strings.Join(got.Lines, "|") != "1 Main Street|Floor 2" {
+		t.Errorf("get address: got %+v, %v", got, err)
+	}
+	_, err = r.GetCompanyAddress(ctx, unknownID, registered.ID)
+	wantError(t, "get address of another company", err, data.ErrRecordNotFound)
+
+	operating.Type = data.AddressRegistered
+	wantError(t, "update to a second registered address", r.UpdateCompanyAddress(ctx, operating, "conformance"), data.ErrDuplicateAddress)
+	operating.Type = data.AddressBilling
+	operating.City = "Porto"
+	wantError(t, "update address", r.UpdateCompanyAddress(ctx, operating, "conformance"), nil)
+	addresses, err := r.GetCompanyAddresses(ctx, company.ID)
+	if err != nil || len(addresses) != 2 || addresses[0].ID != registered.ID || addresses[1].City != "Porto" || addresses[1].Type != data.AddressBilling {
+		t.Errorf("list addresses: want the registered address then the updated billing one; got %+v, %v", addresses, err)
+	}
+
+	wantError(t, "delete address", r.DeleteCompanyAddress(ctx, company.ID, registered.ID, "conformance"), nil)
+	wantError(t, "delete deleted address", r.DeleteCompanyAddress(ctx, company.ID, registered.ID, "conformance"), data.ErrRecordNotFound)
+	wantError(t, "create registered address after deletion", r.CreateCompanyAddress(ctx, newAddress(company.ID, data.AddressRegistered), "conformance"), nil)
+
+	// The addresses of a deleted company are not accessible.
+	wantError(t, "delete company", r.DeleteCompany(ctx, company.ID, company.Version, "conformance"), nil)
+	_, err = r.GetCompanyAddresses(ctx, company.ID)
+	wantError(t, "list addresses of deleted company", err, data.ErrRecordNotFound)
+	_, err = r.GetCompanyAddress(ctx, company.ID, operating.ID)
+	wantError(t, "get address of deleted company", err, data.ErrRecordNotFound)
+}
+
+// tagCounts returns the tags of the conformance suite with their number of companies,
+// formatted as name=count.
+func tagCounts(t *testing.T, r CompanyRepository) string {
+	t.Helper()
+	tags, err := r.GetTags(context.Background())
+	if err != nil {
+		t.Fatalf("get tags: %v", err)
+	}
+	var counts []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag.Name, "conf-") {
+			counts = append(counts, fmt.Sprintf("%s=%d", tag.Name, tag.Companies))
+		}
+	}
+	return strings.Join(counts, ",")
+}
+
+func testTags(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	a := create(t, r, newCompany("Conf Tag A"))
+	b := create(t, r, newCompany("Conf Tag B"))
+	create(t, r, newCompany("Conf Tag C"))
+	_, err := r.AddCompanyTag(ctx, unknownID, "conf-strategic", "conformance")
+	wantError(t, "tag unknown company", err, data.ErrRecordNotFound)
+
+	for _, tag := range []struct {
+		company *data.Company
+		name    string
+	}{{a, "conf-strategic"}, {a, "conf-eu-pilot"}, {b, "conf-strategic"}} {
+		added, err := r.AddCompanyTag(ctx, tag.company.ID, tag.name, "conformance")
+		if err != nil || !added {
+			t.Fatalf("tag %s with %s: want added; got %v, %v", tag.company.Name, tag.name, added, err)
+		}
+	}
+	if added, err := r.AddCompanyTag(ctx, a.ID, "conf-strategic", "conformance"); err != nil || added {
+		t.Errorf("tag again: want not added; got %v, %v", added, err)
+	}
+	// Every change of the tags increments the version, so that the ETag changes.
+	got, err := r.GetCompany(ctx, a.ID)
+	if err != nil || strings.Join(got.Tags, ",") != "conf-eu-pilot,conf-strategic" || got.Version != 3 {
+		t.Errorf("get tagged company: want its tags sorted by name at version 3; got %+v, %v", got, err)
+	}
+	if counts := tagCounts(t, r); counts != "conf-eu-pilot=1,conf-strategic=2" {
+		t.Errorf("tags: want conf-eu-pilot=1,conf-strategic=2; got %s", counts)
+	}
+
+	query := data.CompanyQuery{Name: "Conf Tag", Tag: "conf-strategic"}
+	companies, metadata, err := r.GetAllCompanies(ctx, query, data.Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: data.CompanySortSafelist})
+	if err != nil || names(companies) != "Conf Tag A,Conf Tag B" || metadata.TotalRecords != 2 {
+		t.Errorf("list by tag: want Conf Tag A,Conf Tag B; got %s, %+v, %v", names(companies), metadata, err)
+	}
+	companies, _, err = r.GetCompaniesByCursor(ctx, data.CompanyQuery{Tag: "conf-eu-pilot"}, data.KeysetFilters{PageSize: 20, Sort: "name", SortSafelist: data.CompanySortSafelist})
+	if err != nil || names(companies) != "Conf Tag A" {
+		t.Errorf("list by tag with cursor: want Conf Tag A; got %s, %v", names(companies), err)
+	}
+
+	wantError(t, "untag", r.RemoveCompanyTag(ctx, b.ID, "conf-strategic", "conformance"), nil)
+	wantError(t, "untag again", r.RemoveCompanyTag(ctx, b.ID, "conf-strategic", "conformance"), data.ErrRecordNotFound)
+	if got, err := r.GetCompany(ctx, b.ID); err != nil || len(got.Tags) != 0 || got.Version != 3 {
+		t.Errorf("get untagged company: want no tags at version 3; got %+v, %v", got, err)
+	}
+
+	// Every version has its revision, whose snapshot leaves the tags out, and deleted
+	// companies are not counted.
+	revision, err := r.GetCompanyRevision(ctx, a.ID, a.Version)
+	if err != nil || len(revision.Snapshot.Tags) != 0 {
+		t.Errorf("revision of tagged company: want no tags; got %+v, %v", revision, err)
+	}
+	revision, err = r.GetCompanyRevision(ctx, a.ID, got.Version)
+	if err != nil || revision.Operation != data.OperationUpdated || len(revision.Changes) != 1 || revision.Changes[0].Field != "tags" ||
+		revision.Actor != "conformance" {
+		t.Errorf("revision of tag change: want an update of the tags by conformance; got %+v, %v", revision, err)
+	}
+	if _, metadata, err := r.GetCompanyRevisions(ctx, a.ID, data.Filters{Page: 1, PageSize: 20}); err != nil || metadata.TotalRecords != 3 {
+		t.Errorf("revisions of tagged company: want 3; got %+v, %v", metadata, err)
+	}
+	wantError(t, "delete company", r.DeleteCompany(ctx, a.ID, got.Version, "conformance"), nil)
+	if counts := tagCounts(t, r); counts != "" {
+		t.Errorf("tags after deletion: want none; got %s", counts)
+	}
+	_, err = r.AddCompanyTag(ctx, a.ID, "conf-strategic", "conformance")
+	wantError(t, "tag deleted company", err, data.ErrRecordNotFound)
+}
+
+// nodes returns the names of the companies of a hierarchy with their depth, formatted
+// as name:depth.
+func nodes(companies []*data.CompanyNode) string {
+	var nodes []string
+	for _, company := range companies {
+		nodes = append(nodes, fmt.Sprintf("%s:%d", company.Name, company.Depth))
+	}
+	return strings.Join(nodes, ",")
+}
+
+func testHierarchy(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	a := create(t, r, newCompany("Conf Tree A"))
+	b := create(t, r, newCompany("Conf Tree B"))
+	c := create(t, r, newCompany("Conf Tree C"))
+	d := create(t, r, newCompany("Conf Tree D"))
+	wantError(t, "set parent of unknown company", r.SetCompanyParent(ctx, unknownID, a.ID, "conformance"), data.ErrRecordNotFound)
+	wantError(t, "set unknown parent", r.SetCompanyParent(ctx, b.ID, unknownID, "conformance"), data.ErrParentNotFound)
+	wantError(t, "set parent to itself", r.SetCompanyParent(ctx, a.ID, a.ID, "conformance"), data.ErrHierarchyCycle)
+
+	// A has the subsidiaries B and D, and B has the subsidiary C.
+	wantError(t, "set parent of B", r.SetCompanyParent(ctx, b.ID, a.ID, "conformance"), nil)
+	wantError(t, "set parent of C", r.SetCompanyParent(ctx, c.ID, b.ID, "conformance"), nil)
+	wantError(t, "set parent of D", r.SetCompanyParent(ctx, d.ID, a.ID, "conformance"), nil)
+	wantError(t, "set same parent", r.SetCompanyParent(ctx, b.ID, a.ID, "conformance"), nil)
+	wantError(t, "set subsidiary as parent", r.SetCompanyParent(ctx, a.ID, c.ID, "conformance"), data.ErrHierarchyCycle)
+	// Every change of the parent increments the version, so that the ETag changes.
+	got, err := r.GetCompany(ctx, c.ID)
+	if err != nil || got.ParentID == nil || *got.ParentID != b.ID || got.Version != 2 {
+		t.Errorf("get subsidiary: want parent %s at version 2; got %+v, %v", b.ID, got, err)
+	}
+
+	subsidiaries, err := r.GetSubsidiaries(ctx, a.ID, 1)
+	if err != nil || nodes(subsidiaries) != "Conf Tree B:1,Conf Tree D:1" {
+		t.Errorf("direct subsidiaries: want Conf Tree B:1,Conf Tree D:1; got %s, %v", nodes(subsidiaries), err)
+	}
+	subsidiaries, err = r.GetSubsidiaries(ctx, a.ID, 2)
+	if err != nil || nodes(subsidiaries) != "Conf Tree B:1,Conf Tree D:1,Conf Tree C:2" {
+		t.Errorf("subsidiaries: want Conf Tree B:1,Conf Tree D:1,Conf Tree C:2; got %s, %v", nodes(subsidiaries), err)
+	}
+	ancestors, err := r.GetAncestors(ctx, c.ID)
+	if err != nil || nodes(ancestors) != "Conf Tree B:1,Conf Tree A:2" {
+		t.Errorf("ancestors: want Conf Tree B:1,Conf Tree A:2; got %s, %v", nodes(ancestors), err)
+	}
+	_, err = r.GetSubsidiaries(ctx, unknownID, 1)
+	wantError(t, "subsidiaries of unknown company", err, data.ErrRecordNotFound)
+	_, err = r.GetAncestors(ctx, unknownID)
+	wantError(t, "ancestors of unknown company", err, data.ErrRecordNotFound)
+
+	// A company with subsidiaries cannot be deleted, and a restored company loses
+	// its parent if the parent has been deleted too.
+	wantError(t, "delete parent", r.DeleteCompany(ctx, b.ID, 2, "conformance"), data.ErrHasSubsidiaries)
+	wantError(t, "delete C", r.DeleteCompany(ctx, c.ID, 2, "conformance"), nil)
+	wantError(t, "delete B", r.DeleteCompany(ctx, b.ID, 2, "conformance"), nil)
+	wantError(t, "restore C", r.RestoreCompany(ctx, c.ID, "conformance"), nil)
+	if got, err := r.GetCompany(ctx, c.ID); err != nil || got.ParentID != nil {
+		t.Errorf("restored company: want no parent; got %+v, %v", got, err)
+	}
+	wantError(t, "set deleted parent", r.SetCompanyParent(ctx, c.ID, b.ID, "conformance"), data.ErrParentNotFound)
+
+	wantError(t, "clear parent", r.ClearCompanyParent(ctx, d.ID, "conformance"), nil)
+	wantError(t, "clear cleared parent", r.ClearCompanyParent(ctx, d.ID, "conformance"), data.ErrRecordNotFound)
+	if ancestors, err := r.GetAncestors(ctx, d.ID); err != nil || len(ancestors) != 0 {
+		t.Errorf("ancestors of top-level company: want none; got %s, %v", nodes(ancestors), err)
+	}
+	if got, err := r.GetCompany(ctx, d.ID); err != nil || got.Version != 3 {
+		t.Errorf("top-level company: want version 3; got %+v, %v", got, err)
+	}
+	revision, err := r.GetCompanyRevision(ctx, d.ID, 3)
+	if err != nil || len(revision.Changes) != 1 || revision.Changes[0].Field != "parent_id" || revision.Actor != "conformance" {
+		t.Errorf("revision of parent change: want a change of the parent by conformance; got %+v, %v", revision, err)
+	}
+	wantError(t, "delete A", r.DeleteCompany(ctx, a.ID, a.Version, "conformance"), nil)
+}
+
+// attributes decodes the attributes of a JSON object.
+func attributes(t *testing.T, document string) data.CompanyAttributes {
+	t.Helper()
+	var a data.CompanyAttributes
+	if err := json.Unmarshal([]byte(document), &a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// lookup returns the names of the companies whose attribute key is equal to value.
+func lookup(t *testing.T, r CompanyRepository, key, value string) string {
+	t.Helper()
+	companies, _, err := r.GetCompaniesByAttribute(context.Background(), key, value,
+		data.Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: data.CompanySortSafelist})
+	if err != nil {
+		t.Fatalf("lookup %s=%s: %v", key, value, err)
+	}
+	return names(companies)
+}
+
+func testAttributes(t *testing.T, r CompanyRepository) {
+	ctx := context.Background()
+	company := newCompany("Conf Attr")
+	company.Attributes = attributes(t, `{"crm_id": "CRM-7", "seats": 42, "segment": "smb", "regions": ["PT", "NL"]}`)
+	company = create(t, r, company)
+	if got, _ := json.Marshal(company.Attributes); string(got) != `{"crm_id":"CRM-7","regions":["PT","NL"],"seats":42,"segment":"smb"}` {
+		t.Errorf("get: want the created attributes; got %s", got)
+	}
+	other := newCompany("Conf Attr 42")
+	other.Attributes = attributes(t, `{"crm_id": "42"}`)
+	other = create(t, r, other)
+	if plain := create(t, r, newCompany("Conf Attr None")); plain.Attributes != nil {
+		t.Errorf("get: want no attributes; got %v", plain.Attributes)
+	}
+
+	// The value matches the strings, and the numbers and booleans it spells.
+	for _, tt := range []struct{ key, value, want string }{
+		{"crm_id", "CRM-7", "Conf Attr"},
+		{"seats", "42", "Conf Attr"},
+		{"seats", "42.0", "Conf Attr"},
+		{"crm_id", "42", "Conf Attr 42"},
+		{"segment", "SMB", ""},
+		{"regions", "PT", ""},
+		{"unknown", "CRM-7", ""},
+	} {
+		if got := lookup(t, r, tt.key, tt.value); got != tt.want {
+			t.Errorf("lookup %s=%s: want %q; got %q", tt.key, tt.value, tt.want, got)
+		}
+	}
+
+	// The attributes are replaced as a whole, and their changes are recorded in the
+	// revisions like the other fields.
+	update := *company
+	update.Attributes = attributes(t, `{"crm_id": "CRM-8"}`)
+	if err := r.UpdateCompany(ctx, &update, "conformance"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.GetCompany(ctx, company.ID); err != nil || len(got.Attributes) != 1 || got.Attributes["crm_id"] != "CRM-8" {
+		t.Errorf("update: want the replaced attributes; got %+v, %v", got, err)
+	}
+	if got := lookup(t, r, "seats", "42"); got != "" {
+		t.Errorf("lookup removed attribute: want none; got %q", got)
+	}
+	revision, err := r.GetCompanyRevision(ctx, company.ID, 2)
+	if err != nil || len(revision.Changes) != 1 || revision.Changes[0].Field != "attributes" {
+		t.Errorf("revision: want the attributes change; got %+v, %v", revision, err)
+	}
+	rolledBack, err := r.RollbackCompany(ctx, company.ID, 1, 2, "conformance")
+	if err != nil || rolledBack.Attributes["segment"] != "smb" {
+		t.Errorf("rollback: want the attributes of revision 1; got %+v, %v", rolledBack, err)
+	}
+	if got, err := r.GetCompanyAsOf(ctx, company.ID, time.Now().Add(time.Minute)); err != nil || got.Attributes["crm_id"] != "CRM-7" {
+		t.Errorf("as of: want the current attributes; got %+v, %v", got, err)
+	}
+
+	wantError(t, "delete", r.DeleteCompany(ctx, other.ID, other.Version, "conformance"), nil)
+	if got := lookup(t, r, "crm_id", "42"); got != "" {
+		t.Errorf("lookup deleted company: want none; got %q", got)
+	}
+}
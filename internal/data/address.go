@@ -0,0 +1,360 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"mborgnolo/companyservice/internal/validator"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Address types.
+const (
+	AddressRegistered = "registered"
+	AddressOperating  = "operating"
+	AddressBilling    = "billing"
+)
+
+// ErrDuplicateAddress is returned when a company would have a second registered address.
+var ErrDuplicateAddress = errors.New("duplicate registered address")
+
+// Address is a postal address of a company. A company has at most one registered
+// address, and any number of operating and billing addresses.
+type Address struct {
+	ID         uuid.UUID `json:"id"`
+	CompanyID  uuid.UUID `json:"company_id"`
+	Type       string    `json:"type"`
+	Lines      []string  `json:"lines"`
+	City       string    `json:"city"`
+	PostalCode string    `json:"postal_code"`
+	Country    string    `json:"country"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ValidateAddress runs validation checks on the address data.
+func ValidateAddress(v *validator.Validator, address *Address) {
+	v.Check(v.In(address.Type, AddressRegistered, AddressOperating, AddressBilling), "type", "must be one of: registered, operating, billing")
+	v.Check(len(address.Lines) > 0, "lines", "must contain at least one street line")
+	v.Check(len(address.Lines) <= 3, "lines", "must not contain more than 3 street lines")
+	for _, line := range address.Lines {
+		v.Check(strings.TrimSpace(line) != "", "lines", "must not contain blank street lines")
+		v.Check(utf8.RuneCountInString(line) <= 100, "lines", "must not contain street lines of more than 100 characters")
+	}
+	v.Check(strings.TrimSpace(address.City) != "", "city", "is required")
+	v.Check(utf8.RuneCountInString(address.City) <= 100, "city", "must not be more than 100 characters")
+	v.Check(utf8.RuneCountInString(address.PostalCode) <= 20, "postal_code", "must not be more than 20 characters")
+	v.Check(validator.IsCountryCode(address.Country), "country", "must be an ISO 3166-1 alpha-2 country code")
+}
+
+// lockActiveCompany returns the company which is not deleted, locking its row until
+// the end of the transaction, so that it cannot be deleted while its addresses change.
+func lockActiveCompany(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*Company, error) {
+	company, err := getCompanyForUpdate(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if company.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+	return company, nil
+}
+
+// scanAddress scans the columns of an address selected by addressColumns.
+func scanAddress(scan func(dest ...interface{}) error, address *Address) error {
+	return scan(&address.ID, &address.CompanyID, &address.Type, pq.Array(&address.Lines), &address.City, &address.PostalCode,
+		&address.Country, &address.CreatedAt, &address.UpdatedAt)
+}
+
+const addressColumns = `a."id", a."company_id", a."type", a."lines", a."city", a."postal_code", a."country", a."created_at", a."updated_at"`
+
+// GetCompanyAddresses returns the addresses of a company, in the order they were
+// created. ErrRecordNotFound is returned if the company does not exist or is deleted.
+func (m *CompanyModel) GetCompanyAddresses(ctx context.Context, companyID uuid.UUID) ([]*Address, error) {
+	if _, err := m.GetCompany(ctx, companyID); err != nil {
+		return nil, err
+	}
+	query := `SELECT ` + addressColumns + ` FROM company_addresses a WHERE a.company_id = $1 ORDER BY a.created_at, a.id`
+	rows, err := m.DB.QueryContext(ctx, query, companyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	addresses := []*Address{}
+	for rows.Next() {
+		address := &Address{}
+		if err := scanAddress(rows.Scan, address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// GetCompanyAddress returns an address of a company. ErrRecordNotFound is returned if
+// the company does not exist, is deleted or has no such address.
+func (m *CompanyModel) GetCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID) (*Address, error) {
+	query := `SELECT ` + addressColumns + ` FROM company_addresses a JOIN company c ON c.id = a.company_id
+		WHERE a.id = $1 AND a.company_id = $2 AND c.deleted_at IS NULL`
+	address := &Address{}
+	err := scanAddress(m.DB.QueryRowContext(ctx, query, addressID, companyID).Scan, address)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return address, nil
+}
+
+// CreateCompanyAddress inserts a new address of a company, along with the
+// CompanyUpdated event in the outbox, and sets the id and the timestamps of the
+// provided address. actor is the user adding the address. ErrRecordNotFound is returned if the company does not exist or
+// is deleted, and ErrDuplicateAddress if it already has a registered address.
+func (m *CompanyModel) CreateCompanyAddress(ctx context.Context, address *Address, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	company, err := lockActiveCompany(ctx, tx, address.CompanyID)
+	if err != nil {
+		return err
+	}
+	id := uuid.New()
+	query := `INSERT INTO company_addresses ("id", "company_id", "type", "lines", "city", "postal_code", "country")
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING "created_at", "updated_at"`
+	err = tx.QueryRowContext(ctx, query, id, address.CompanyID, address.Type, pq.Array(address.Lines), address.City, address.PostalCode,
+		address.Country).Scan(&address.CreatedAt, &address.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateAddress
+		}
+		return err
+	}
+	created := copyAddress(address)
+	created.ID = id
+	if err = recordCompanyUpdated(ctx, tx, company, FieldChange{Field: "addresses", New: created}, actor); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	address.ID = id
+	return nil
+}
+
+// UpdateCompanyAddress replaces an address of a company with the provided one, along
+// with the CompanyUpdated event in the outbox, and sets the update time of the
+// provided address. actor is the user updating the address. ErrRecordNotFound is
+// returned if the company does not exist, is deleted or has no such address, and
+// ErrDuplicateAddress if the company would have a second registered address.
+func (m *CompanyModel) UpdateCompanyAddress(ctx context.Context, address *Address, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	company, err := lockActiveCompany(ctx, tx, address.CompanyID)
+	if err != nil {
+		return err
+	}
+	// The addresses only change while their company is locked, so the old address
+	// cannot change before it is updated.
+	old := &Address{}
+	query := `SELECT ` + addressColumns + ` FROM company_addresses a WHERE a.id = $1 AND a.company_id = $2`
+	if err = scanAddress(tx.QueryRowContext(ctx, query, address.ID, address.CompanyID).Scan, old); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	query = `UPDATE company_addresses SET "type" = $3, "lines" = $4, "city" = $5, "postal_code" = $6, "country" = $7, "updated_at" = NOW()
+		WHERE id = $1 AND company_id = $2 RETURNING "created_at", "updated_at"`
+	err = tx.QueryRowContext(ctx, query, address.ID, address.CompanyID, address.Type, pq.Array(address.Lines), address.City, address.PostalCode,
+		address.Country).Scan(&address.CreatedAt, &address.UpdatedAt)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			return ErrRecordNotFound
+		case isUniqueViolation(err):
+			return ErrDuplicateAddress
+		default:
+			return err
+		}
+	}
+	if err = recordCompanyUpdated(ctx, tx, company, FieldChange{Field: "addresses", Old: old, New: copyAddress(address)}, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteCompanyAddress removes an address of a company, along with the CompanyUpdated
+// event in the outbox. actor is the user removing the address. ErrRecordNotFound is
+// returned if the company does not exist, is deleted or has no such address.
+func (m *CompanyModel) DeleteCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	company, err := lockActiveCompany(ctx, tx, companyID)
+	if err != nil {
+		return err
+	}
+	old := &Address{}
+	query := `DELETE FROM company_addresses a WHERE a.id = $1 AND a.company_id = $2 RETURNING ` + addressColumns
+	if err = scanAddress(tx.QueryRowContext(ctx, query, addressID, companyID).Scan, old); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if err = recordCompanyUpdated(ctx, tx, company, FieldChange{Field: "addresses", Old: old}, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// copyAddress returns a deep copy of the address.
+func copyAddress(a *Address) *Address {
+	address := *a
+	address.Lines = append([]string{}, a.Lines...)
+	return &address
+}
+
+// SeedAddresses stores the addresses as is, bypassing the rules of the write methods.
+// It is meant for fixtures.
+func (m *MemoryModel) SeedAddresses(addresses ...*Address) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, address := range addresses {
+		m.addresses[address.CompanyID] = append(m.addresses[address.CompanyID], copyAddress(address))
+	}
+}
+
+// activeCompany reports whether the company exists and is not deleted. The caller
+// must hold the lock.
+func (m *MemoryModel) activeCompany(id uuid.UUID) bool {
+	company, ok := m.companies[id]
+	return ok && company.DeletedAt == nil
+}
+
+// registeredAddressTaken reports whether the company has a registered address other
+// than id, like the unique index of the company_addresses table.
+func (m *MemoryModel) registeredAddressTaken(companyID, id uuid.UUID) bool {
+	for _, address := range m.addresses[companyID] {
+		if address.Type == AddressRegistered && address.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCompanyAddresses returns the addresses of a company, in the order they were created.
+func (m *MemoryModel) GetCompanyAddresses(ctx context.Context, companyID uuid.UUID) ([]*Address, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.activeCompany(companyID) {
+		return nil, ErrRecordNotFound
+	}
+	addresses := []*Address{}
+	for _, address := range m.addresses[companyID] {
+		addresses = append(addresses, copyAddress(address))
+	}
+	return addresses, nil
+}
+
+// GetCompanyAddress returns an address of a company.
+func (m *MemoryModel) GetCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID) (*Address, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.activeCompany(companyID) {
+		return nil, ErrRecordNotFound
+	}
+	for _, address := range m.addresses[companyID] {
+		if address.ID == addressID {
+			return copyAddress(address), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// CreateCompanyAddress stores a new address of a company, and sets the id and the
+// timestamps of the provided address.
+func (m *MemoryModel) CreateCompanyAddress(ctx context.Context, address *Address, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(address.CompanyID) {
+		return ErrRecordNotFound
+	}
+	if address.Type == AddressRegistered && m.registeredAddressTaken(address.CompanyID, uuid.Nil) {
+		return ErrDuplicateAddress
+	}
+	now := time.Now().UTC()
+	created := copyAddress(address)
+	created.ID = uuid.New()
+	created.CreatedAt, created.UpdatedAt = now, now
+	if err := m.addCompanyUpdated(address.CompanyID, FieldChange{Field: "addresses", New: created}, actor); err != nil {
+		return err
+	}
+	address.ID = created.ID
+	address.CreatedAt, address.UpdatedAt = now, now
+	m.addresses[address.CompanyID] = append(m.addresses[address.CompanyID], created)
+	return nil
+}
+
+// UpdateCompanyAddress replaces an address of a company with the provided one, and
+// sets the update time of the provided address.
+func (m *MemoryModel) UpdateCompanyAddress(ctx context.Context, address *Address, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(address.CompanyID) {
+		return ErrRecordNotFound
+	}
+	for i, stored := range m.addresses[address.CompanyID] {
+		if stored.ID != address.ID {
+			continue
+		}
+		if address.Type == AddressRegistered && m.registeredAddressTaken(address.CompanyID, address.ID) {
+			return ErrDuplicateAddress
+		}
+		updated := copyAddress(address)
+		updated.CreatedAt, updated.UpdatedAt = stored.CreatedAt, time.Now().UTC()
+		if err := m.addCompanyUpdated(address.CompanyID, FieldChange{Field: "addresses", Old: stored, New: updated}, actor); err != nil {
+			return err
+		}
+		address.CreatedAt, address.UpdatedAt = updated.CreatedAt, updated.UpdatedAt
+		m.addresses[address.CompanyID][i] = updated
+		return nil
+	}
+	return ErrRecordNotFound
+}
+
+// DeleteCompanyAddress removes an address of a company.
+func (m *MemoryModel) DeleteCompanyAddress(ctx context.Context, companyID, addressID uuid.UUID, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(companyID) {
+		return ErrRecordNotFound
+	}
+	addresses := m.addresses[companyID]
+	for i, address := range addresses {
+		if address.ID != addressID {
+			continue
+		}
+		if err := m.addCompanyUpdated(companyID, FieldChange{Field: "addresses", Old: address}, actor); err != nil {
+			return err
+		}
+		m.addresses[companyID] = append(addresses[:i:i], addresses[i+1:]...)
+		return nil
+	}
+	return ErrRecordNotFound
+}
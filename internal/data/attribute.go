@@ -0,0 +1,207 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"mborgnolo/companyservice/internal/validator"
+)
+
+// CompanyAttributes holds the custom fields of a company, whose names and values are
+// defined by each deployment and validated against its JSON Schema. The values are
+// decoded with their numbers as json.Number, so that no precision is lost. It is
+// stored as a jsonb object, empty when the company has no attributes.
+type CompanyAttributes map[string]interface{}
+
+// UnmarshalJSON decodes a JSON object, keeping the numbers as json.Number. null
+// decodes as no attributes.
+func (a *CompanyAttributes) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		*a = nil
+	case map[string]interface{}:
+		*a = value
+	default:
+		return errors.New("attributes must be a JSON object")
+	}
+	return nil
+}
+
+// Value encodes the attributes as a JSON object.
+func (a CompanyAttributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(a)
+	return string(b), err
+}
+
+// Scan decodes the attributes of a jsonb column.
+func (a *CompanyAttributes) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return a.scan(v)
+	case string:
+		return a.scan([]byte(v))
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into attributes", src)
+	}
+}
+
+// scan decodes the attributes, leaving them nil when the object is empty.
+func (a *CompanyAttributes) scan(b []byte) error {
+	if err := a.UnmarshalJSON(b); err != nil {
+		return err
+	}
+	if len(*a) == 0 {
+		*a = nil
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the attributes.
+func (a CompanyAttributes) Clone() CompanyAttributes {
+	if a == nil {
+		return nil
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		// The attributes are made of JSON values, which always encode.
+		panic(err)
+	}
+	var clone CompanyAttributes
+	if err := clone.UnmarshalJSON(b); err != nil {
+		panic(err)
+	}
+	return clone
+}
+
+// ValidateAttributeLookup runs validation checks on the criteria of an attribute lookup.
+func ValidateAttributeLookup(v *validator.Validator, key string, hasValue bool) {
+	v.Check(key != "", "key", "is required")
+	v.Check(len(key) <= 100, "key", "must not be more than 100 characters")
+	v.Check(hasValue, "value", "is required")
+}
+
+// attributeCandidates returns the JSON values matched by the value of an attribute
+// lookup: the string itself, and the number or boolean it spells, if any, so that
+// ?value=42 finds both "42" and 42.
+func attributeCandidates(value string) []interface{} {
+	candidates := []interface{}{value}
+	dec := json.NewDecoder(bytes.NewReader([]byte(value)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		switch v.(type) {
+		case json.Number, bool:
+			candidates = append(candidates, v)
+		}
+	}
+	return candidates
+}
+
+// attributeEqual reports whether an attribute value is equal to a lookup candidate.
+// Numbers are compared by value, like the jsonb containment.
+func attributeEqual(value, candidate interface{}) bool {
+	switch candidate := candidate.(type) {
+	case json.Number:
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		a, errA := n.Float64()
+		b, errB := candidate.Float64()
+		return errA == nil && errB == nil && a == b
+	case string:
+		s, ok := value.(string)
+		return ok && s == candidate
+	case bool:
+		b, ok := value.(bool)
+		return ok && b == candidate
+	}
+	return false
+}
+
+// GetCompaniesByAttribute returns the page of companies, not deleted, whose attribute
+// key is equal to the value, sorted and paginated according to the filters, along
+// with the pagination metadata. The lookup is a containment query served by the GIN
+// index of the attributes.
+func (m *CompanyModel) GetCompaniesByAttribute(ctx context.Context, key, value string, filters Filters) ([]*Company, Metadata, error) {
+	// The value is matched as a string and, if it spells one, as a number or a boolean:
+	// each is a containment condition, and both are combined by a bitmap scan.
+	candidates := attributeCandidates(value)
+	documents := make([]interface{}, 2)
+	for i := range documents {
+		document, err := json.Marshal(map[string]interface{}{key: candidates[i%len(candidates)]})
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		documents[i] = string(document)
+	}
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", %s FROM company
+		WHERE deleted_at IS NULL
+		AND (attributes @> $1::jsonb OR attributes @> $2::jsonb)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, companyTagsColumn, filters.sortColumn(), filters.sortDirection())
+	rows, err := m.DB.QueryContext(ctx, stmt, documents[0], documents[1], filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	companies := []*Company{}
+	for rows.Next() {
+		company := &Company{}
+		err := rows.Scan(&totalRecords, &company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID,
+			&company.Attributes, pq.Array(&company.Tags))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		companies = append(companies, company)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return companies, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetCompaniesByAttribute returns the page of companies, not deleted, whose attribute
+// key is equal to the value.
+func (m *MemoryModel) GetCompaniesByAttribute(ctx context.Context, key, value string, filters Filters) ([]*Company, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	candidates := attributeCandidates(value)
+	matching := []*Company{}
+	for _, company := range m.companies {
+		attribute, ok := company.Attributes[key]
+		if company.DeletedAt != nil || !ok {
+			continue
+		}
+		for _, candidate := range candidates {
+			if attributeEqual(attribute, candidate) {
+				matching = append(matching, company)
+				break
+			}
+		}
+	}
+	sortCompanies(matching, filters)
+	start, end := pageBounds(len(matching), filters)
+	companies := []*Company{}
+	for _, company := range matching[start:end] {
+		companies = append(companies, copyCompany(company))
+	}
+	return companies, CalculateMetadata(len(matching), filters.Page, filters.PageSize), nil
+}
@@ -1,9 +1,17 @@
 package data
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"mborgnolo/companyservice/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // CompanyDescription is a custom type that wraps a string and implements the
@@ -18,7 +26,7 @@ func (cd *CompanyDescription) MarshalJSON() ([]byte, error) {
 	if !cd.Valid {
 		return []byte("null"), nil
 	}
-	return []byte(`"` + cd.String + `"`), nil
+	return json.Marshal(cd.String)
 }
 
 func (cd *CompanyDescription) UnmarshalJSON(data []byte) error {
@@ -27,9 +35,7 @@ func (cd *CompanyDescription) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 	cd.Valid = true
-	tmp := string(data)
-	cd.String = tmp[1 : len(tmp)-1]
-	return nil
+	return json.Unmarshal(data, &cd.String)
 }
 
 func (cd *CompanyDescription) Scan(src interface{}) error {
@@ -54,11 +60,19 @@ type Company struct {
 	Employees   int                `json:"employees"`
 	Registered  *bool              `json:"registered"`
 	Type        string             `json:"type"`
+	Attributes  CompanyAttributes  `json:"attributes,omitempty"`
+	Version     int                `json:"version"`
+	ParentID    *uuid.UUID         `json:"parent_id,omitempty"`
+	Tags        []string           `json:"tags,omitempty"`
+	DeletedAt   *time.Time         `json:"deleted_at,omitempty"`
 }
 
 // ValidateCompany runs validation checks on the company data.
 func ValidateCompany(v *validator.Validator, company *Company) {
 	v.Check(company.Name != "", "name", "is required")
+	// The name column is a varchar(15), which counts characters rather than bytes:
+	// a longer name would be rejected by the database with a server error.
+	v.Check(utf8.RuneCountInString(company.Name) <= 15, "name", "must not be more than 15 characters")
 	v.Check(len(company.Description.String) < 3000, "description", "must be less than 3000 characters")
 	v.Check(company.Employees > 0, "employees", "must be greater than zero")
 	v.Check(company.Registered != nil, "registered", "is required")
@@ -76,17 +90,85 @@ func validateCompanyType(t string) bool {
 	return false
 }
 
+// CompanyQuery holds the optional criteria used to narrow down a company
+// listing. Zero values mean that the criterion is not applied.
+type CompanyQuery struct {
+	Name         string
+	Type         string
+	Registered   *bool
+	MinEmployees int
+	MaxEmployees int
+	Tag          string
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, and its escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike returns s escaped to be matched literally by a LIKE pattern with the
+// ESCAPE '\' clause.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// CompanySortSafelist contains the sort values accepted when listing companies.
+var CompanySortSafelist = []string{
+	"id", "name", "description", "employees", "registered", "type",
+	"-id", "-name", "-description", "-employees", "-registered", "-type",
+}
+
+// ValidateCompanyQuery runs validation checks on the company listing criteria.
+func ValidateCompanyQuery(v *validator.Validator, query CompanyQuery) {
+	v.Check(query.Type == "" || validateCompanyType(query.Type), "type", "must be one of: Corporations, NonProfit, Cooperative , Sole Proprietorship")
+	v.Check(query.MinEmployees >= 0, "min_employees", "must not be negative")
+	v.Check(query.MaxEmployees >= 0, "max_employees", "must not be negative")
+	v.Check(query.MaxEmployees == 0 || query.MinEmployees <= query.MaxEmployees, "max_employees", "must be greater than or equal to min_employees")
+	v.Check(query.Tag == "" || tagRX.MatchString(query.Tag), "tag", tagMessage)
+}
+
+// sortKey returns the value of the company for the given sort column, formatted
+// as it is compared by the keyset queries.
+func (c *Company) sortKey(column string) string {
+	switch column {
+	case "name":
+		return c.Name
+	case "description":
+		return c.Description.String
+	case "employees":
+		return strconv.Itoa(c.Employees)
+	case "registered":
+		return strconv.FormatBool(c.Registered != nil && *c.Registered)
+	case "type":
+		return c.Type
+	default:
+		return c.ID.String()
+	}
+}
+
+// keysetExpression returns the SQL expression used to sort and compare rows by
+// the given column. NULL descriptions are compared as empty strings, so that
+// the row comparison of the keyset queries is always defined.
+func keysetExpression(column string) string {
+	if column == "description" {
+		return "COALESCE(description, '')"
+	}
+	return column
+}
+
+// CompanyTrashSortSafelist contains the sort values accepted when listing deleted companies.
+var CompanyTrashSortSafelist = []string{"-deleted_at", "deleted_at", "name", "-name"}
+
 // CompanyModel wraps the sql.DB connection pool.
 type CompanyModel struct {
 	DB *sql.DB
 }
 
 // GetCompany returns a single company based on the ID provided.
-func (m *CompanyModel) GetCompany(id uuid.UUID) (*Company, error) {
-	query := `SELECT "id", "name", "description", "employees", "registered", "type" FROM company WHERE id = $1`
-	row := m.DB.QueryRow(query, id)
+func (m *CompanyModel) GetCompany(ctx context.Context, id uuid.UUID) (*Company, error) {
+	query := `SELECT "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", ` + companyTagsColumn + `
+		FROM company WHERE id = $1 AND deleted_at IS NULL`
+	row := m.DB.QueryRowContext(ctx, query, id)
 	company := &Company{}
-	err := row.Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type)
+	err := row.Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID, &company.Attributes, pq.Array(&company.Tags))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrRecordNotFound
@@ -96,41 +178,434 @@ func (m *CompanyModel) GetCompany(id uuid.UUID) (*Company, error) {
 	return company, nil
 }
 
-// CreateCompany inserts a new company record in the database.
-func (m *CompanyModel) CreateCompany(company *Company) (uuid.UUID, error) {
+// CreateCompany inserts a new company record in the database, along with its first
+// revision and the CompanyCreated event in the outbox. actor is the user creating
+// the company. ErrDuplicateName is returned if the name is already used by a company
+// which is not deleted.
+func (m *CompanyModel) CreateCompany(ctx context.Context, company *Company, actor string) (uuid.UUID, error) {
 	newUUID := uuid.New()
-	query := `INSERT INTO company ("id", "name", "description", "employees", "registered", "type") VALUES ($1, $2, $3, $4, $5, $6)`
-	_, err := m.DB.Exec(query, newUUID, company.Name, company.Description.String, company.Employees, company.Registered, company.Type)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	defer tx.Rollback()
+	query := `INSERT INTO company ("id", "name", "description", "employees", "registered", "type", "attributes") VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, query, newUUID, company.Name, company.Description.String, company.Employees, company.Registered, company.Type, company.Attributes)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return uuid.Nil, ErrDuplicateName
+		}
+		return uuid.Nil, err
+	}
+	after, err := getCompanyForUpdate(ctx, tx, newUUID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if err = recordChange(ctx, tx, OperationCreated, nil, after, actor); err != nil {
+		return uuid.Nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
 	return newUUID, nil
 }
 
-// DeleteCompany deletes a company record from the database.
-func (m *CompanyModel) DeleteCompany(id uuid.UUID) error {
-	query := `DELETE FROM company WHERE id = $1`
-	_, err := m.DB.Exec(query, id)
+// DeleteCompany moves a company to the trash by setting its deletion time, along
+// with a new revision and the CompanyDeleted event in the outbox. The company is
+// only deleted if its version matches the provided one, otherwise ErrEditConflict
+// is returned. A company with subsidiaries which are not deleted cannot be deleted:
+// ErrHasSubsidiaries is returned until they are deleted or moved to another parent.
+// Deleted companies are excluded from every read until they are restored, and
+// permanently removed by PurgeDeletedCompanies.
+func (m *CompanyModel) DeleteCompany(ctx context.Context, id uuid.UUID, version int, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	before, err := lockCompanyVersion(ctx, tx, id, version)
+	if err != nil {
+		return err
+	}
+	subsidiaries, err := hasSubsidiaries(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if subsidiaries {
+		return ErrHasSubsidiaries
+	}
+	query := `UPDATE company SET deleted_at = NOW(), version = version + 1 WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	after, err := getCompanyForUpdate(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err = recordChange(ctx, tx, OperationDeleted, before, after, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateCompany updates a company record in the database, along with a new revision
+// and the CompanyUpdated event in the outbox. The update is conditional on the
+// version of the company: if the record has been changed since it was read,
+// ErrEditConflict is returned. ErrDuplicateName is returned if the new name is used
+// by another company which is not deleted. On success the version of the company is
+// set to the new version.
+func (m *CompanyModel) UpdateCompany(ctx context.Context, company *Company, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+	before, err := lockCompanyVersion(ctx, tx, company.ID, company.Version)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE company SET name = COALESCE($1,name), description = COALESCE($2,description), employees = COALESCE($3,employees), registered = COALESCE($4,registered), type = COALESCE($5,type), attributes = $6, version = version + 1
+		WHERE id = $7`
+	_, err = tx.ExecContext(ctx, query, company.Name, company.Description.String, company.Employees, company.Registered, company.Type, company.Attributes, company.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return err
+	}
+	after, err := getCompanyForUpdate(ctx, tx, company.ID)
+	if err != nil {
+		return err
+	}
+	if err = recordChange(ctx, tx, OperationUpdated, before, after, actor); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	company.Version = after.Version
 	return nil
 }
 
-// UpdateCompany updates a company record in the database.
-func (m *CompanyModel) UpdateCompany(company *Company) error {
-	query := `UPDATE company SET name = COALESCE($1,name), description = COALESCE($2,description), employees = COALESCE($3,employees), registered = COALESCE($4,registered), type = COALESCE($5,type) WHERE id = $6`
-	result, err := m.DB.Exec(query, company.Name, company.Description.String, company.Employees, company.Registered, company.Type, company.ID)
+// lockCompanyVersion locks the row of a company which is not deleted until the end
+// of the transaction, and returns its current state. ErrEditConflict is returned if
+// the version of the company does not match the provided one.
+func lockCompanyVersion(ctx context.Context, tx *sql.Tx, id uuid.UUID, version int) (*Company, error) {
+	company, err := getCompanyForUpdate(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if company.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+	if company.Version != version {
+		return nil, ErrEditConflict
+	}
+	return company, nil
+}
+
+// GetAllCompanies returns the page of companies matching the query, sorted and
+// paginated according to the filters, along with the pagination metadata.
+func (m *CompanyModel) GetAllCompanies(ctx context.Context, query CompanyQuery, filters Filters) ([]*Company, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", %s FROM company
+		WHERE deleted_at IS NULL
+		AND (name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+		AND (type = $2 OR $2 = '')
+		AND (registered = $3 OR $3 IS NULL)
+		AND (employees >= $4 OR $4 = 0)
+		AND (employees <= $5 OR $5 = 0)
+		AND %s
+		ORDER BY %s %s, id ASC
+		LIMIT $7 OFFSET $8`, companyTagsColumn, companyTagFilter("$6"), filters.sortColumn(), filters.sortDirection())
+	rows, err := m.DB.QueryContext(ctx, stmt, escapeLike(query.Name), query.Type, query.Registered, query.MinEmployees, query.MaxEmployees, query.Tag,
+		filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	companies := []*Company{}
+	for rows.Next() {
+		company := &Company{}
+		err := rows.Scan(&totalRecords, &company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID, &company.Attributes, pq.Array(&company.Tags))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		companies = append(companies, company)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return companies, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetCompaniesByCursor returns a page of companies matching the query using
+// keyset pagination: instead of an OFFSET, rows are selected relative to the
+// sort value and id stored in the cursor, which keeps the paging stable while
+// rows are inserted or deleted. The cursors of the surrounding pages are
+// returned along with the companies.
+func (m *CompanyModel) GetCompaniesByCursor(ctx context.Context, query CompanyQuery, filters KeysetFilters) ([]*Company, CursorPage, error) {
+	column := filters.sortColumn()
+	expression := keysetExpression(column)
+	direction := filters.sortDirection()
+	backward := filters.Cursor != nil && filters.Cursor.Before
+	// When walking backward the rows are read in the opposite order and
+	// reversed afterwards.
+	if backward {
+		if direction == "ASC" {
+			direction = "DESC"
+		} else {
+			direction = "ASC"
+		}
+	}
+	comparison := ">"
+	if direction == "DESC" {
+		comparison = "<"
+	}
+	args := []interface{}{escapeLike(query.Name), query.Type, query.Registered, query.MinEmployees, query.MaxEmployees, query.Tag, filters.PageSize + 1}
+	keyset := ""
+	if filters.Cursor != nil {
+		keyset = fmt.Sprintf("AND (%s, id) %s ($8, $9)", expression, comparison)
+		args = append(args, filters.Cursor.Value, filters.Cursor.ID)
+	}
+	stmt := fmt.Sprintf(`SELECT "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", %s FROM company
+		WHERE deleted_at IS NULL
+		AND (name ILIKE '%%' || $1 || '%%' ESCAPE '\' OR $1 = '')
+		AND (type = $2 OR $2 = '')
+		AND (registered = $3 OR $3 IS NULL)
+		AND (employees >= $4 OR $4 = 0)
+		AND (employees <= $5 OR $5 = 0)
+		AND %s
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT $7`, companyTagsColumn, companyTagFilter("$6"), keyset, expression, direction, direction)
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, CursorPage{}, err
+	}
+	defer rows.Close()
+	companies := []*Company{}
+	for rows.Next() {
+		company := &Company{}
+		err := rows.Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID, &company.Attributes, pq.Array(&company.Tags))
+		if err != nil {
+			return nil, CursorPage{}, err
+		}
+		companies = append(companies, company)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, CursorPage{}, err
+	}
+	companies, page := keysetPage(companies, filters)
+	return companies, page, nil
+}
+
+// keysetPage turns the rows read by a keyset query, up to one more than the page
+// size in the direction of the walk, into the page of companies in sort order
+// along with the cursors of the surrounding pages.
+func keysetPage(companies []*Company, filters KeysetFilters) ([]*Company, CursorPage) {
+	column := filters.sortColumn()
+	backward := filters.Cursor != nil && filters.Cursor.Before
+	hasMore := len(companies) > filters.PageSize
+	if hasMore {
+		companies = companies[:filters.PageSize]
+	}
+	if backward {
+		for i, j := 0, len(companies)-1; i < j; i, j = i+1, j-1 {
+			companies[i], companies[j] = companies[j], companies[i]
+		}
+	}
+	var page CursorPage
+	if len(companies) == 0 {
+		return companies, page
+	}
+	first, last := companies[0], companies[len(companies)-1]
+	if hasMore || backward {
+		page.Next = &Cursor{Sort: filters.Sort, Value: last.sortKey(column), ID: last.ID}
+	}
+	if (backward && hasMore) || (!backward && filters.Cursor != nil) {
+		page.Prev = &Cursor{Sort: filters.Sort, Value: first.sortKey(column), ID: first.ID, Before: true}
+	}
+	return companies, page
+}
+
+// SearchCompanies returns the page of companies whose name or description match
+// the full-text search query, ranked by relevance (matches on the name weigh
+// more than matches on the description) unless sorted otherwise.
+func (m *CompanyModel) SearchCompanies(ctx context.Context, q string, filters Filters) ([]*CompanySearchResult, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", %s,
+		ts_rank(search, query) AS rank,
+		ts_headline('english', name, query, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'),
+		ts_headline('english', coalesce(description, ''), query, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MinWords=5, MaxWords=20')
+		FROM company, to_tsquery('english', $1) query
+		WHERE search @@ query AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, companyTagsColumn, filters.sortColumn(), filters.sortDirection())
+	rows, err := m.DB.QueryContext(ctx, stmt, toTSQuery(q), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	results := []*CompanySearchResult{}
+	for rows.Next() {
+		result := &CompanySearchResult{Company: &Company{}}
+		company := result.Company
+		err := rows.Scan(&totalRecords, &company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID, &company.Attributes,
+			pq.Array(&company.Tags), &result.Rank, &result.NameSnippet, &result.DescriptionSnippet)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return results, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetDeletedCompanies returns the page of companies in the trash, sorted and
+// paginated according to the filters, along with the pagination metadata.
+func (m *CompanyModel) GetDeletedCompanies(ctx context.Context, filters Filters) ([]*Company, Metadata, error) {
+	stmt := fmt.Sprintf(`SELECT count(*) OVER(), "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", "deleted_at", %s FROM company
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, companyTagsColumn, filters.sortColumn(), filters.sortDirection())
+	rows, err := m.DB.QueryContext(ctx, stmt, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	companies := []*Company{}
+	for rows.Next() {
+		company := &Company{}
+		err := rows.Scan(&totalRecords, &company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID, &company.Attributes, &company.DeletedAt,
+			pq.Array(&company.Tags))
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		companies = append(companies, company)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return companies, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// RestoreCompany moves a company out of the trash, along with a new revision and the
+// CompanyRestored event in the outbox. The company keeps its parent, unless the parent
+// has been deleted too. ErrRecordNotFound is returned if the company is not in the trash,
+// and ErrDuplicateName if its name has been taken by another company in the meantime.
+func (m *CompanyModel) RestoreCompany(ctx context.Context, id uuid.UUID, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	i, err := result.RowsAffected()
+	defer tx.Rollback()
+	before, err := getCompanyForUpdate(ctx, tx, id)
 	if err != nil {
 		return err
 	}
-	if i == 0 {
+	if before.DeletedAt == nil {
 		return ErrRecordNotFound
 	}
+	parentID, err := restoredParent(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE company SET deleted_at = NULL, version = version + 1, parent_id = $2 WHERE id = $1`
+	_, err = tx.ExecContext(ctx, query, id, parentID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDuplicateName
+		}
+		return err
+	}
+	after, err := getCompanyForUpdate(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err = recordChange(ctx, tx, OperationRestored, before, after, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeDeletedCompanies permanently removes the companies deleted before the given
+// time along with their addresses and tags, and returns the number of companies
+// removed. The companies in the trash which had them as parent lose their parent,
+// which is recorded like any other change of the parent.
+func (m *CompanyModel) PurgeDeletedCompanies(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"company_addresses", "company_tags"} {
+		query := `DELETE FROM ` + table + ` WHERE company_id IN (SELECT id FROM company WHERE deleted_at IS NOT NULL AND deleted_at < $1)`
+		if _, err = tx.ExecContext(ctx, query, deletedBefore); err != nil {
+			return 0, err
+		}
+	}
+	if err = clearPurgedParents(ctx, tx, deletedBefore); err != nil {
+		return 0, err
+	}
+	query := `DELETE FROM company WHERE deleted_at IS NOT NULL AND deleted_at < $1`
+	result, err := tx.ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
 
+// clearPurgedParents makes the companies kept by a purge whose parent is about to be
+// purged top-level companies, incrementing their version along with the
+// CompanyUpdated event.
+func clearPurgedParents(ctx context.Context, tx *sql.Tx, deletedBefore time.Time) error {
+	query := `SELECT id FROM company WHERE parent_id IN (SELECT id FROM company WHERE deleted_at IS NOT NULL AND deleted_at < $1)
+		AND NOT (deleted_at IS NOT NULL AND deleted_at < $1)
+		ORDER BY id`
+	rows, err := tx.QueryContext(ctx, query, deletedBefore)
+	if err != nil {
+		return err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		company, err := getCompanyForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		old, err := getCompanyParent(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, `UPDATE company SET parent_id = NULL WHERE id = $1`, id); err != nil {
+			return err
+		}
+		if err = recordNewVersion(ctx, tx, company, FieldChange{Field: "parent_id", Old: old, New: (*uuid.UUID)(nil)}, ""); err != nil {
+			return err
+		}
+	}
 	return nil
 }
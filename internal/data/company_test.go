@@ -4,10 +4,13 @@
 package data
 
 import (
+	"context"
 	"github.com/google/uuid"
 	_ "github.com/lib/pq"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCompanyModelGet(t *testing.T) {
@@ -30,6 +33,7 @@ func TestCompanyModelGet(t *testing.T) {
 				Employees:   100,
 				Registered:  boolPtr(true),
 				Type:        "Corporations",
+				Version:     1,
 			},
 			wantError: nil,
 		},
@@ -48,7 +52,7 @@ func TestCompanyModelGet(t *testing.T) {
 
 			c := CompanyModel{db}
 
-			company, err := c.GetCompany(tt.companyID)
+			company, err := c.GetCompany(context.Background(), tt.companyID)
 			if err != tt.wantError {
 				t.Errorf("want %v; got %s", tt.wantError, err)
 			}
@@ -81,6 +85,7 @@ func TestCompanyModelUpdate(t *testing.T) {
 				Employees:   2,
 				Registered:  boolPtr(true),
 				Type:        "Corporations",
+				Version:     1,
 			},
 			wantCompany: &Company{
 				ID:          uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6"),
@@ -89,6 +94,7 @@ func TestCompanyModelUpdate(t *testing.T) {
 				Employees:   2,
 				Registered:  boolPtr(true),
 				Type:        "Corporations",
+				Version:     2,
 			},
 			wantError: nil,
 		},
@@ -111,6 +117,7 @@ func TestCompanyModelUpdate(t *testing.T) {
 				Description: CompanyDescription{String: "Description for company one", Valid: true},
 				Employees:   2,
 				Type:        "Corporations",
+				Version:     1,
 			},
 			wantCompany: &Company{
 				ID:          uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6"),
@@ -119,6 +126,7 @@ func TestCompanyModelUpdate(t *testing.T) {
 				Employees:   2,
 				Registered:  boolPtr(true),
 				Type:        "Corporations",
+				Version:     2,
 			},
 			wantError: nil,
 		},
@@ -128,11 +136,11 @@ func TestCompanyModelUpdate(t *testing.T) {
 			db, teardown := newTestDB(t)
 			defer teardown()
 			c := CompanyModel{db}
-			err := c.UpdateCompany(tt.updateCompany)
+			err := c.UpdateCompany(context.Background(), tt.updateCompany, "")
 			if err != tt.wantError {
 				t.Errorf("want %v; got %s", tt.wantError, err)
 			}
-			companyFromDb, err := c.GetCompany(tt.updateCompany.ID)
+			companyFromDb, err := c.GetCompany(context.Background(), tt.updateCompany.ID)
 			if err != tt.wantError {
 				t.Errorf("want %v; got %s", tt.wantError, err)
 			}
@@ -172,18 +180,357 @@ func TestCompanyModelCreate(t *testing.T) {
 			defer teardown()
 
 			c := CompanyModel{db}
-			UUID, err := c.CreateCompany(tt.company)
+			UUID, err := c.CreateCompany(context.Background(), tt.company, "")
 
 			if err != tt.wantError {
 				t.Errorf("want %v; got %s", tt.wantError, err)
 			}
-			companyFromDb, err := c.GetCompany(UUID)
+			companyFromDb, err := c.GetCompany(context.Background(), UUID)
 			// Set the UUID of the company to the one returned from the database, since it's generated
 			// by the database
 			tt.company.ID = UUID
+			tt.company.Version = 1
 			if !reflect.DeepEqual(companyFromDb, tt.company) {
 				t.Errorf("want %v; got %v", tt.company, companyFromDb)
 			}
 		})
 	}
 }
+
+func TestCompanyModelGetAll(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	tests := []struct {
+		name      string
+		query     CompanyQuery
+		filters   Filters
+		wantCount int
+		wantTotal int
+	}{
+		{
+			name:      "No criteria",
+			filters:   Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist},
+			wantCount: 1,
+			wantTotal: 1,
+		},
+		{
+			name:      "Partial name match",
+			query:     CompanyQuery{Name: "one", Type: "Corporations", Registered: boolPtr(true), MinEmployees: 10, MaxEmployees: 100},
+			filters:   Filters{Page: 1, PageSize: 20, Sort: "-employees", SortSafelist: CompanySortSafelist},
+			wantCount: 1,
+			wantTotal: 1,
+		},
+		{
+			name:      "No match",
+			query:     CompanyQuery{MinEmployees: 1000},
+			filters:   Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist},
+			wantCount: 0,
+			wantTotal: 0,
+		},
+		{
+			name:      "Page out of range",
+			filters:   Filters{Page: 2, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist},
+			wantCount: 0,
+			wantTotal: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+			c := CompanyModel{db}
+			companies, metadata, err := c.GetAllCompanies(context.Background(), tt.query, tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(companies) != tt.wantCount {
+				t.Errorf("want %d companies; got %d", tt.wantCount, len(companies))
+			}
+			if metadata.TotalRecords != tt.wantTotal {
+				t.Errorf("want %d total records; got %d", tt.wantTotal, metadata.TotalRecords)
+			}
+		})
+	}
+}
+
+func TestCompanyModelGetByCursor(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	c := CompanyModel{db}
+	for _, name := range []string{"Company Two", "Company Three"} {
+		_, err := c.CreateCompany(context.Background(), &Company{Name: name, Employees: 10, Registered: boolPtr(false), Type: "NonProfit"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	filters := KeysetFilters{Sort: "name", SortSafelist: CompanySortSafelist, PageSize: 2}
+
+	first, page, err := c.GetCompaniesByCursor(context.Background(), CompanyQuery{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].Name != "Company One" || first[1].Name != "Company Three" {
+		t.Fatalf("unexpected first page %v", first)
+	}
+	if page.Next == nil || page.Prev != nil {
+		t.Fatalf("want only a next cursor; got %+v", page)
+	}
+
+	filters.Cursor = page.Next
+	second, page, err := c.GetCompaniesByCursor(context.Background(), CompanyQuery{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[0].Name != "Company Two" {
+		t.Fatalf("unexpected second page %v", second)
+	}
+	if page.Next != nil || page.Prev == nil {
+		t.Fatalf("want only a prev cursor; got %+v", page)
+	}
+
+	filters.Cursor = page.Prev
+	back, page, err := c.GetCompaniesByCursor(context.Background(), CompanyQuery{}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, first) {
+		t.Errorf("want %v; got %v", first, back)
+	}
+	if page.Next == nil || page.Prev != nil {
+		t.Errorf("want only a next cursor; got %+v", page)
+	}
+}
+
+func TestCompanyModelSearch(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	tests := []struct {
+		name      string
+		q         string
+		wantCount int
+	}{
+		{"Match on description", "description", 1},
+		{"Match on name", "one", 1},
+		{"Phrase", `"company one"`, 1},
+		{"Prefix", "descr*", 1},
+		{"No match", "bakery", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+			c := CompanyModel{db}
+			filters := Filters{Page: 1, PageSize: 20, Sort: "-rank", SortSafelist: CompanySearchSortSafelist}
+			results, metadata, err := c.SearchCompanies(context.Background(), tt.q, filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != tt.wantCount || metadata.TotalRecords != tt.wantCount {
+				t.Errorf("want %d results; got %d (total %d)", tt.wantCount, len(results), metadata.TotalRecords)
+			}
+			for _, result := range results {
+				if !strings.Contains(result.NameSnippet+result.DescriptionSnippet, "<mark>") {
+					t.Errorf("want highlighted snippets; got %q and %q", result.NameSnippet, result.DescriptionSnippet)
+				}
+			}
+		})
+	}
+}
+
+func TestCompanyModelSoftDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	c := CompanyModel{db}
+	id := uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6")
+
+	if err := c.DeleteCompany(context.Background(), id, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetCompany(context.Background(), id); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+	if err := c.DeleteCompany(context.Background(), id, 2, ""); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+	filters := Filters{Page: 1, PageSize: 20, Sort: "-deleted_at", SortSafelist: CompanyTrashSortSafelist}
+	trash, _, err := c.GetDeletedCompanies(context.Background(), filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != id || trash[0].DeletedAt == nil {
+		t.Fatalf("want the deleted company in the trash; got %v", trash)
+	}
+
+	if err := c.RestoreCompany(context.Background(), id, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetCompany(context.Background(), id); err != nil {
+		t.Errorf("want restored company; got %v", err)
+	}
+	if err := c.RestoreCompany(context.Background(), id, ""); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+
+	if err := c.DeleteCompany(context.Background(), id, 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.PurgeDeletedCompanies(context.Background(), time.Now().Add(-time.Hour))
+	if err != nil || n != 0 {
+		t.Errorf("want nothing purged; got %d, %v", n, err)
+	}
+	n, err = c.PurgeDeletedCompanies(context.Background(), time.Now().Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Errorf("want 1 company purged; got %d, %v", n, err)
+	}
+}
+
+func TestCompanyModelEditConflict(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	c := CompanyModel{db}
+	id := uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6")
+
+	first, err := c.GetCompany(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := c.GetCompany(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Employees = 200
+	if err = c.UpdateCompany(context.Background(), first, ""); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 2 {
+		t.Errorf("want version 2; got %d", first.Version)
+	}
+	second.Employees = 300
+	if err = c.UpdateCompany(context.Background(), second, ""); err != ErrEditConflict {
+		t.Errorf("want %v; got %v", ErrEditConflict, err)
+	}
+	if err = c.DeleteCompany(context.Background(), id, 1, ""); err != ErrEditConflict {
+		t.Errorf("want %v; got %v", ErrEditConflict, err)
+	}
+	company, err := c.GetCompany(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if company.Employees != 200 {
+		t.Errorf("want 200 employees; got %d", company.Employees)
+	}
+}
+
+func TestCompanyModelRevisions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	c := CompanyModel{db}
+	id := uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6")
+
+	company, err := c.GetCompany(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	company.Employees = 2
+	if err = c.UpdateCompany(context.Background(), company, "john@companyservice.io"); err != nil {
+		t.Fatal(err)
+	}
+	filters := Filters{Page: 1, PageSize: 20, Sort: "-revision", SortSafelist: []string{"-revision"}}
+	revisions, metadata, err := c.GetCompanyRevisions(context.Background(), id, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || metadata.TotalRecords != 2 {
+		t.Fatalf("want 2 revisions; got %d", len(revisions))
+	}
+	latest := revisions[0]
+	wantChanges := []FieldChange{{Field: "employees", Old: float64(100), New: float64(2)}}
+	if latest.Revision != 2 || latest.Operation != OperationUpdated || latest.Actor != "john@companyservice.io" || !reflect.DeepEqual(latest.Changes, wantChanges) {
+		t.Errorf("unexpected latest revision %+v", latest)
+	}
+
+	rolledBack, err := c.RollbackCompany(context.Background(), id, 1, 2, "john@companyservice.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rolledBack.Employees != 100 || rolledBack.Version != 3 {
+		t.Errorf("want 100 employees at version 3; got %d at version %d", rolledBack.Employees, rolledBack.Version)
+	}
+	revision, err := c.GetCompanyRevision(context.Background(), id, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.Operation != OperationRolledBack || revision.Snapshot.Employees != 100 {
+		t.Errorf("unexpected rollback revision %+v", revision)
+	}
+	if _, err = c.RollbackCompany(context.Background(), id, 10, 3, ""); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+	if _, _, err = c.GetCompanyRevisions(context.Background(), uuid.MustParse("e2d3253c-3e65-4516-9318-d013fde56dca"), filters); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+}
+
+func TestCompanyModelGetAsOf(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	c := CompanyModel{db}
+	id := uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6")
+
+	company, err := c.GetCompany(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beforeUpdate := time.Now()
+	company.Employees = 2
+	if err = c.UpdateCompany(context.Background(), company, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteCompany(context.Background(), id, company.Version, ""); err != nil {
+		t.Fatal(err)
+	}
+	var inverted int
+	err = db.QueryRow(`SELECT count(*) FROM company_history WHERE company_id = $1 AND valid_to < valid_from`, id).Scan(&inverted)
+	if err != nil || inverted != 0 {
+		t.Errorf("want no period ending before it starts; got %d, %v", inverted, err)
+	}
+
+	tests := []struct {
+		name          string
+		asOf          time.Time
+		wantEmployees int
+		wantError     error
+	}{
+		{"Before creation", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), 0, ErrRecordNotFound},
+		{"Before update", beforeUpdate, 100, nil},
+		{"After deletion", time.Now().Add(time.Hour), 0, ErrRecordNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			company, err := c.GetCompanyAsOf(context.Background(), id, tt.asOf)
+			if err != tt.wantError {
+				t.Fatalf("want %v; got %v", tt.wantError, err)
+			}
+			if err == nil && company.Employees != tt.wantEmployees {
+				t.Errorf("want %d employees; got %d", tt.wantEmployees, company.Employees)
+			}
+		})
+	}
+}
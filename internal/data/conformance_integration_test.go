@@ -0,0 +1,22 @@
+//go:build integration
+// +build integration
+
+package data_test
+
+import (
+	_ "github.com/lib/pq"
+	"mborgnolo/companyservice/internal/conformance"
+	"mborgnolo/companyservice/internal/data"
+	"testing"
+)
+
+func TestCompanyModelConformance(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	conformance.RunCompanyRepositoryTests(t, func(t *testing.T) conformance.CompanyRepository {
+		db, teardown := data.NewTestDB(t)
+		t.Cleanup(teardown)
+		return data.NewCompanyModel(db)
+	})
+}
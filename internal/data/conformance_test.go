@@ -0,0 +1,13 @@
+package data_test
+
+import (
+	"mborgnolo/companyservice/internal/conformance"
+	"mborgnolo/companyservice/internal/data"
+	"testing"
+)
+
+func TestMemoryModelConformance(t *testing.T) {
+	conformance.RunCompanyRepositoryTests(t, func(t *testing.T) conformance.CompanyRepository {
+		return data.NewMemoryModel()
+	})
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/validator"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Cursor identifies a position in a keyset paginated listing. It holds the sort
+// value and the id of the row the next page starts after (or, when Before is
+// set, the row the previous page ends before).
+type Cursor struct {
+	Sort   string    `json:"s"`
+	Value  string    `json:"v"`
+	ID     uuid.UUID `json:"id"`
+	Before bool      `json:"b,omitempty"`
+}
+
+// EncodeCursor returns the opaque representation of the cursor, signed with the
+// provided secret so that clients cannot forge or tamper with it.
+func EncodeCursor(c Cursor, secret []byte) string {
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor verifies the signature of an opaque cursor and returns the
+// decoded Cursor. ErrInvalidCursor is returned if the cursor is malformed or
+// has not been signed with the provided secret.
+func DecodeCursor(s string, secret []byte) (Cursor, error) {
+	var c Cursor
+	encodedPayload, encodedSignature, ok := strings.Cut(s, ".")
+	if !ok {
+		return c, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// KeysetFilters holds the sorting and paging options of a keyset paginated
+// listing. A nil Cursor requests the first page.
+type KeysetFilters struct {
+	Sort         string
+	SortSafelist []string
+	PageSize     int
+	Cursor       *Cursor
+}
+
+// ValidateKeysetFilters runs validation checks on the keyset paging options.
+func ValidateKeysetFilters(v *validator.Validator, f KeysetFilters) {
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(v.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort, "cursor", "does not match the sort value")
+	}
+}
+
+// sortColumn returns the column name to sort by. It panics if the sort value
+// is not in the safelist, which protects the query from SQL injection.
+func (f KeysetFilters) sortColumn() string {
+	return Filters{Sort: f.Sort, SortSafelist: f.SortSafelist}.sortColumn()
+}
+
+// sortDirection returns the SQL sort direction matching the sort value.
+func (f KeysetFilters) sortDirection() string {
+	return Filters{Sort: f.Sort}.sortDirection()
+}
+
+// CursorPage holds the cursors pointing to the pages around a keyset page.
+// A nil cursor means that there is no such page.
+type CursorPage struct {
+	Next *Cursor
+	Prev *Cursor
+}
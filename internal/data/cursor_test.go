@@ -0,0 +1,58 @@
+package data
+
+import (
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	secret := []byte("secret")
+	tests := []struct {
+		name      string
+		encoded   func() string
+		want      Cursor
+		wantError error
+	}{
+		{
+			name: "Valid cursor",
+			encoded: func() string {
+				return EncodeCursor(Cursor{Sort: "-employees", Value: "100", ID: uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6"), Before: true}, secret)
+			},
+			want:      Cursor{Sort: "-employees", Value: "100", ID: uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6"), Before: true},
+			wantError: nil,
+		},
+		{
+			name: "Signed with another secret",
+			encoded: func() string {
+				return EncodeCursor(Cursor{Sort: "name", Value: "Company One"}, []byte("another secret"))
+			},
+			wantError: ErrInvalidCursor,
+		},
+		{
+			name: "Tampered payload",
+			encoded: func() string {
+				_, signature, _ := strings.Cut(EncodeCursor(Cursor{Sort: "name", Value: "Company One"}, secret), ".")
+				payload, _, _ := strings.Cut(EncodeCursor(Cursor{Sort: "name", Value: "Company Two"}, []byte("another secret")), ".")
+				return payload + "." + signature
+			},
+			wantError: ErrInvalidCursor,
+		},
+		{
+			name:      "Malformed cursor",
+			encoded:   func() string { return "not-a-cursor" },
+			wantError: ErrInvalidCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := DecodeCursor(tt.encoded(), secret)
+			if err != tt.wantError {
+				t.Fatalf("want %v; got %v", tt.wantError, err)
+			}
+			if err == nil && cursor != tt.want {
+				t.Errorf("want %v; got %v", tt.want, cursor)
+			}
+		})
+	}
+}
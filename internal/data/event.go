@@ -5,11 +5,27 @@ import (
 	"time"
 )
 
-// EventRecord is a record of an event that occurred in the system.
+// EventSchemaVersion is the version of the EventRecord payload. Version 1 only held
+// the ID, Type and TimeStamp fields; version 2 added the states of the company, its
+// changes and the acting user.
+const EventSchemaVersion = 2
+
+// EventRecord is a record of an event that occurred in the system. Before and After
+// are the states of the company, as recorded in its revisions, around the change:
+// Before is null for a created company, and After is the deleted company for a
+// deletion. Changes lists the changed fields with their old and new values. For a
+// change of the addresses, the tags or the parent of a company, which leaves its
+// revisions unchanged, Before and After are the same and Changes holds the changed
+// "addresses", "tags" or "parent_id" field.
 type EventRecord struct {
-	ID        uuid.UUID `json:"ID"`
-	Type      EventType `json:"Type"`
-	TimeStamp time.Time `json:"TimeStamp"`
+	SchemaVersion int           `json:"SchemaVersion"`
+	ID            uuid.UUID     `json:"ID"`
+	Type          EventType     `json:"Type"`
+	TimeStamp     time.Time     `json:"TimeStamp"`
+	Actor         string        `json:"Actor"`
+	Before        *Company      `json:"Before"`
+	After         *Company      `json:"After"`
+	Changes       []FieldChange `json:"Changes"`
 }
 type EventType int
 
@@ -17,8 +33,33 @@ const (
 	CompanyCreated = iota
 	CompanyUpdated
 	CompanyDeleted
+	CompanyRestored
 )
 
 func (e EventType) String() string {
-	return [...]string{"CompanyCreated", "CompanyUpdated", "CompanyDeleted"}[e]
+	return [...]string{"CompanyCreated", "CompanyUpdated", "CompanyDeleted", "CompanyRestored"}[e]
+}
+
+// EventTypeNames are the names of the event types, which the consumers of the events
+// filter them by.
+var EventTypeNames = []string{
+	EventType(CompanyCreated).String(),
+	EventType(CompanyUpdated).String(),
+	EventType(CompanyDeleted).String(),
+	EventType(CompanyRestored).String(),
+}
+
+// newEventRecord returns the event of a change of a company made by actor. before is
+// nil for a newly created company.
+func newEventRecord(eventType EventType, before, after *Company, changes []FieldChange, actor string) EventRecord {
+	return EventRecord{
+		SchemaVersion: EventSchemaVersion,
+		ID:            after.ID,
+		Type:          eventType,
+		TimeStamp:     time.Now().UTC(),
+		Actor:         actor,
+		Before:        before,
+		After:         after,
+		Changes:       changes,
+	}
 }
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// exportBatchSize is the number of companies fetched at once from the cursor of an
+// export.
+const exportBatchSize = 500
+
+// ExportCompanies calls fn with every company matching the query, sorted by name.
+// The companies are fetched in batches from a cursor, within a read-only transaction,
+// so that the export is consistent without holding the whole result set in memory.
+// The export stops at the first error returned by fn, which is returned.
+func (m *CompanyModel) ExportCompanies(ctx context.Context, query CompanyQuery, fn func(*Company) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt := `DECLARE company_export NO SCROLL CURSOR FOR
+		SELECT "id", "name", "description", "employees", "registered", "type", "version", "parent_id", "attributes", ` + companyTagsColumn + ` FROM company
+		WHERE deleted_at IS NULL
+		AND (name ILIKE '%' || $1 || '%' ESCAPE '\' OR $1 = '')
+		AND (type = $2 OR $2 = '')
+		AND (registered = $3 OR $3 IS NULL)
+		AND (employees >= $4 OR $4 = 0)
+		AND (employees <= $5 OR $5 = 0)
+		AND ` + companyTagFilter("$6") + `
+		ORDER BY name ASC, id ASC`
+	_, err = tx.ExecContext(ctx, stmt, escapeLike(query.Name), query.Type, query.Registered, query.MinEmployees, query.MaxEmployees, query.Tag)
+	if err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf(`FETCH FORWARD %d FROM company_export`, exportBatchSize)
+	for {
+		fetched, err := exportBatch(ctx, tx, fetch, fn)
+		if err != nil {
+			return err
+		}
+		if fetched < exportBatchSize {
+			return tx.Commit()
+		}
+	}
+}
+
+// exportBatch fetches the next batch of companies of an export, calls fn with each of
+// them and returns the number of companies fetched.
+func exportBatch(ctx context.Context, tx *sql.Tx, fetch string, fn func(*Company) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	fetched := 0
+	for rows.Next() {
+		company := &Company{}
+		err := rows.Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Version, &company.ParentID, &company.Attributes, pq.Array(&company.Tags))
+		if err != nil {
+			return 0, err
+		}
+		fetched++
+		if err = fn(company); err != nil {
+			return 0, err
+		}
+	}
+	return fetched, rows.Err()
+}
+
+// ExportCompanies calls fn with every company matching the query, sorted by name.
+// The matching companies are copied before fn is called, so that the model is not
+// locked while they are written.
+func (m *MemoryModel) ExportCompanies(ctx context.Context, query CompanyQuery, fn func(*Company) error) error {
+	m.mu.RLock()
+	companies := []*Company{}
+	for _, company := range m.companies {
+		if company.DeletedAt == nil && query.matches(company) {
+			companies = append(companies, copyCompany(company))
+		}
+	}
+	m.mu.RUnlock()
+	sortCompanies(companies, Filters{Sort: "name", SortSafelist: CompanySortSafelist})
+	for _, company := range companies {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(company); err != nil {
+			return err
+		}
+	}
+	return nil
+}
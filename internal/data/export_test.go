@@ -0,0 +1,4 @@
+package data
+
+// NewTestDB gives the external tests of the package access to the test database.
+var NewTestDB = newTestDB
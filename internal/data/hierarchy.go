@@ -0,0 +1,317 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"mborgnolo/companyservice/internal/validator"
+	"strconv"
+)
+
+var (
+	// ErrParentNotFound is returned when the parent of a company does not exist or is deleted.
+	ErrParentNotFound = errors.New("parent company not found")
+	// ErrHierarchyCycle is returned when a company would become its own ancestor.
+	ErrHierarchyCycle = errors.New("company hierarchy cycle")
+	// ErrHasSubsidiaries is returned when a company with subsidiaries is deleted.
+	ErrHasSubsidiaries = errors.New("company has subsidiaries")
+)
+
+// MaxHierarchyDepth is the maximum number of levels of subsidiaries read at once.
+const MaxHierarchyDepth = 10
+
+// hierarchyLockKey is the key of the Postgres advisory lock serialising the changes of
+// parent. Without it, two concurrent changes could each close half of a cycle which
+// neither of them sees.
+const hierarchyLockKey = 7301002019
+
+// CompanyNode is a company of the hierarchy of another one, along with its distance
+// from it: 1 for a direct subsidiary or the parent, 2 for the next level, and so on.
+type CompanyNode struct {
+	*Company
+	Depth int `json:"depth"`
+}
+
+// ValidateDepth runs validation checks on the number of levels of a hierarchy query.
+func ValidateDepth(v *validator.Validator, depth int) {
+	v.Check(depth >= 1 && depth <= MaxHierarchyDepth, "depth", "must be between 1 and "+strconv.Itoa(MaxHierarchyDepth))
+}
+
+// hasSubsidiaries reports whether a company has subsidiaries which are not deleted.
+func hasSubsidiaries(ctx context.Context, tx *sql.Tx, id uuid.UUID) (bool, error) {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM company WHERE parent_id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+	return exists, err
+}
+
+// getCompanyParent returns the parent of a company, or nil if it has none, as part of
+// a transaction.
+func getCompanyParent(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*uuid.UUID, error) {
+	var parentID *uuid.UUID
+	err := tx.QueryRowContext(ctx, `SELECT parent_id FROM company WHERE id = $1`, id).Scan(&parentID)
+	return parentID, err
+}
+
+// restoredParent returns the parent a deleted company keeps when it is restored: its
+// parent, locked until the end of the transaction so that it cannot be deleted in the
+// meantime, or nil if the parent has been deleted too.
+func restoredParent(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*uuid.UUID, error) {
+	query := `SELECT p.id FROM company c JOIN company p ON p.id = c.parent_id
+		WHERE c.id = $1 AND p.deleted_at IS NULL FOR UPDATE OF p`
+	var parentID uuid.UUID
+	err := tx.QueryRowContext(ctx, query, id).Scan(&parentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &parentID, nil
+}
+
+// SetCompanyParent makes parentID the parent of a company and increments its version,
+// along with the CompanyUpdated event in the outbox. actor is the user making the
+// change. Like the tags, the parent is not part of the revisions. ErrRecordNotFound
+// is returned if the company does not exist or is deleted, ErrParentNotFound if the
+// parent does not exist or is deleted, and ErrHierarchyCycle if the parent is the
+// company itself or one of its subsidiaries.
+func (m *CompanyModel) SetCompanyParent(ctx context.Context, id, parentID uuid.UUID, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, hierarchyLockKey); err != nil {
+		return err
+	}
+	company, err := lockActiveCompany(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if parentID == id {
+		return ErrHierarchyCycle
+	}
+	if _, err = lockActiveCompany(ctx, tx, parentID); err != nil {
+		if err == ErrRecordNotFound {
+			return ErrParentNotFound
+		}
+		return err
+	}
+	query := `WITH RECURSIVE ancestors (id, parent_id) AS (
+			SELECT id, parent_id FROM company WHERE id = $1
+			UNION
+			SELECT c.id, c.parent_id FROM company c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`
+	var cycle bool
+	if err = tx.QueryRowContext(ctx, query, parentID, id).Scan(&cycle); err != nil {
+		return err
+	}
+	if cycle {
+		return ErrHierarchyCycle
+	}
+	old, err := getCompanyParent(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if old != nil && *old == parentID {
+		return tx.Commit()
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE company SET parent_id = $2 WHERE id = $1`, id, parentID); err != nil {
+		return err
+	}
+	if err = recordNewVersion(ctx, tx, company, FieldChange{Field: "parent_id", Old: old, New: &parentID}, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClearCompanyParent makes a company a top-level company and increments its version,
+// along with the CompanyUpdated event in the outbox. actor is the user making the
+// change. ErrRecordNotFound is returned if the company does not exist, is deleted or
+// has no parent.
+func (m *CompanyModel) ClearCompanyParent(ctx context.Context, id uuid.UUID, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	company, err := lockActiveCompany(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	old, err := getCompanyParent(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return ErrRecordNotFound
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE company SET parent_id = NULL WHERE id = $1`, id); err != nil {
+		return err
+	}
+	if err = recordNewVersion(ctx, tx, company, FieldChange{Field: "parent_id", Old: old, New: (*uuid.UUID)(nil)}, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// companyNodeColumns are the columns of the companies of a hierarchy query, joined
+// with the recursive table h holding their depth.
+const companyNodeColumns = `company."id", company."name", company."description", company."employees", company."registered", company."type",
+	company."version", company."parent_id", company."attributes", ` + companyTagsColumn + `, h."depth"`
+
+// queryCompanyNodes returns the companies of a hierarchy query selecting companyNodeColumns.
+func (m *CompanyModel) queryCompanyNodes(ctx context.Context, query string, args ...interface{}) ([]*CompanyNode, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nodes := []*CompanyNode{}
+	for rows.Next() {
+		node := &CompanyNode{Company: &Company{}}
+		company := node.Company
+		err := rows.Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type,
+			&company.Version, &company.ParentID, &company.Attributes, pq.Array(&company.Tags), &node.Depth)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// GetSubsidiaries returns the subsidiaries of a company down to the given depth,
+// level by level and sorted by name within a level. ErrRecordNotFound is returned
+// if the company does not exist or is deleted.
+func (m *CompanyModel) GetSubsidiaries(ctx context.Context, id uuid.UUID, depth int) ([]*CompanyNode, error) {
+	if _, err := m.GetCompany(ctx, id); err != nil {
+		return nil, err
+	}
+	query := `WITH RECURSIVE h (id, depth) AS (
+			SELECT id, 1 FROM company WHERE parent_id = $1 AND deleted_at IS NULL
+			UNION ALL
+			SELECT c.id, h.depth + 1 FROM company c JOIN h ON c.parent_id = h.id
+			WHERE c.deleted_at IS NULL AND h.depth < $2
+		)
+		SELECT ` + companyNodeColumns + ` FROM h JOIN company ON company.id = h.id
+		ORDER BY h.depth, company.name, company.id`
+	return m.queryCompanyNodes(ctx, query, id, depth)
+}
+
+// GetAncestors returns the ancestors of a company, from its parent up to the top of
+// its hierarchy. ErrRecordNotFound is returned if the company does not exist or is
+// deleted.
+func (m *CompanyModel) GetAncestors(ctx context.Context, id uuid.UUID) ([]*CompanyNode, error) {
+	if _, err := m.GetCompany(ctx, id); err != nil {
+		return nil, err
+	}
+	query := `WITH RECURSIVE h (id, depth) AS (
+			SELECT parent_id, 1 FROM company WHERE id = $1 AND parent_id IS NOT NULL
+			UNION ALL
+			SELECT c.parent_id, h.depth + 1 FROM company c JOIN h ON c.id = h.id
+			WHERE c.parent_id IS NOT NULL
+		)
+		SELECT ` + companyNodeColumns + ` FROM h JOIN company ON company.id = h.id
+		ORDER BY h.depth`
+	return m.queryCompanyNodes(ctx, query, id)
+}
+
+// hasSubsidiaries reports whether a company has subsidiaries which are not deleted.
+// The caller must hold the lock.
+func (m *MemoryModel) hasSubsidiaries(id uuid.UUID) bool {
+	for _, company := range m.companies {
+		if company.ParentID != nil && *company.ParentID == id && company.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCompanyParent makes parentID the parent of a company and increments its version.
+func (m *MemoryModel) SetCompanyParent(ctx context.Context, id, parentID uuid.UUID, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(id) {
+		return ErrRecordNotFound
+	}
+	if parentID == id {
+		return ErrHierarchyCycle
+	}
+	if !m.activeCompany(parentID) {
+		return ErrParentNotFound
+	}
+	for ancestor := m.companies[parentID].ParentID; ancestor != nil; ancestor = m.companies[*ancestor].ParentID {
+		if *ancestor == id {
+			return ErrHierarchyCycle
+		}
+	}
+	company := m.companies[id]
+	if company.ParentID != nil && *company.ParentID == parentID {
+		return nil
+	}
+	after := copyCompany(company)
+	after.ParentID = &parentID
+	return m.recordNewVersion(company, after, FieldChange{Field: "parent_id", Old: company.ParentID, New: &parentID}, actor)
+}
+
+// ClearCompanyParent makes a company a top-level company and increments its version.
+func (m *MemoryModel) ClearCompanyParent(ctx context.Context, id uuid.UUID, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(id) || m.companies[id].ParentID == nil {
+		return ErrRecordNotFound
+	}
+	company := m.companies[id]
+	after := copyCompany(company)
+	after.ParentID = nil
+	return m.recordNewVersion(company, after, FieldChange{Field: "parent_id", Old: company.ParentID, New: (*uuid.UUID)(nil)}, actor)
+}
+
+// GetSubsidiaries returns the subsidiaries of a company down to the given depth,
+// level by level and sorted by name within a level.
+func (m *MemoryModel) GetSubsidiaries(ctx context.Context, id uuid.UUID, depth int) ([]*CompanyNode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.activeCompany(id) {
+		return nil, ErrRecordNotFound
+	}
+	nodes := []*CompanyNode{}
+	parents := map[uuid.UUID]bool{id: true}
+	for level := 1; level <= depth && len(parents) > 0; level++ {
+		var children []*Company
+		for _, company := range m.companies {
+			if company.DeletedAt == nil && company.ParentID != nil && parents[*company.ParentID] {
+				children = append(children, company)
+			}
+		}
+		sortCompanies(children, Filters{Sort: "name", SortSafelist: CompanySortSafelist})
+		parents = map[uuid.UUID]bool{}
+		for _, company := range children {
+			nodes = append(nodes, &CompanyNode{Company: copyCompany(company), Depth: level})
+			parents[company.ID] = true
+		}
+	}
+	return nodes, nil
+}
+
+// GetAncestors returns the ancestors of a company, from its parent up to the top of
+// its hierarchy.
+func (m *MemoryModel) GetAncestors(ctx context.Context, id uuid.UUID) ([]*CompanyNode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.activeCompany(id) {
+		return nil, ErrRecordNotFound
+	}
+	nodes := []*CompanyNode{}
+	for ancestor := m.companies[id].ParentID; ancestor != nil; ancestor = m.companies[*ancestor].ParentID {
+		nodes = append(nodes, &CompanyNode{Company: copyCompany(m.companies[*ancestor]), Depth: len(nodes) + 1})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"time"
+)
+
+// operationEvents maps the operations recorded in the revisions to the type of
+// the event published for them.
+var operationEvents = map[string]EventType{
+	OperationCreated:    CompanyCreated,
+	OperationUpdated:    CompanyUpdated,
+	OperationDeleted:    CompanyDeleted,
+	OperationRestored:   CompanyRestored,
+	OperationRolledBack: CompanyUpdated,
+}
+
+// recordChange records a change of a company as part of the transaction that made
+// it: the new revision, the temporal history of the company and the event in the
+// outbox. before is nil for a newly created company.
+func recordChange(ctx context.Context, tx *sql.Tx, operation string, before, after *Company, actor string) error {
+	if err := insertRevision(ctx, tx, operation, after, diffCompanies(before, after), actor); err != nil {
+		return err
+	}
+	if err := recordHistory(ctx, tx, after); err != nil {
+		return err
+	}
+	event := newEventRecord(operationEvents[operation], before, after, diffCompanies(before, after), actor)
+	return insertOutboxEvent(ctx, tx, event)
+}
+
+// recordCompanyUpdated records the CompanyUpdated event of a change of the addresses
+// of a company as part of the transaction that made it. The
+// versioned fields of the company, locked by the transaction, do not change, so no
+// revision is recorded and change describes the change. actor is the user making it.
+func recordCompanyUpdated(ctx context.Context, tx *sql.Tx, company *Company, change FieldChange, actor string) error {
+	return insertOutboxEvent(ctx, tx, newEventRecord(CompanyUpdated, company, company, []FieldChange{change}, actor))
+}
+
+// recordNewVersion records a change of the tags or the parent of a company, whose row
+// is locked by the transaction that made it: the version of the company is
+// incremented, so that its ETag changes, along with its revision, its temporal history
+// and the CompanyUpdated event. The tags and the parent are not part of the snapshots
+// of the revisions, so change describes the change in the revision and the event.
+// actor is the user making it, empty for the changes made by the service itself.
+func recordNewVersion(ctx context.Context, tx *sql.Tx, before *Company, change FieldChange, actor string) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE company SET version = version + 1 WHERE id = $1`, before.ID); err != nil {
+		return err
+	}
+	after, err := getCompanyForUpdate(ctx, tx, before.ID)
+	if err != nil {
+		return err
+	}
+	if err = insertRevision(ctx, tx, OperationUpdated, after, []FieldChange{change}, actor); err != nil {
+		return err
+	}
+	if err = recordHistory(ctx, tx, after); err != nil {
+		return err
+	}
+	return insertOutboxEvent(ctx, tx, newEventRecord(CompanyUpdated, before, after, []FieldChange{change}, actor))
+}
+
+// recordHistory maintains the temporal history of a company: the period of validity
+// of its previous state is closed, and a new period is opened for its new state
+// unless the company has been deleted. Both use the time at which the transaction
+// records the change, once it holds the lock of the company row, rather than its
+// start time: a transaction which waited for the lock would otherwise end the period
+// opened by the one it waited for before it starts.
+func recordHistory(ctx context.Context, tx *sql.Tx, after *Company) error {
+	var now time.Time
+	if err := tx.QueryRowContext(ctx, `SELECT clock_timestamp()`).Scan(&now); err != nil {
+		return err
+	}
+	query := `UPDATE company_history SET valid_to = $2 WHERE company_id = $1 AND valid_to IS NULL`
+	_, err := tx.ExecContext(ctx, query, after.ID, now)
+	if err != nil {
+		return err
+	}
+	if after.DeletedAt != nil {
+		return nil
+	}
+	query = `INSERT INTO company_history ("company_id", "name", "description", "employees", "registered", "type", "attributes", "version", "valid_from")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = tx.ExecContext(ctx, query, after.ID, after.Name, sql.NullString(after.Description), after.Employees, after.Registered, after.Type, after.Attributes, after.Version, now)
+	return err
+}
+
+// GetCompanyAsOf returns the state of a company at the given time, reconstructed from
+// its temporal history. ErrRecordNotFound is returned if the company did not exist,
+// or was deleted, at that time.
+func (m *CompanyModel) GetCompanyAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (*Company, error) {
+	query := `SELECT "company_id", "name", "description", "employees", "registered", "type", "attributes", "version" FROM company_history
+		WHERE company_id = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)`
+	company := &Company{}
+	err := m.DB.QueryRowContext(ctx, query, id, asOf).Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type,
+		&company.Attributes, &company.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return company, nil
+}
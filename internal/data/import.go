@@ -0,0 +1,161 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+)
+
+// importBatchSize is the number of companies inserted by each statement of an
+// import, which keeps the statements below the limit of 65535 parameters.
+const importBatchSize = 1000
+
+// ImportResult is the outcome of the import of one company: the id of the created
+// company, or the reason why it was not created.
+type ImportResult struct {
+	ID  uuid.UUID
+	Err error
+}
+
+// markRepeatedNames sets ErrDuplicateName as the result of the companies whose name
+// is used by an earlier company of the same import, and reports whether there is any.
+func markRepeatedNames(companies []*Company, results []ImportResult) bool {
+	repeated := false
+	seen := make(map[string]bool, len(companies))
+	for i, company := range companies {
+		if seen[company.Name] {
+			results[i].Err = ErrDuplicateName
+			repeated = true
+		}
+		seen[company.Name] = true
+	}
+	return repeated
+}
+
+// ImportCompanies inserts the companies in batches, in a single transaction, along
+// with their first revisions and CompanyCreated events in the outbox. The results
+// are in the order of the companies: ErrDuplicateName is reported for a company
+// whose name is already used by a company which is not deleted, or repeated in the import.
+// If atomic is true and any company cannot be created, none is created and the
+// results only report the failed companies.
+func (m *CompanyModel) ImportCompanies(ctx context.Context, companies []*Company, atomic bool, actor string) ([]ImportResult, error) {
+	results := make([]ImportResult, len(companies))
+	failed := markRepeatedNames(companies, results)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for start := 0; start < len(companies); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(companies) {
+			end = len(companies)
+		}
+		var values []string
+		var args []interface{}
+		for i := start; i < end; i++ {
+			if results[i].Err != nil {
+				continue
+			}
+			company := companies[i]
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+			args = append(args, uuid.New(), company.Name, company.Description.String, company.Employees, company.Registered, company.Type, company.Attributes)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		// The companies whose name is already used are skipped by ON CONFLICT, and
+		// are the ones missing from the returned rows.
+		query := `INSERT INTO company ("id", "name", "description", "employees", "registered", "type", "attributes") VALUES ` + strings.Join(values, ", ") + `
+			ON CONFLICT DO NOTHING
+			RETURNING "id", "name", "description", "employees", "registered", "type", "attributes", "version"`
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		inserted := make(map[string]*Company, len(values))
+		for rows.Next() {
+			company := &Company{}
+			err := rows.Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Attributes, &company.Version)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			inserted[company.Name] = company
+		}
+		if err = rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		rows.Close()
+		for i := start; i < end; i++ {
+			if results[i].Err != nil {
+				continue
+			}
+			after, ok := inserted[companies[i].Name]
+			if !ok {
+				results[i].Err = ErrDuplicateName
+				failed = true
+				continue
+			}
+			results[i].ID = after.ID
+			if failed && atomic {
+				continue
+			}
+			if err = recordChange(ctx, tx, OperationCreated, nil, after, actor); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if failed && atomic {
+		clearImportedIDs(results)
+		return results, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// clearImportedIDs removes the ids of the companies of an import which has been
+// rolled back.
+func clearImportedIDs(results []ImportResult) {
+	for i := range results {
+		results[i].ID = uuid.Nil
+	}
+}
+
+// ImportCompanies stores the companies, with the same results as the SQL model.
+func (m *MemoryModel) ImportCompanies(ctx context.Context, companies []*Company, atomic bool, actor string) ([]ImportResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := make([]ImportResult, len(companies))
+	failed := markRepeatedNames(companies, results)
+	for i, company := range companies {
+		// Like the NOT NULL constraint of the company table.
+		if company.Registered == nil {
+			return nil, errors.New("company registered is required")
+		}
+		if results[i].Err == nil && m.nameTaken(company.Name, uuid.Nil) {
+			results[i].Err = ErrDuplicateName
+			failed = true
+		}
+	}
+	if failed && atomic {
+		return results, nil
+	}
+	for i, company := range companies {
+		if results[i].Err != nil {
+			continue
+		}
+		after := newMemoryCompany(company)
+		if err := m.record(OperationCreated, nil, after, actor); err != nil {
+			return nil, err
+		}
+		results[i].ID = after.ID
+	}
+	return results, nil
+}
@@ -0,0 +1,965 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemoryModel is a thread-safe in-memory implementation of the company and outbox
+// repositories, used to run the service without Postgres. It follows the rules of
+// the SQL models: the names of the companies which are not deleted are unique, every
+// change records a revision, the temporal history of the company and an outbox
+// event. The operations never block, so the contexts are not used.
+type MemoryModel struct {
+	mu          sync.RWMutex
+	companies   map[uuid.UUID]*Company
+	revisions   map[uuid.UUID][]*CompanyRevision
+	history     map[uuid.UUID][]*historyPeriod
+	addresses   map[uuid.UUID][]*Address
+	outbox      []*memoryEvent
+	nextEventID int64
+	// webhooks and deliveries hold the webhooks and their deliveries, in the order
+	// the deliveries were enqueued.
+	webhooks       map[uuid.UUID]*Webhook
+	deliveries     []*WebhookDelivery
+	nextDeliveryID int64
+}
+
+// historyPeriod is the state of a company during its period of validity. A nil
+// ValidTo means that the state is the current one.
+type historyPeriod struct {
+	Company   *Company   `json:"company"`
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to"`
+}
+
+// memoryEvent is an outbox event which has not been sent yet. Sent events are
+// removed from the outbox.
+type memoryEvent struct {
+	Event     *OutboxEvent `json:"event"`
+	LastError string       `json:"last_error,omitempty"`
+	DeadAt    *time.Time   `json:"dead_at,omitempty"`
+}
+
+// memorySnapshot is the JSON representation of the content of a MemoryModel.
+type memorySnapshot struct {
+	Companies   []*Company         `json:"companies"`
+	Revisions   []*CompanyRevision `json:"revisions"`
+	History     []*historyPeriod   `json:"history"`
+	Addresses   []*Address         `json:"addresses"`
+	Outbox      []*memoryEvent     `json:"outbox"`
+	NextEventID int64              `json:"next_event_id"`
+	// Webhooks keep their secret, which is not part of their JSON representation.
+	Webhooks       []*memoryWebhook  `json:"webhooks,omitempty"`
+	Deliveries     []*memoryDelivery `json:"deliveries,omitempty"`
+	NextDeliveryID int64             `json:"next_delivery_id,omitempty"`
+}
+
+// memoryWebhook is the JSON representation of a webhook in a snapshot.
+type memoryWebhook struct {
+	*Webhook
+	Secret string `json:"secret"`
+}
+
+// memoryDelivery is the JSON representation of a delivery in a snapshot. The payload
+// is base64 encoded, so that the indentation of the snapshot does not alter it.
+type memoryDelivery struct {
+	*WebhookDelivery
+	Payload []byte `json:"payload"`
+}
+
+// NewMemoryModel returns a new empty MemoryModel.
+func NewMemoryModel() *MemoryModel {
+	return &MemoryModel{
+		companies:      make(map[uuid.UUID]*Company),
+		revisions:      make(map[uuid.UUID][]*CompanyRevision),
+		history:        make(map[uuid.UUID][]*historyPeriod),
+		addresses:      make(map[uuid.UUID][]*Address),
+		nextEventID:    1,
+		webhooks:       make(map[uuid.UUID]*Webhook),
+		nextDeliveryID: 1,
+	}
+}
+
+// LoadMemoryModel returns a MemoryModel holding the content of the snapshot file
+// written by Save. An empty MemoryModel is returned if the file does not exist.
+func LoadMemoryModel(path string) (*MemoryModel, error) {
+	m := NewMemoryModel()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return m, nil
+		}
+		return nil, err
+	}
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, err
+	}
+	for _, company := range snapshot.Companies {
+		m.companies[company.ID] = company
+	}
+	for _, revision := range snapshot.Revisions {
+		m.revisions[revision.CompanyID] = append(m.revisions[revision.CompanyID], revision)
+	}
+	for _, period := range snapshot.History {
+		m.history[period.Company.ID] = append(m.history[period.Company.ID], period)
+	}
+	for _, address := range snapshot.Addresses {
+		m.addresses[address.CompanyID] = append(m.addresses[address.CompanyID], address)
+	}
+	m.outbox = snapshot.Outbox
+	if snapshot.NextEventID > m.nextEventID {
+		m.nextEventID = snapshot.NextEventID
+	}
+	for _, webhook := range snapshot.Webhooks {
+		webhook.Webhook.Secret = webhook.Secret
+		m.webhooks[webhook.ID] = webhook.Webhook
+	}
+	for _, delivery := range snapshot.Deliveries {
+		delivery.WebhookDelivery.Payload = delivery.Payload
+		m.deliveries = append(m.deliveries, delivery.WebhookDelivery)
+	}
+	if snapshot.NextDeliveryID > m.nextDeliveryID {
+		m.nextDeliveryID = snapshot.NextDeliveryID
+	}
+	return m, nil
+}
+
+// Save writes the content of the model to a JSON snapshot file. The file is
+// replaced atomically, so a failed save leaves the previous snapshot intact.
+func (m *MemoryModel) Save(path string) error {
+	m.mu.RLock()
+	snapshot := memorySnapshot{
+		Companies:      []*Company{},
+		Revisions:      []*CompanyRevision{},
+		History:        []*historyPeriod{},
+		Addresses:      []*Address{},
+		Outbox:         m.outbox,
+		NextEventID:    m.nextEventID,
+		NextDeliveryID: m.nextDeliveryID,
+	}
+	for _, webhook := range m.webhooks {
+		snapshot.Webhooks = append(snapshot.Webhooks, &memoryWebhook{Webhook: webhook, Secret: webhook.Secret})
+	}
+	for _, delivery := range m.deliveries {
+		snapshot.Deliveries = append(snapshot.Deliveries, &memoryDelivery{WebhookDelivery: delivery, Payload: delivery.Payload})
+	}
+	for _, company := range m.companies {
+		snapshot.Companies = append(snapshot.Companies, company)
+		snapshot.Revisions = append(snapshot.Revisions, m.revisions[company.ID]...)
+		snapshot.History = append(snapshot.History, m.history[company.ID]...)
+		snapshot.Addresses = append(snapshot.Addresses, m.addresses[company.ID]...)
+	}
+	b, err := json.MarshalIndent(snapshot, "", "\t")
+	m.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Seed stores a company as is, along with its revisions, bypassing the rules of the
+// write methods. It is meant for fixtures: the temporal history of the company is
+// derived from the revisions, each one being valid until the next one.
+func (m *MemoryModel) Seed(company *Company, revisions ...*CompanyRevision) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.companies[company.ID] = copyCompany(company)
+	for i, revision := range revisions {
+		m.revisions[company.ID] = append(m.revisions[company.ID], copyRevision(revision))
+		if revision.Snapshot.DeletedAt != nil {
+			continue
+		}
+		period := &historyPeriod{Company: copyCompany(revision.Snapshot), ValidFrom: revision.CreatedAt}
+		if i+1 < len(revisions) {
+			validTo := revisions[i+1].CreatedAt
+			period.ValidTo = &validTo
+		}
+		m.history[company.ID] = append(m.history[company.ID], period)
+	}
+}
+
+// copyCompany returns a deep copy of the company, so that the stored companies are
+// never shared with the callers.
+func copyCompany(c *Company) *Company {
+	company := *c
+	if c.Registered != nil {
+		registered := *c.Registered
+		company.Registered = &registered
+	}
+	if c.DeletedAt != nil {
+		deletedAt := *c.DeletedAt
+		company.DeletedAt = &deletedAt
+	}
+	if c.ParentID != nil {
+		parentID := *c.ParentID
+		company.ParentID = &parentID
+	}
+	if c.Tags != nil {
+		company.Tags = append([]string{}, c.Tags...)
+	}
+	company.Attributes = c.Attributes.Clone()
+	return &company
+}
+
+// snapshotCompany returns a copy of the company without its parent and its tags,
+// which are not part of the revisions and of the temporal history, like in the SQL
+// model.
+func snapshotCompany(c *Company) *Company {
+	company := copyCompany(c)
+	company.ParentID = nil
+	company.Tags = nil
+	return company
+}
+
+// nameTaken reports whether the name is used by a company other than id which is not
+// deleted, like the unique index of the company table.
+func (m *MemoryModel) nameTaken(name string, id uuid.UUID) bool {
+	for _, company := range m.companies {
+		if company.Name == name && company.ID != id && company.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// lockVersion returns the company which is not deleted, or ErrEditConflict if its
+// version does not match the provided one. The caller must hold the write lock.
+func (m *MemoryModel) lockVersion(id uuid.UUID, version int) (*Company, error) {
+	company, ok := m.companies[id]
+	if !ok || company.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+	if company.Version != version {
+		return nil, ErrEditConflict
+	}
+	return company, nil
+}
+
+// record stores the new state of a company along with its revision, its temporal
+// history and the outbox event, like recordChange does in the SQL transactions.
+// before is nil for a newly created company. The caller must hold the write lock.
+func (m *MemoryModel) record(operation string, before, after *Company, actor string) error {
+	return m.recordChanges(operation, before, after, diffCompanies(before, after), actor)
+}
+
+// recordChanges stores the new state of a company like record, with the given
+// changes in its revision and its event. The caller must hold the write lock.
+func (m *MemoryModel) recordChanges(operation string, before, after *Company, changes []FieldChange, actor string) error {
+	var snapshot *Company
+	if before != nil {
+		snapshot = snapshotCompany(before)
+	}
+	event := newEventRecord(operationEvents[operation], snapshot, snapshotCompany(after), changes, actor)
+	if err := m.addEvent(event); err != nil {
+		return err
+	}
+	now := event.TimeStamp
+	m.companies[after.ID] = after
+	m.revisions[after.ID] = append(m.revisions[after.ID], &CompanyRevision{
+		CompanyID: after.ID,
+		Revision:  after.Version,
+		Operation: operation,
+		Snapshot:  snapshotCompany(after),
+		Changes:   changes,
+		Actor:     actor,
+		CreatedAt: now,
+	})
+	for _, period := range m.history[after.ID] {
+		if period.ValidTo == nil {
+			period.ValidTo = &now
+		}
+	}
+	if after.DeletedAt == nil {
+		m.history[after.ID] = append(m.history[after.ID], &historyPeriod{Company: snapshotCompany(after), ValidFrom: now})
+	}
+	return nil
+}
+
+// addEvent adds the event of a change of a company to the outbox. The caller must
+// hold the write lock.
+func (m *MemoryModel) addEvent(event EventRecord) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	m.outbox = append(m.outbox, &memoryEvent{Event: &OutboxEvent{
+		ID:            m.nextEventID,
+		CompanyID:     event.ID,
+		Type:          event.Type.String(),
+		Payload:       payload,
+		NextAttemptAt: event.TimeStamp,
+		CreatedAt:     event.TimeStamp,
+	}})
+	m.nextEventID++
+	return nil
+}
+
+// addCompanyUpdated adds the CompanyUpdated event of a change of the addresses of a
+// company to the outbox, like recordCompanyUpdated. The caller must hold the write
+// lock.
+func (m *MemoryModel) addCompanyUpdated(companyID uuid.UUID, change FieldChange, actor string) error {
+	company := snapshotCompany(m.companies[companyID])
+	return m.addEvent(newEventRecord(CompanyUpdated, company, company, []FieldChange{change}, actor))
+}
+
+// recordNewVersion stores the new state of a company whose tags or parent changed,
+// with an incremented version, along with its revision, its temporal history and the
+// CompanyUpdated event, like recordNewVersion in the SQL transactions. The caller must
+// hold the write lock.
+func (m *MemoryModel) recordNewVersion(before, after *Company, change FieldChange, actor string) error {
+	after.Version = before.Version + 1
+	return m.recordChanges(OperationUpdated, before, after, []FieldChange{change}, actor)
+}
+
+// GetCompany returns a single company based on the ID provided.
+func (m *MemoryModel) GetCompany(ctx context.Context, id uuid.UUID) (*Company, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	company, ok := m.companies[id]
+	if !ok || company.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+	return copyCompany(company), nil
+}
+
+// GetCompanyAsOf returns the state of a company at the given time, reconstructed from
+// its temporal history.
+func (m *MemoryModel) GetCompanyAsOf(ctx context.Context, id uuid.UUID, asOf time.Time) (*Company, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, period := range m.history[id] {
+		if !period.ValidFrom.After(asOf) && (period.ValidTo == nil || period.ValidTo.After(asOf)) {
+			return copyCompany(period.Company), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// newMemoryCompany returns the stored state of a new company, as inserted in the
+// company table: the description is never NULL.
+func newMemoryCompany(company *Company) *Company {
+	after := copyCompany(company)
+	after.ID = uuid.New()
+	after.Description = CompanyDescription{String: company.Description.String, Valid: true}
+	after.Version = 1
+	after.ParentID = nil
+	after.Tags = nil
+	after.DeletedAt = nil
+	return after
+}
+
+// CreateCompany stores a new company. ErrDuplicateName is returned if the name is
+// already used.
+func (m *MemoryModel) CreateCompany(ctx context.Context, company *Company, actor string) (uuid.UUID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Like the NOT NULL constraint of the company table.
+	if company.Registered == nil {
+		return uuid.Nil, errors.New("company registered is required")
+	}
+	if m.nameTaken(company.Name, uuid.Nil) {
+		return uuid.Nil, ErrDuplicateName
+	}
+	after := newMemoryCompany(company)
+	if err := m.record(OperationCreated, nil, after, actor); err != nil {
+		return uuid.Nil, err
+	}
+	return after.ID, nil
+}
+
+// DeleteCompany moves a company to the trash if its version matches the provided one
+// and it has no subsidiaries.
+func (m *MemoryModel) DeleteCompany(ctx context.Context, id uuid.UUID, version int, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before, err := m.lockVersion(id, version)
+	if err != nil {
+		return err
+	}
+	if m.hasSubsidiaries(id) {
+		return ErrHasSubsidiaries
+	}
+	now := time.Now().UTC()
+	after := copyCompany(before)
+	after.DeletedAt = &now
+	after.Version++
+	return m.record(OperationDeleted, before, after, actor)
+}
+
+// UpdateCompany updates a company if its version matches the one of the provided
+// company, and sets the version of the provided company to the new version.
+func (m *MemoryModel) UpdateCompany(ctx context.Context, company *Company, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before, err := m.lockVersion(company.ID, company.Version)
+	if err != nil {
+		return err
+	}
+	if m.nameTaken(company.Name, company.ID) {
+		return ErrDuplicateName
+	}
+	after := copyCompany(before)
+	after.Name = company.Name
+	after.Description = CompanyDescription{String: company.Description.String, Valid: true}
+	after.Employees = company.Employees
+	if company.Registered != nil {
+		registered := *company.Registered
+		after.Registered = &registered
+	}
+	after.Type = company.Type
+	after.Attributes = company.Attributes.Clone()
+	after.Version++
+	if err := m.record(OperationUpdated, before, after, actor); err != nil {
+		return err
+	}
+	company.Version = after.Version
+	return nil
+}
+
+// matches reports whether the company matches the listing criteria.
+func (query CompanyQuery) matches(c *Company) bool {
+	registered := c.Registered != nil && *c.Registered
+	return strings.Contains(strings.ToLower(c.Name), strings.ToLower(query.Name)) &&
+		(query.Type == "" || c.Type == query.Type) &&
+		(query.Registered == nil || registered == *query.Registered) &&
+		(query.MinEmployees == 0 || c.Employees >= query.MinEmployees) &&
+		(query.MaxEmployees == 0 || c.Employees <= query.MaxEmployees) &&
+		(query.Tag == "" || hasTag(c.Tags, query.Tag))
+}
+
+// compareCompanies compares two companies by the given column, returning -1, 0 or
+// +1. NULL descriptions are compared as empty strings, like in the keyset queries.
+func compareCompanies(column string, a, b *Company) int {
+	switch column {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "description":
+		return strings.Compare(a.Description.String, b.Description.String)
+	case "employees":
+		return compareInts(a.Employees, b.Employees)
+	case "registered":
+		return compareInts(boolToInt(a.Registered), boolToInt(b.Registered))
+	case "type":
+		return strings.Compare(a.Type, b.Type)
+	case "deleted_at":
+		var ta, tb time.Time
+		if a.DeletedAt != nil {
+			ta = *a.DeletedAt
+		}
+		if b.DeletedAt != nil {
+			tb = *b.DeletedAt
+		}
+		return compareInts(int(ta.Sub(tb)), 0)
+	default:
+		return strings.Compare(a.ID.String(), b.ID.String())
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareRanks(a, b float32) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b *bool) int {
+	if b != nil && *b {
+		return 1
+	}
+	return 0
+}
+
+// sortCompanies sorts the companies according to the filters, then by id.
+func sortCompanies(companies []*Company, filters Filters) {
+	column := filters.sortColumn()
+	descending := filters.sortDirection() == "DESC"
+	sort.Slice(companies, func(i, j int) bool {
+		c := compareCompanies(column, companies[i], companies[j])
+		if descending {
+			c = -c
+		}
+		if c == 0 {
+			return compareCompanies("id", companies[i], companies[j]) < 0
+		}
+		return c < 0
+	})
+}
+
+// pageBounds returns the bounds of the page selected by the filters in a listing
+// of n items.
+func pageBounds(n int, filters Filters) (int, int) {
+	start := filters.offset()
+	if start > n {
+		start = n
+	}
+	end := start + filters.limit()
+	if end > n {
+		end = n
+	}
+	return start, end
+}
+
+// GetAllCompanies returns the page of companies matching the query, sorted and
+// paginated according to the filters, along with the pagination metadata.
+func (m *MemoryModel) GetAllCompanies(ctx context.Context, query CompanyQuery, filters Filters) ([]*Company, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matching := []*Company{}
+	for _, company := range m.companies {
+		if company.DeletedAt == nil && query.matches(company) {
+			matching = append(matching, company)
+		}
+	}
+	sortCompanies(matching, filters)
+	start, end := pageBounds(len(matching), filters)
+	companies := []*Company{}
+	for _, company := range matching[start:end] {
+		companies = append(companies, copyCompany(company))
+	}
+	return companies, CalculateMetadata(len(matching), filters.Page, filters.PageSize), nil
+}
+
+// cursorCompany returns a company holding the sort value and the id of the cursor,
+// to be compared with the listed companies.
+func cursorCompany(column string, cursor *Cursor) (*Company, error) {
+	company := &Company{ID: cursor.ID}
+	var err error
+	switch column {
+	case "name":
+		company.Name = cursor.Value
+	case "description":
+		company.Description = CompanyDescription{String: cursor.Value, Valid: true}
+	case "employees":
+		company.Employees, err = strconv.Atoi(cursor.Value)
+	case "registered":
+		var registered bool
+		registered, err = strconv.ParseBool(cursor.Value)
+		company.Registered = &registered
+	case "type":
+		company.Type = cursor.Value
+	}
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return company, nil
+}
+
+// GetCompaniesByCursor returns a page of companies matching the query using keyset
+// pagination, along with the cursors of the surrounding pages.
+func (m *MemoryModel) GetCompaniesByCursor(ctx context.Context, query CompanyQuery, filters KeysetFilters) ([]*Company, CursorPage, error) {
+	column := filters.sortColumn()
+	descending := filters.sortDirection() == "DESC"
+	// When walking backward the companies are read in the opposite order and
+	// reversed by keysetPage.
+	if filters.Cursor != nil && filters.Cursor.Before {
+		descending = !descending
+	}
+	compare := func(a, b *Company) int {
+		c := compareCompanies(column, a, b)
+		if c == 0 {
+			c = compareCompanies("id", a, b)
+		}
+		if descending {
+			c = -c
+		}
+		return c
+	}
+	var after *Company
+	if filters.Cursor != nil {
+		var err error
+		if after, err = cursorCompany(column, filters.Cursor); err != nil {
+			return nil, CursorPage{}, err
+		}
+	}
+	m.mu.RLock()
+	matching := []*Company{}
+	for _, company := range m.companies {
+		if company.DeletedAt == nil && query.matches(company) && (after == nil || compare(company, after) > 0) {
+			matching = append(matching, company)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return compare(matching[i], matching[j]) < 0
+	})
+	companies := []*Company{}
+	for i := 0; i < len(matching) && i <= filters.PageSize; i++ {
+		companies = append(companies, copyCompany(matching[i]))
+	}
+	m.mu.RUnlock()
+	companies, page := keysetPage(companies, filters)
+	return companies, page, nil
+}
+
+// wordSpan is the position of a word in a text, along with the word in lower case.
+type wordSpan struct {
+	start, end int
+	word       string
+}
+
+// textWords splits text into words made of letters and digits, like tsWords.
+func textWords(text string) []wordSpan {
+	var words []wordSpan
+	start := -1
+	for i, r := range text + " " {
+		isWord := i < len(text) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			words = append(words, wordSpan{start: start, end: i, word: strings.ToLower(text[start:i])})
+			start = -1
+		}
+	}
+	return words
+}
+
+// matchTerm returns the indexes of the words matching the search term, a phrase of
+// consecutive words where words ending with :* match by prefix.
+func matchTerm(term []string, words []wordSpan) []int {
+	var matched []int
+	for i := 0; i+len(term) <= len(words); i++ {
+		ok := true
+		for k, t := range term {
+			if strings.HasSuffix(t, ":*") {
+				ok = strings.HasPrefix(words[i+k].word, strings.TrimSuffix(t, ":*"))
+			} else {
+				ok = words[i+k].word == t
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			for k := range term {
+				matched = append(matched, i+k)
+			}
+		}
+	}
+	return matched
+}
+
+// highlight wraps the given words of the text in <mark> tags.
+func highlight(text string, words []wordSpan, matched map[int]bool) string {
+	var b strings.Builder
+	last := 0
+	for i, w := range words {
+		if !matched[i] {
+			continue
+		}
+		b.WriteString(text[last:w.start])
+		b.WriteString("<mark>" + text[w.start:w.end] + "</mark>")
+		last = w.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// SearchCompanies returns the page of companies whose name or description contain
+// every term of the search query. Words are matched without the stemming of the
+// Postgres full-text search. Matches on the name weigh more in the rank than
+// matches on the description.
+func (m *MemoryModel) SearchCompanies(ctx context.Context, q string, filters Filters) ([]*CompanySearchResult, Metadata, error) {
+	terms := searchTerms(q)
+	m.mu.RLock()
+	matching := []*CompanySearchResult{}
+	for _, company := range m.companies {
+		if company.DeletedAt != nil || len(terms) == 0 {
+			continue
+		}
+		nameWords, descriptionWords := textWords(company.Name), textWords(company.Description.String)
+		nameMatched, descriptionMatched := map[int]bool{}, map[int]bool{}
+		var rank float32
+		found := true
+		for _, term := range terms {
+			inName, inDescription := matchTerm(term, nameWords), matchTerm(term, descriptionWords)
+			if len(inName) == 0 && len(inDescription) == 0 {
+				found = false
+				break
+			}
+			for _, i := range inName {
+				nameMatched[i] = true
+			}
+			for _, i := range inDescription {
+				descriptionMatched[i] = true
+			}
+			if len(inName) > 0 {
+				rank += 1.0
+			}
+			if len(inDescription) > 0 {
+				rank += 0.4
+			}
+		}
+		if !found {
+			continue
+		}
+		matching = append(matching, &CompanySearchResult{
+			Company:            copyCompany(company),
+			Rank:               rank / float32(len(terms)),
+			NameSnippet:        highlight(company.Name, nameWords, nameMatched),
+			DescriptionSnippet: highlight(company.Description.String, descriptionWords, descriptionMatched),
+		})
+	}
+	m.mu.RUnlock()
+	column := filters.sortColumn()
+	descending := filters.sortDirection() == "DESC"
+	sort.Slice(matching, func(i, j int) bool {
+		a, b := matching[i], matching[j]
+		c := compareCompanies(column, a.Company, b.Company)
+		if column == "rank" {
+			c = compareRanks(a.Rank, b.Rank)
+		}
+		if descending {
+			c = -c
+		}
+		if c == 0 {
+			return compareCompanies("id", a.Company, b.Company) < 0
+		}
+		return c < 0
+	})
+	start, end := pageBounds(len(matching), filters)
+	return matching[start:end], CalculateMetadata(len(matching), filters.Page, filters.PageSize), nil
+}
+
+// GetDeletedCompanies returns the page of companies in the trash, sorted and
+// paginated according to the filters, along with the pagination metadata.
+func (m *MemoryModel) GetDeletedCompanies(ctx context.Context, filters Filters) ([]*Company, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	deleted := []*Company{}
+	for _, company := range m.companies {
+		if company.DeletedAt != nil {
+			deleted = append(deleted, company)
+		}
+	}
+	sortCompanies(deleted, filters)
+	start, end := pageBounds(len(deleted), filters)
+	companies := []*Company{}
+	for _, company := range deleted[start:end] {
+		companies = append(companies, copyCompany(company))
+	}
+	return companies, CalculateMetadata(len(deleted), filters.Page, filters.PageSize), nil
+}
+
+// RestoreCompany moves a company out of the trash, without its parent if the parent
+// has been deleted too. ErrRecordNotFound is returned if the company is not in the trash.
+func (m *MemoryModel) RestoreCompany(ctx context.Context, id uuid.UUID, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before, ok := m.companies[id]
+	if !ok || before.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+	if m.nameTaken(before.Name, id) {
+		return ErrDuplicateName
+	}
+	after := copyCompany(before)
+	after.DeletedAt = nil
+	if after.ParentID != nil && !m.activeCompany(*after.ParentID) {
+		after.ParentID = nil
+	}
+	after.Version++
+	return m.record(OperationRestored, before, after, actor)
+}
+
+// PurgeDeletedCompanies permanently removes the companies deleted before the given
+// time, and returns the number of companies removed. Their revisions and history
+// are kept, like in the SQL model, and the companies which had them as parent lose
+// their parent, which increments their version.
+func (m *MemoryModel) PurgeDeletedCompanies(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, company := range m.companies {
+		if company.DeletedAt != nil && company.DeletedAt.Before(deletedBefore) {
+			delete(m.companies, id)
+			delete(m.addresses, id)
+			n++
+		}
+	}
+	for _, company := range m.companies {
+		if company.ParentID != nil && m.companies[*company.ParentID] == nil {
+			after := copyCompany(company)
+			after.ParentID = nil
+			if err := m.recordNewVersion(company, after, FieldChange{Field: "parent_id", Old: company.ParentID, New: (*uuid.UUID)(nil)}, ""); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// copyRevision returns a deep copy of the revision.
+func copyRevision(r *CompanyRevision) *CompanyRevision {
+	revision := *r
+	revision.Snapshot = copyCompany(r.Snapshot)
+	revision.Changes = append([]FieldChange{}, r.Changes...)
+	return &revision
+}
+
+// GetCompanyRevisions returns the page of revisions of a company, latest first,
+// along with the pagination metadata. ErrRecordNotFound is returned if the company
+// has no revision.
+func (m *MemoryModel) GetCompanyRevisions(ctx context.Context, id uuid.UUID, filters Filters) ([]*CompanyRevision, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := m.revisions[id]
+	if len(all) == 0 && filters.Page == 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+	start, end := pageBounds(len(all), filters)
+	revisions := []*CompanyRevision{}
+	// The revisions are stored oldest first.
+	for i := start; i < end; i++ {
+		revisions = append(revisions, copyRevision(all[len(all)-1-i]))
+	}
+	return revisions, CalculateMetadata(len(all), filters.Page, filters.PageSize), nil
+}
+
+// GetCompanyRevision returns a single revision of a company.
+func (m *MemoryModel) GetCompanyRevision(ctx context.Context, id uuid.UUID, revision int) (*CompanyRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.getRevision(id, revision)
+}
+
+func (m *MemoryModel) getRevision(id uuid.UUID, revision int) (*CompanyRevision, error) {
+	for _, r := range m.revisions[id] {
+		if r.Revision == revision {
+			return copyRevision(r), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// RollbackCompany restores the fields of a company to their state at the given
+// revision if its version matches the provided one, and returns the updated company.
+func (m *MemoryModel) RollbackCompany(ctx context.Context, id uuid.UUID, revision, version int, actor string) (*Company, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	target, err := m.getRevision(id, revision)
+	if err != nil {
+		return nil, err
+	}
+	before, err := m.lockVersion(id, version)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := target.Snapshot
+	if m.nameTaken(snapshot.Name, id) {
+		return nil, ErrDuplicateName
+	}
+	after := copyCompany(before)
+	after.Name = snapshot.Name
+	after.Description = snapshot.Description
+	after.Employees = snapshot.Employees
+	after.Registered = snapshot.Registered
+	after.Type = snapshot.Type
+	after.Attributes = snapshot.Attributes.Clone()
+	after.Version++
+	if err := m.record(OperationRolledBack, before, after, actor); err != nil {
+		return nil, err
+	}
+	return copyCompany(after), nil
+}
+
+// LockRelay always takes the lock of the outbox relay: the memory storage is not
+// shared by several instances.
+func (m *MemoryModel) LockRelay(ctx context.Context) (unlock func(), ok bool, err error) {
+	return func() {}, true, nil
+}
+
+// GetPendingEvents returns up to limit events which have not been sent yet, in
+// the order they were recorded.
+func (m *MemoryModel) GetPendingEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	events := []*OutboxEvent{}
+	for _, e := range m.outbox {
+		if len(events) == limit {
+			break
+		}
+		if e.DeadAt != nil {
+			continue
+		}
+		event := *e.Event
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// MarkEventSent removes the delivered event from the outbox.
+func (m *MemoryModel) MarkEventSent(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.outbox {
+		if e.Event.ID == id {
+			m.outbox = append(m.outbox[:i], m.outbox[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRecordNotFound
+}
+
+// DeleteSentEvents removes nothing: the sent events are removed from the outbox as
+// soon as they are sent.
+func (m *MemoryModel) DeleteSentEvents(ctx context.Context, sentBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+// MarkEventFailed records a failed delivery attempt of the event along with its
+// cause, and schedules the next attempt. After maxAttempts failed attempts the event
+// is dead, like in the SQL model.
+func (m *MemoryModel) MarkEventFailed(ctx context.Context, id int64, nextAttempt time.Time, cause error, maxAttempts int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.outbox {
+		if e.Event.ID == id {
+			e.Event.Attempts++
+			e.Event.NextAttemptAt = nextAttempt
+			e.LastError = cause.Error()
+			if e.Event.Attempts >= maxAttempts {
+				now := time.Now()
+				e.DeadAt = &now
+			}
+			return e.DeadAt != nil, nil
+		}
+	}
+	return false, ErrRecordNotFound
+}
@@ -0,0 +1,314 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestMemoryModel returns a MemoryModel holding the companies of the SQL test data.
+func newTestMemoryModel(t *testing.T) *MemoryModel {
+	m := NewMemoryModel()
+	companies := []*Company{
+		{Name: "Company One", Description: CompanyDescription{String: "Cloud data platform", Valid: true}, Employees: 100, Registered: boolPtr(true), Type: "Corporations"},
+		{Name: "Company Two", Description: CompanyDescription{String: "Charity for data literacy", Valid: true}, Employees: 20, Registered: boolPtr(false), Type: "NonProfit"},
+		{Name: "Three", Description: CompanyDescription{String: "Farming", Valid: true}, Employees: 5, Registered: boolPtr(true), Type: "Cooperative"},
+	}
+	for _, company := range companies {
+		if _, err := m.CreateCompany(context.Background(), company, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func companyNames(companies []*Company) []string {
+	names := []string{}
+	for _, company := range companies {
+		names = append(names, company.Name)
+	}
+	return names
+}
+
+func TestMemoryModelOutbox(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemoryModel(t)
+	events, err := m.GetPendingEvents(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].ID != 1 || events[0].Type != "CompanyCreated" {
+		t.Fatalf("want the first 2 creation events; got %+v", events)
+	}
+	if err := m.MarkEventSent(ctx, events[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if dead, err := m.MarkEventFailed(ctx, events[1].ID, time.Now().Add(time.Minute), ErrEditConflict, 2); err != nil || dead {
+		t.Fatalf("want the event failed once; got dead %t, %v", dead, err)
+	}
+	events, _ = m.GetPendingEvents(ctx, 100)
+	if len(events) != 2 || events[0].ID != 2 || events[0].Attempts != 1 {
+		t.Errorf("want 2 pending events, the first one attempted once; got %+v", events)
+	}
+	// The event is dead after its last attempt, and no longer pending.
+	if dead, err := m.MarkEventFailed(ctx, events[0].ID, time.Now().Add(time.Minute), ErrEditConflict, 2); err != nil || !dead {
+		t.Fatalf("want the event dead; got dead %t, %v", dead, err)
+	}
+	if events, _ = m.GetPendingEvents(ctx, 100); len(events) != 1 || events[0].ID != 3 {
+		t.Errorf("want the event after the dead one pending; got %+v", events)
+	}
+	if err := m.MarkEventSent(ctx, 1); err != ErrRecordNotFound {
+		t.Errorf("mark sent event: want %v; got %v", ErrRecordNotFound, err)
+	}
+}
+
+func TestMemoryModelAddressEvents(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemoryModel(t)
+	companies, _, _ := m.GetAllCompanies(ctx, CompanyQuery{Name: "Three"}, Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist})
+	address := &Address{CompanyID: companies[0].ID, Type: AddressOperating, Lines: []string{"Farm road"}, City: "Utrecht", Country: "NL"}
+	if err := m.CreateCompanyAddress(ctx, address, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteCompanyAddress(ctx, address.CompanyID, address.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	events, _ := m.GetPendingEvents(ctx, 100)
+	if len(events) != 5 || events[3].Type != "CompanyUpdated" || events[4].Type != "CompanyUpdated" || events[4].CompanyID != address.CompanyID {
+		t.Errorf("want a CompanyUpdated event for each change of the addresses; got %+v", events)
+	}
+}
+
+func TestMemoryModelEventPayloads(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMemoryModel(t)
+	companies, _, _ := m.GetAllCompanies(ctx, CompanyQuery{Name: "Three"}, Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist})
+	company := companies[0]
+	company.Employees = 6
+	if err := m.UpdateCompany(ctx, company, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.AddCompanyTag(ctx, company.ID, "farming", "carol"); err != nil {
+		t.Fatal(err)
+	}
+	// Tagging the company incremented its version.
+	if err := m.DeleteCompany(ctx, company.ID, company.Version+1, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	events, _ := m.GetPendingEvents(ctx, 100)
+	if len(events) != 6 {
+		t.Fatalf("want 6 events; got %d", len(events))
+	}
+	payloads := make([]EventRecord, 3)
+	for i, event := range events[3:] {
+		if err := json.Unmarshal(event.Payload, &payloads[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated := payloads[0]
+	if updated.SchemaVersion != EventSchemaVersion || updated.Type != CompanyUpdated || updated.Actor != "alice" {
+		t.Errorf("want a version %d CompanyUpdated event by alice; got %+v", EventSchemaVersion, updated)
+	}
+	if updated.Before == nil || updated.Before.Employees != 5 || updated.After == nil || updated.After.Employees != 6 {
+		t.Errorf("want the states before and after the update; got %+v and %+v", updated.Before, updated.After)
+	}
+	if len(updated.Changes) != 1 || updated.Changes[0].Field != "employees" {
+		t.Errorf("want the employees change; got %+v", updated.Changes)
+	}
+
+	tagged := payloads[1]
+	if tagged.Type != CompanyUpdated || tagged.Actor != "carol" || len(tagged.Changes) != 1 || tagged.Changes[0].Field != "tags" {
+		t.Errorf("want the tags change by carol; got %+v", tagged)
+	}
+	if tags, ok := tagged.Changes[0].New.([]interface{}); !ok || len(tags) != 1 || tags[0] != "farming" {
+		t.Errorf("want the new tags; got %#v", tagged.Changes[0].New)
+	}
+	if tagged.Before == nil || tagged.After == nil || tagged.After.Version != tagged.Before.Version+1 {
+		t.Errorf("want the version incremented by the tags change; got %+v and %+v", tagged.Before, tagged.After)
+	}
+
+	deleted := payloads[2]
+	if deleted.Type != CompanyDeleted || deleted.Actor != "bob" || deleted.Before == nil || deleted.Before.Name != "Three" {
+		t.Errorf("want the deleted company in the CompanyDeleted event by bob; got %+v", deleted)
+	}
+	if deleted.After == nil || deleted.After.DeletedAt == nil {
+		t.Errorf("want the deletion time in the state after the deletion; got %+v", deleted.After)
+	}
+}
+
+func TestMemoryModelSeed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModel()
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	company := &Company{ID: uuid.MustParse("f1203d76-0491-47fe-9640-0aeda76ad3f6"), Name: "Company One", Employees: 200,
+		Registered: boolPtr(true), Type: "Corporations", Version: 2}
+	first := *company
+	first.Employees, first.Version = 100, 1
+	m.Seed(company,
+		&CompanyRevision{CompanyID: company.ID, Revision: 1, Operation: OperationCreated, Snapshot: &first, CreatedAt: created},
+		&CompanyRevision{CompanyID: company.ID, Revision: 2, Operation: OperationUpdated, Snapshot: company, CreatedAt: created.AddDate(0, 1, 0)},
+	)
+	got, err := m.GetCompanyAsOf(ctx, company.ID, created.AddDate(0, 0, 1))
+	if err != nil || got.Version != 1 {
+		t.Errorf("want revision 1 during its period of validity; got %+v, %v", got, err)
+	}
+	got, err = m.GetCompanyAsOf(ctx, company.ID, created.AddDate(1, 0, 0))
+	if err != nil || got.Version != 2 {
+		t.Errorf("want revision 2 after it; got %+v, %v", got, err)
+	}
+	if events, _ := m.GetPendingEvents(ctx, 100); len(events) != 0 {
+		t.Errorf("want no events for seeded companies; got %d", len(events))
+	}
+}
+
+func TestMemoryModelSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "companies.json")
+	empty, err := LoadMemoryModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if companies, _, _ := empty.GetAllCompanies(ctx, CompanyQuery{}, Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist}); len(companies) != 0 {
+		t.Fatalf("want no companies without snapshot; got %d", len(companies))
+	}
+
+	m := newTestMemoryModel(t)
+	webhook := &Webhook{URL: "https://partner.example.com", Secret: "0123456789abcdef"}
+	if err := m.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.EnqueueWebhookDeliveries(ctx, 1, "CompanyCreated", uuid.New(), []byte(`{"ID":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadMemoryModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := Filters{Page: 1, PageSize: 20, Sort: "name", SortSafelist: CompanySortSafelist}
+	want, _, _ := m.GetAllCompanies(ctx, CompanyQuery{}, filters)
+	got, _, _ := loaded.GetAllCompanies(ctx, CompanyQuery{}, filters)
+	if !equalStrings(companyNames(got), companyNames(want)) {
+		t.Fatalf("want %v; got %v", companyNames(want), companyNames(got))
+	}
+	if _, err := loaded.GetCompanyRevision(ctx, got[0].ID, 1); err != nil {
+		t.Errorf("want the revisions to be loaded; got %v", err)
+	}
+	if _, err := loaded.CreateCompany(ctx, &Company{Name: "Three", Employees: 1, Registered: boolPtr(true), Type: "Cooperative"}, "test"); err != ErrDuplicateName {
+		t.Errorf("want the names to stay unique; got %v", err)
+	}
+	events, _ := loaded.GetPendingEvents(ctx, 100)
+	if len(events) != 3 || events[2].ID != 3 {
+		t.Errorf("want the 3 pending events to be loaded; got %d", len(events))
+	}
+	deliveries, _ := loaded.ClaimWebhookDeliveries(ctx, 10, 0)
+	if len(deliveries) != 1 || deliveries[0].Secret != webhook.Secret || string(deliveries[0].Payload) != `{"ID":1}` {
+		t.Errorf("want the pending webhook delivery to be loaded with its secret and payload; got %+v", deliveries)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryModelWebhooks(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryModel()
+	created := &Webhook{URL: "https://partner.example.com/created", EventTypes: []string{"CompanyCreated"}, Secret: "0123456789abcdef"}
+	all := &Webhook{URL: "https://partner.example.com/all", Secret: "0123456789abcdef"}
+	for _, webhook := range []*Webhook{created, all} {
+		if err := m.CreateWebhook(ctx, webhook); err != nil {
+			t.Fatal(err)
+		}
+	}
+	companyID := uuid.New()
+	for id, eventType := range []string{"CompanyCreated", "CompanyDeleted", "CompanyDeleted"} {
+		if err := m.EnqueueWebhookDeliveries(ctx, int64(id/2+1), eventType, companyID, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := m.ClaimWebhookDeliveries(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 3 || pending[0].Secret != created.Secret {
+		t.Fatalf("want 3 pending deliveries, each event once per subscribed webhook; got %d", len(pending))
+	}
+
+	for _, delivery := range pending {
+		if delivery.WebhookID != all.ID {
+			continue
+		}
+		disabled, err := m.MarkWebhookDeliveryFailed(ctx, delivery.ID, 0, errors.New("connection refused"), time.Now(), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := delivery.EventID == 2; disabled != want {
+			t.Errorf("delivery %d: want disabled %t; got %t", delivery.ID, want, disabled)
+		}
+	}
+	if pending, _ = m.ClaimWebhookDeliveries(ctx, 10, 0); len(pending) != 1 || pending[0].WebhookID != created.ID {
+		t.Errorf("want only the deliveries of the enabled webhook pending; got %d", len(pending))
+	}
+	if err := m.MarkWebhookDelivered(ctx, pending[0].ID, 200); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, metadata, err := m.GetWebhookDeliveries(ctx, all.ID, Filters{Page: 1, PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].EventID != 2 || deliveries[0].LastError != "connection refused" || metadata.TotalRecords != 2 {
+		t.Errorf("want the most recent failed delivery of 2; got %+v, %+v", deliveries, metadata)
+	}
+
+	all.Enabled = true
+	if err := m.UpdateWebhook(ctx, all); err != nil {
+		t.Fatal(err)
+	}
+	if all.ConsecutiveFailures != 0 {
+		t.Errorf("want the failures reset when enabled again; got %d", all.ConsecutiveFailures)
+	}
+	if err := m.DeleteWebhook(ctx, all.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.GetWebhookDeliveries(ctx, all.ID, Filters{Page: 1, PageSize: 1}); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+
+	// A claimed delivery is not claimed again until its lease expires.
+	if err := m.EnqueueWebhookDeliveries(ctx, 3, "CompanyCreated", companyID, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ = m.ClaimWebhookDeliveries(ctx, 10, time.Minute); len(pending) != 1 || pending[0].EventID != 3 {
+		t.Errorf("want the delivery of event 3 claimed; got %+v", pending)
+	}
+	if pending, _ = m.ClaimWebhookDeliveries(ctx, 10, time.Minute); len(pending) != 0 {
+		t.Errorf("want no delivery claimed while leased; got %+v", pending)
+	}
+
+	// The delivered deliveries are pruned once they are old enough, unlike the pending ones.
+	if n, err := m.DeleteWebhookDeliveries(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("want no recent delivery pruned; got %d, %v", n, err)
+	}
+	if n, err := m.DeleteWebhookDeliveries(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("want the delivered delivery pruned; got %d, %v", n, err)
+	}
+	if deliveries, _, _ = m.GetWebhookDeliveries(ctx, created.ID, Filters{Page: 1, PageSize: 10}); len(deliveries) != 1 || deliveries[0].EventID != 3 {
+		t.Errorf("want the pending delivery of event 3 kept; got %+v", deliveries)
+	}
+}
@@ -1,15 +1,37 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"github.com/lib/pq"
 )
 
 var (
 	ErrRecordNotFound = errors.New("record not found")
+	ErrEditConflict   = errors.New("edit conflict")
+	ErrDuplicateName  = errors.New("duplicate company name")
 )
 
+// IsTimeout reports whether the error is caused by a query which did not complete
+// in time, either because the deadline of its context was exceeded or because
+// Postgres cancelled it after the statement_timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// isUniqueViolation reports whether the error is caused by a unique constraint,
+// which for the company table is the uniqueness of the name.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 type NullString sql.NullString
 
 func (x *NullString) MarshalJSON() ([]byte, error) {
@@ -23,3 +45,13 @@ func (x *NullString) MarshalJSON() ([]byte, error) {
 func NewCompanyModel(db *sql.DB) *CompanyModel {
 	return &CompanyModel{DB: db}
 }
+
+// NewOutboxModel returns a new OutboxModel.
+func NewOutboxModel(db *sql.DB) *OutboxModel {
+	return &OutboxModel{DB: db}
+}
+
+// NewWebhookModel returns a new WebhookModel.
+func NewWebhookModel(db *sql.DB) *WebhookModel {
+	return &WebhookModel{DB: db}
+}
@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+// OutboxEvent is an event stored in the company_outbox table, waiting to be
+// relayed to the message broker. Payload holds the JSON encoded EventRecord.
+type OutboxEvent struct {
+	ID            int64     `json:"id"`
+	CompanyID     uuid.UUID `json:"company_id"`
+	Type          string    `json:"event_type"`
+	Payload       []byte    `json:"payload"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	// SentAt is the time the event was sent, nil while it is pending.
+	SentAt *time.Time `json:"sent_at,omitempty"`
+}
+
+// insertOutboxEvent stores the event in the outbox as part of the transaction
+// that changed the company, so that the event is recorded if and only if the
+// change is committed.
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, event EventRecord) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO company_outbox ("company_id", "event_type", "payload", "created_at") VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, query, event.ID, event.Type.String(), payload, event.TimeStamp)
+	return err
+}
+
+// OutboxModel wraps the sql.DB connection pool.
+type OutboxModel struct {
+	DB *sql.DB
+}
+
+// relayLockKey is the key of the Postgres advisory lock of the outbox relay.
+const relayLockKey = 0x6f7574626f78
+
+// LockRelay takes the lock of the outbox relay, so that a single instance relays the
+// events at a time, in the order they were recorded. It reports false if another
+// instance holds the lock. The lock is held by a dedicated connection until unlock is
+// called.
+func (m *OutboxModel) LockRelay(ctx context.Context) (unlock func(), ok bool, err error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	err = conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, relayLockKey).Scan(&ok)
+	if err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, relayLockKey); err != nil {
+			// The connection is discarded rather than returned to the pool, which
+			// releases the lock along with the session.
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
+// GetPendingEvents returns up to limit events which have not been sent yet, in
+// the order they were recorded. The dead events are left out.
+func (m *OutboxModel) GetPendingEvents(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	query := `SELECT "id", "company_id", "event_type", "payload", "attempts", "next_attempt_at", "created_at" FROM company_outbox
+		WHERE sent_at IS NULL AND dead_at IS NULL ORDER BY id LIMIT $1`
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		event := &OutboxEvent{}
+		err := rows.Scan(&event.ID, &event.CompanyID, &event.Type, &event.Payload, &event.Attempts, &event.NextAttemptAt, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetSentEvents returns up to limit events sent after the given one, in the order they
+// were sent, which is the order they were relayed in since a single instance relays
+// them at a time. If after is nil, the last limit events sent are returned.
+func (m *OutboxModel) GetSentEvents(ctx context.Context, after *OutboxEvent, limit int) ([]*OutboxEvent, error) {
+	query := `SELECT "id", "company_id", "event_type", "payload", "attempts", "next_attempt_at", "created_at", "sent_at" FROM (
+			SELECT * FROM company_outbox WHERE sent_at IS NOT NULL ORDER BY sent_at DESC, id DESC LIMIT $1
+		) e ORDER BY sent_at, id`
+	args := []interface{}{limit}
+	if after != nil {
+		query = `SELECT "id", "company_id", "event_type", "payload", "attempts", "next_attempt_at", "created_at", "sent_at" FROM company_outbox
+			WHERE sent_at IS NOT NULL AND (sent_at, id) > ($2, $3) ORDER BY sent_at, id LIMIT $1`
+		args = append(args, after.SentAt, after.ID)
+	}
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*OutboxEvent{}
+	for rows.Next() {
+		event := &OutboxEvent{}
+		err := rows.Scan(&event.ID, &event.CompanyID, &event.Type, &event.Payload, &event.Attempts, &event.NextAttemptAt, &event.CreatedAt,
+			&event.SentAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteSentEvents permanently removes the events sent before the given time, and
+// returns the number of events removed. The pending and dead events are kept.
+func (m *OutboxModel) DeleteSentEvents(ctx context.Context, sentBefore time.Time) (int64, error) {
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM company_outbox WHERE sent_at < $1`, sentBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// MarkEventSent records that the event has been delivered.
+func (m *OutboxModel) MarkEventSent(ctx context.Context, id int64) error {
+	query := `UPDATE company_outbox SET sent_at = NOW(), last_error = NULL WHERE id = $1`
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	i, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if i == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// MarkEventFailed records a failed delivery attempt of the event along with its
+// cause, and schedules the next attempt. After maxAttempts failed attempts the event
+// is dead: it is no longer pending, so that it stops holding back the following
+// events, and is kept with its last error until it is retried by hand. It reports
+// whether the event is dead.
+func (m *OutboxModel) MarkEventFailed(ctx context.Context, id int64, nextAttempt time.Time, cause error, maxAttempts int) (bool, error) {
+	query := `UPDATE company_outbox SET attempts = attempts + 1, next_attempt_at = $1, last_error = $2,
+		dead_at = CASE WHEN attempts + 1 >= $4 THEN NOW() END
+		WHERE id = $3
+		RETURNING dead_at IS NOT NULL`
+	var dead bool
+	err := m.DB.QueryRowContext(ctx, query, nextAttempt, cause.Error(), id, maxAttempts).Scan(&dead)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrRecordNotFound
+		}
+		return false, err
+	}
+	return dead, nil
+}
@@ -0,0 +1,126 @@
+//go:build integration
+// +build integration
+
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"testing"
+	"time"
+)
+
+func TestOutboxModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	c := CompanyModel{db}
+	o := OutboxModel{db}
+
+	id, err := c.CreateCompany(context.Background(), &Company{Name: "Company Two", Employees: 10, Registered: boolPtr(true), Type: "NonProfit"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteCompany(context.Background(), id, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	// A failed mutation must not record an event.
+	if err = c.DeleteCompany(context.Background(), uuid.MustParse("e2d3253c-3e65-4516-9318-d013fde56dca"), 1, ""); err != ErrRecordNotFound {
+		t.Fatalf("want %v; got %v", ErrRecordNotFound, err)
+	}
+
+	events, err := o.GetPendingEvents(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("want 2 pending events; got %d", len(events))
+	}
+	if events[0].Type != "CompanyCreated" || events[1].Type != "CompanyDeleted" || events[0].CompanyID != id {
+		t.Errorf("unexpected events %+v %+v", events[0], events[1])
+	}
+	var deleted EventRecord
+	if err = json.Unmarshal(events[1].Payload, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.SchemaVersion != EventSchemaVersion || deleted.Before == nil || deleted.Before.Name != "Company Two" || deleted.After == nil || deleted.After.DeletedAt == nil {
+		t.Errorf("want the states of the deleted company in the payload; got %+v", deleted)
+	}
+
+	if dead, err := o.MarkEventFailed(context.Background(), events[0].ID, time.Now().Add(time.Minute), errors.New("broker unavailable"), 2); err != nil || dead {
+		t.Fatalf("want the event failed once; got dead %t, %v", dead, err)
+	}
+	if err = o.MarkEventSent(context.Background(), events[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	events, err = o.GetPendingEvents(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Attempts != 1 {
+		t.Errorf("want 1 pending event with 1 attempt; got %+v", events)
+	}
+
+	// The sent events are returned in the order they were sent, from the last ones.
+	if err = o.MarkEventSent(context.Background(), events[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := o.GetSentEvents(context.Background(), nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || sent[0].ID != events[0].ID || sent[0].SentAt == nil {
+		t.Fatalf("want the last event sent; got %+v", sent)
+	}
+	if sent, err = o.GetSentEvents(context.Background(), nil, 10); err != nil || len(sent) != 2 || sent[1].ID != events[0].ID {
+		t.Fatalf("want the 2 events sent, in order; got %+v, %v", sent, err)
+	}
+	if sent, err = o.GetSentEvents(context.Background(), sent[0], 10); err != nil || len(sent) != 1 || sent[0].ID != events[0].ID {
+		t.Errorf("want the event sent after the first one; got %+v, %v", sent, err)
+	}
+	if sent, err = o.GetSentEvents(context.Background(), sent[0], 10); err != nil || len(sent) != 0 {
+		t.Errorf("want no event sent after the last one; got %+v, %v", sent, err)
+	}
+
+	// An event is dead after its last attempt, and no longer pending.
+	if _, err = c.CreateCompany(context.Background(), &Company{Name: "Company Dead", Employees: 1, Registered: boolPtr(true), Type: "NonProfit"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if events, err = o.GetPendingEvents(context.Background(), 10); err != nil || len(events) != 1 {
+		t.Fatalf("want 1 pending event; got %+v, %v", events, err)
+	}
+	if dead, err := o.MarkEventFailed(context.Background(), events[0].ID, time.Now(), errors.New("rejected"), 1); err != nil || !dead {
+		t.Errorf("want the event dead; got dead %t, %v", dead, err)
+	}
+	if events, err = o.GetPendingEvents(context.Background(), 10); err != nil || len(events) != 0 {
+		t.Errorf("want no pending event; got %+v, %v", events, err)
+	}
+
+	// The sent events are pruned once they are old enough, unlike the dead one.
+	if n, err := o.DeleteSentEvents(context.Background(), time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("want no recent event pruned; got %d, %v", n, err)
+	}
+	if n, err := o.DeleteSentEvents(context.Background(), time.Now().Add(time.Second)); err != nil || n != 2 {
+		t.Errorf("want the 2 sent events pruned; got %d, %v", n, err)
+	}
+
+	// A single relay holds the lock at a time.
+	unlock, ok, err := o.LockRelay(context.Background())
+	if err != nil || !ok {
+		t.Fatalf("want the relay lock; got %t, %v", ok, err)
+	}
+	if _, ok, err := o.LockRelay(context.Background()); err != nil || ok {
+		t.Errorf("want the relay lock held; got %t, %v", ok, err)
+	}
+	unlock()
+	unlock, ok, err = o.LockRelay(context.Background())
+	if err != nil || !ok {
+		t.Fatalf("want the relay lock once released; got %t, %v", ok, err)
+	}
+	unlock()
+}
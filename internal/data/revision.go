@@ -0,0 +1,239 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"reflect"
+	"time"
+)
+
+// Operations recorded in the company revisions.
+const (
+	OperationCreated    = "created"
+	OperationUpdated    = "updated"
+	OperationDeleted    = "deleted"
+	OperationRestored   = "restored"
+	OperationRolledBack = "rolled_back"
+)
+
+// FieldChange is the change of a single company field between two revisions.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// CompanyRevision is the state of a company after one of its changes, along with
+// the fields changed, the user who made the change and when. The revision number
+// is the version of the company after the change.
+type CompanyRevision struct {
+	CompanyID uuid.UUID     `json:"company_id"`
+	Revision  int           `json:"revision"`
+	Operation string        `json:"operation"`
+	Snapshot  *Company      `json:"snapshot"`
+	Changes   []FieldChange `json:"changes"`
+	Actor     string        `json:"actor"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// diffCompanies returns the changes of the company fields between before and after.
+// A nil before is considered as an empty company, so that every set field of a
+// newly created company is reported.
+func diffCompanies(before, after *Company) []FieldChange {
+	if before == nil {
+		before = &Company{}
+	}
+	changes := []FieldChange{}
+	add := func(field string, old, new interface{}) {
+		if !reflect.DeepEqual(old, new) {
+			changes = append(changes, FieldChange{Field: field, Old: old, New: new})
+		}
+	}
+	add("name", before.Name, after.Name)
+	add("description", nullableString(before.Description), nullableString(after.Description))
+	add("employees", before.Employees, after.Employees)
+	add("registered", nullableBool(before.Registered), nullableBool(after.Registered))
+	add("type", before.Type, after.Type)
+	add("attributes", nullableAttributes(before.Attributes), nullableAttributes(after.Attributes))
+	add("deleted_at", nullableTime(before.DeletedAt), nullableTime(after.DeletedAt))
+	return changes
+}
+
+func nullableString(d CompanyDescription) interface{} {
+	if !d.Valid {
+		return nil
+	}
+	return d.String
+}
+
+func nullableAttributes(a CompanyAttributes) interface{} {
+	if len(a) == 0 {
+		return nil
+	}
+	return map[string]interface{}(a)
+}
+
+func nullableBool(b *bool) interface{} {
+	if b == nil {
+		return nil
+	}
+	return *b
+}
+
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// getCompanyForUpdate returns the company, including a deleted one, locking its row
+// until the end of the transaction.
+func getCompanyForUpdate(ctx context.Context, tx *sql.Tx, id uuid.UUID) (*Company, error) {
+	query := `SELECT "id", "name", "description", "employees", "registered", "type", "attributes", "version", "deleted_at" FROM company WHERE id = $1 FOR UPDATE`
+	company := &Company{}
+	err := tx.QueryRowContext(ctx, query, id).Scan(&company.ID, &company.Name, &company.Description, &company.Employees, &company.Registered, &company.Type, &company.Attributes,
+		&company.Version, &company.DeletedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return company, nil
+}
+
+// getCompanyLinks sets the parent and the tags of a company read by getCompanyForUpdate,
+// which leaves them out since they are not part of the revisions.
+func getCompanyLinks(ctx context.Context, tx *sql.Tx, company *Company) error {
+	query := `SELECT "parent_id", ` + companyTagsColumn + ` FROM company WHERE id = $1`
+	return tx.QueryRowContext(ctx, query, company.ID).Scan(&company.ParentID, pq.Array(&company.Tags))
+}
+
+// insertRevision records the state of the company after a change, along with the
+// fields it changed, as part of the transaction that made the change.
+func insertRevision(ctx context.Context, tx *sql.Tx, operation string, after *Company, fieldChanges []FieldChange, actor string) error {
+	snapshot, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	changes, err := json.Marshal(fieldChanges)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO company_revisions ("company_id", "revision", "operation", "snapshot", "changes", "actor") VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err = tx.ExecContext(ctx, query, after.ID, after.Version, operation, snapshot, changes, actor)
+	return err
+}
+
+// scanRevision scans a company_revisions row into a CompanyRevision.
+func scanRevision(scan func(dest ...interface{}) error, revision *CompanyRevision) error {
+	var snapshot, changes []byte
+	err := scan(&revision.CompanyID, &revision.Revision, &revision.Operation, &snapshot, &changes, &revision.Actor, &revision.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(snapshot, &revision.Snapshot); err != nil {
+		return err
+	}
+	return json.Unmarshal(changes, &revision.Changes)
+}
+
+// GetCompanyRevisions returns the page of revisions of a company, latest first,
+// along with the pagination metadata. The revisions of deleted companies remain
+// available. ErrRecordNotFound is returned if the company has no revision.
+func (m *CompanyModel) GetCompanyRevisions(ctx context.Context, id uuid.UUID, filters Filters) ([]*CompanyRevision, Metadata, error) {
+	query := `SELECT count(*) OVER(), "company_id", "revision", "operation", "snapshot", "changes", "actor", "created_at" FROM company_revisions
+		WHERE company_id = $1
+		ORDER BY revision DESC
+		LIMIT $2 OFFSET $3`
+	rows, err := m.DB.QueryContext(ctx, query, id, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	revisions := []*CompanyRevision{}
+	for rows.Next() {
+		revision := &CompanyRevision{}
+		err := scanRevision(func(dest ...interface{}) error {
+			return rows.Scan(append([]interface{}{&totalRecords}, dest...)...)
+		}, revision)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	if totalRecords == 0 && filters.Page == 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+	return revisions, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// GetCompanyRevision returns a single revision of a company.
+func (m *CompanyModel) GetCompanyRevision(ctx context.Context, id uuid.UUID, revision int) (*CompanyRevision, error) {
+	query := `SELECT "company_id", "revision", "operation", "snapshot", "changes", "actor", "created_at" FROM company_revisions
+		WHERE company_id = $1 AND revision = $2`
+	r := &CompanyRevision{}
+	err := scanRevision(m.DB.QueryRowContext(ctx, query, id, revision).Scan, r)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// RollbackCompany restores the fields of a company to their state at the given
+// revision. The rollback is recorded as a new revision, along with the
+// CompanyUpdated event in the outbox, and the updated company is returned.
+// The rollback is conditional on the version of the company, like UpdateCompany:
+// ErrEditConflict is returned if the company has been changed since it was read.
+// ErrRecordNotFound is returned if the company or the revision does not exist,
+// or if the company is deleted, and ErrDuplicateName if the name of the revision
+// is now used by another company.
+func (m *CompanyModel) RollbackCompany(ctx context.Context, id uuid.UUID, revision, version int, actor string) (*Company, error) {
+	target, err := m.GetCompanyRevision(ctx, id, revision)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	before, err := lockCompanyVersion(ctx, tx, id, version)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := target.Snapshot
+	query := `UPDATE company SET name = $1, description = $2, employees = $3, registered = $4, type = $5, attributes = $6, version = version + 1 WHERE id = $7`
+	_, err = tx.ExecContext(ctx, query, snapshot.Name, sql.NullString(snapshot.Description), snapshot.Employees, snapshot.Registered, snapshot.Type, snapshot.Attributes, id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateName
+		}
+		return nil, err
+	}
+	after, err := getCompanyForUpdate(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = recordChange(ctx, tx, OperationRolledBack, before, after, actor); err != nil {
+		return nil, err
+	}
+	if err = getCompanyLinks(ctx, tx, after); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
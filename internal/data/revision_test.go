@@ -0,0 +1,69 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffCompanies(t *testing.T) {
+	deletedAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	company := &Company{
+		Name:        "Company One",
+		Description: CompanyDescription{String: "Description", Valid: true},
+		Employees:   100,
+		Registered:  boolPtr(true),
+		Type:        "Corporations",
+	}
+	tests := []struct {
+		name   string
+		before *Company
+		after  *Company
+		want   []FieldChange
+	}{
+		{
+			name:   "Created",
+			before: nil,
+			after:  company,
+			want: []FieldChange{
+				{Field: "name", Old: "", New: "Company One"},
+				{Field: "description", Old: nil, New: "Description"},
+				{Field: "employees", Old: 0, New: 100},
+				{Field: "registered", Old: nil, New: true},
+				{Field: "type", Old: "", New: "Corporations"},
+			},
+		},
+		{
+			name:   "Updated",
+			before: company,
+			after:  &Company{Name: "Company One", Employees: 2, Registered: boolPtr(false), Type: "Corporations"},
+			want: []FieldChange{
+				{Field: "description", Old: "Description", New: nil},
+				{Field: "employees", Old: 100, New: 2},
+				{Field: "registered", Old: true, New: false},
+			},
+		},
+		{
+			name:   "Deleted",
+			before: company,
+			after: &Company{Name: "Company One", Description: company.Description, Employees: 100, Registered: boolPtr(true),
+				Type: "Corporations", DeletedAt: &deletedAt},
+			want: []FieldChange{
+				{Field: "deleted_at", Old: nil, New: "2023-01-01T00:00:00Z"},
+			},
+		},
+		{
+			name:   "Unchanged",
+			before: company,
+			after:  company,
+			want:   []FieldChange{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffCompanies(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"mborgnolo/companyservice/internal/validator"
+	"strings"
+	"unicode"
+)
+
+// CompanySearchSortSafelist contains the sort values accepted when searching companies.
+var CompanySearchSortSafelist = []string{"-rank", "rank", "name", "-name"}
+
+// CompanySearchResult is a company matching a full-text search, along with its
+// rank and the matching parts of its name and description highlighted with
+// <mark> tags.
+type CompanySearchResult struct {
+	Company            *Company `json:"company"`
+	Rank               float32  `json:"rank"`
+	NameSnippet        string   `json:"name_snippet"`
+	DescriptionSnippet string   `json:"description_snippet"`
+}
+
+// ValidateSearchQuery runs validation checks on a full-text search query.
+func ValidateSearchQuery(v *validator.Validator, q string) {
+	v.Check(q != "", "q", "is required")
+	v.Check(len(q) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(q == "" || toTSQuery(q) != "", "q", "must contain at least one word")
+}
+
+// toTSQuery converts a user search query into the Postgres tsquery syntax. Words
+// are combined with AND, text in double quotes is searched as a phrase and a
+// trailing * turns a word into a prefix match. Any other character is dropped,
+// so the result can always be parsed by to_tsquery.
+//
+//	cloud "data platform" analyt*  =>  cloud & (data <-> platform) & analyt:*
+func toTSQuery(q string) string {
+	var terms []string
+	for _, phrase := range searchTerms(q) {
+		if len(phrase) > 1 {
+			terms = append(terms, "("+strings.Join(phrase, " <-> ")+")")
+			continue
+		}
+		terms = append(terms, phrase[0])
+	}
+	return strings.Join(terms, " & ")
+}
+
+// searchTerms splits a user search query into the terms which must all match.
+// A term is a phrase of consecutive words, made of a single word outside of
+// double quotes. Words ending with :* match by prefix.
+func searchTerms(q string) [][]string {
+	var terms [][]string
+	for i, part := range strings.Split(q, `"`) {
+		words := tsWords(part)
+		if len(words) == 0 {
+			continue
+		}
+		// Odd parts are between double quotes.
+		if i%2 == 1 {
+			terms = append(terms, words)
+			continue
+		}
+		for _, word := range words {
+			terms = append(terms, []string{word})
+		}
+	}
+	return terms
+}
+
+// tsWords splits text into words made of letters and digits, keeping the prefix
+// marker of words ending with *.
+func tsWords(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(text) {
+		prefix := strings.HasSuffix(field, "*")
+		n := len(words)
+		for _, word := range strings.FieldsFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			words = append(words, strings.ToLower(word))
+		}
+		if prefix && len(words) > n {
+			words[len(words)-1] += ":*"
+		}
+	}
+	return words
+}
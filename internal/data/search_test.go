@@ -0,0 +1,29 @@
+package data
+
+import "testing"
+
+func TestToTSQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{"Single word", "Cloud", "cloud"},
+		{"Several words", "cloud storage", "cloud & storage"},
+		{"Phrase", `"data platform"`, "(data <-> platform)"},
+		{"Prefix", "analyt*", "analyt:*"},
+		{"Mixed", `cloud "data platform" analyt*`, "cloud & (data <-> platform) & analyt:*"},
+		{"Single word phrase", `"cloud"`, "cloud"},
+		{"Unterminated phrase", `cloud "data platform`, "cloud & (data <-> platform)"},
+		{"Operators are dropped", "cloud & !storage | (x)", "cloud & storage & x"},
+		{"Lone prefix marker", "cloud *", "cloud"},
+		{"No words", `"" * &`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toTSQuery(tt.q); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
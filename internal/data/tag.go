@@ -0,0 +1,232 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"mborgnolo/companyservice/internal/validator"
+	"regexp"
+	"sort"
+)
+
+// tagRX matches the tag names: lower case words of letters and digits joined by
+// single hyphens, such as "eu-pilot".
+var tagRX = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// tagMessage is the validation message of an invalid tag name.
+const tagMessage = "must only contain lower case letters, digits and single hyphens"
+
+// Tag is a label attached to companies, along with the number of companies, not
+// deleted, carrying it.
+type Tag struct {
+	Name      string `json:"name"`
+	Companies int    `json:"companies"`
+}
+
+// ValidateTag runs validation checks on a tag name.
+func ValidateTag(v *validator.Validator, tag string) {
+	v.Check(tag != "", "tag", "is required")
+	v.Check(len(tag) <= 50, "tag", "must not be more than 50 characters")
+	v.Check(tag == "" || tagRX.MatchString(tag), "tag", tagMessage)
+}
+
+// companyTagsColumn is the SQL expression selecting the tags of the company of the
+// row, sorted by name.
+const companyTagsColumn = `ARRAY(SELECT t.name FROM company_tags ct JOIN tags t ON t.id = ct.tag_id WHERE ct.company_id = company.id ORDER BY t.name)`
+
+// getCompanyTags returns the tags of a company, sorted by name, as part of a transaction.
+func getCompanyTags(ctx context.Context, tx *sql.Tx, companyID uuid.UUID) ([]string, error) {
+	tags := []string{}
+	err := tx.QueryRowContext(ctx, `SELECT `+companyTagsColumn+` FROM company WHERE id = $1`, companyID).Scan(pq.Array(&tags))
+	return tags, err
+}
+
+// companyTagFilter is the SQL condition matching the companies carrying the tag of
+// the given placeholder, or every company if it is empty.
+func companyTagFilter(placeholder string) string {
+	return `(` + placeholder + ` = '' OR EXISTS (SELECT 1 FROM company_tags ct JOIN tags t ON t.id = ct.tag_id
+		WHERE ct.company_id = company.id AND t.name = ` + placeholder + `))`
+}
+
+// AddCompanyTag attaches a tag to a company, creating the tag if it is new, and
+// increments its version, along with the CompanyUpdated event in the outbox. actor is
+// the user tagging the company. It reports whether the tag has been added, false
+// meaning that the company already carried it. ErrRecordNotFound is returned if the
+// company does not exist or is deleted.
+func (m *CompanyModel) AddCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	company, err := lockActiveCompany(ctx, tx, companyID)
+	if err != nil {
+		return false, err
+	}
+	old, err := getCompanyTags(ctx, tx, companyID)
+	if err != nil {
+		return false, err
+	}
+	// Tags are never deleted, so the tag cannot disappear before it is attached.
+	_, err = tx.ExecContext(ctx, `INSERT INTO tags ("name") VALUES ($1) ON CONFLICT ("name") DO NOTHING`, tag)
+	if err != nil {
+		return false, err
+	}
+	query := `INSERT INTO company_tags ("company_id", "tag_id") SELECT $1, id FROM tags WHERE name = $2 ON CONFLICT DO NOTHING`
+	result, err := tx.ExecContext(ctx, query, companyID, tag)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, tx.Commit()
+	}
+	tags, err := getCompanyTags(ctx, tx, companyID)
+	if err != nil {
+		return false, err
+	}
+	if err = recordNewVersion(ctx, tx, company, FieldChange{Field: "tags", Old: old, New: tags}, actor); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RemoveCompanyTag detaches a tag from a company and increments its version, along
+// with the CompanyUpdated event in the outbox. actor is the user untagging the
+// company. ErrRecordNotFound is returned if the company does not exist, is deleted or
+// does not carry the tag.
+func (m *CompanyModel) RemoveCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	company, err := lockActiveCompany(ctx, tx, companyID)
+	if err != nil {
+		return err
+	}
+	old, err := getCompanyTags(ctx, tx, companyID)
+	if err != nil {
+		return err
+	}
+	query := `DELETE FROM company_tags ct USING tags t WHERE t.id = ct.tag_id AND ct.company_id = $1 AND t.name = $2`
+	result, err := tx.ExecContext(ctx, query, companyID, tag)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	tags, err := getCompanyTags(ctx, tx, companyID)
+	if err != nil {
+		return err
+	}
+	if err = recordNewVersion(ctx, tx, company, FieldChange{Field: "tags", Old: old, New: tags}, actor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTags returns the tags carried by at least one company which is not deleted,
+// sorted by name, along with the number of such companies.
+func (m *CompanyModel) GetTags(ctx context.Context) ([]*Tag, error) {
+	query := `SELECT t.name, count(*) FROM tags t
+		JOIN company_tags ct ON ct.tag_id = t.id
+		JOIN company c ON c.id = ct.company_id
+		WHERE c.deleted_at IS NULL
+		GROUP BY t.name
+		ORDER BY t.name`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := []*Tag{}
+	for rows.Next() {
+		tag := &Tag{}
+		if err := rows.Scan(&tag.Name, &tag.Companies); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// hasTag reports whether the sorted tags contain tag.
+func hasTag(tags []string, tag string) bool {
+	i := sort.SearchStrings(tags, tag)
+	return i < len(tags) && tags[i] == tag
+}
+
+// AddCompanyTag attaches a tag to a company and increments its version, and reports
+// whether the tag has been added.
+func (m *MemoryModel) AddCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(companyID) {
+		return false, ErrRecordNotFound
+	}
+	company := m.companies[companyID]
+	if hasTag(company.Tags, tag) {
+		return false, nil
+	}
+	after := copyCompany(company)
+	after.Tags = append(after.Tags, tag)
+	sort.Strings(after.Tags)
+	if err := m.recordNewVersion(company, after, FieldChange{Field: "tags", Old: append([]string{}, company.Tags...), New: append([]string{}, after.Tags...)}, actor); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveCompanyTag detaches a tag from a company and increments its version.
+func (m *MemoryModel) RemoveCompanyTag(ctx context.Context, companyID uuid.UUID, tag, actor string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.activeCompany(companyID) {
+		return ErrRecordNotFound
+	}
+	company := m.companies[companyID]
+	i := sort.SearchStrings(company.Tags, tag)
+	if i == len(company.Tags) || company.Tags[i] != tag {
+		return ErrRecordNotFound
+	}
+	after := copyCompany(company)
+	after.Tags = append(after.Tags[:i:i], after.Tags[i+1:]...)
+	return m.recordNewVersion(company, after, FieldChange{Field: "tags", Old: append([]string{}, company.Tags...), New: append([]string{}, after.Tags...)}, actor)
+}
+
+// GetTags returns the tags carried by at least one company which is not deleted,
+// sorted by name, along with the number of such companies.
+func (m *MemoryModel) GetTags(ctx context.Context) ([]*Tag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := map[string]int{}
+	for _, company := range m.companies {
+		if company.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range company.Tags {
+			counts[tag]++
+		}
+	}
+	tags := []*Tag{}
+	for name, count := range counts {
+		tags = append(tags, &Tag{Name: name, Companies: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
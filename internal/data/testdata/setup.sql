@@ -1,12 +1,118 @@
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+CREATE EXTENSION IF NOT EXISTS btree_gist;
 
 CREATE TABLE IF NOT EXISTS company (
 id uuid DEFAULT uuid_generate_v4(),
-    name varchar(15) NOT NULL unique,
+    name varchar(15) NOT NULL,
 description varchar(3000) NULL,
 employees integer NOT NULL,
 registered boolean NOT NULL,
-type text NOT NULL
+type text NOT NULL,
+search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED,
+deleted_at timestamp with time zone NULL,
+version integer NOT NULL DEFAULT 1,
+parent_id uuid NULL CHECK (parent_id <> id),
+attributes jsonb NOT NULL DEFAULT '{}' CHECK (jsonb_typeof(attributes) = 'object')
 );
 
-INSERT INTO company (id,name, description, employees, registered, type) VALUES ('f1203d76-0491-47fe-9640-0aeda76ad3f6','Company One', 'Description for company one', 100, true, 'Corporations');
\ No newline at end of file
+CREATE UNIQUE INDEX IF NOT EXISTS company_name_active_idx ON company (name) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS company_outbox (
+id bigserial PRIMARY KEY,
+company_id uuid NOT NULL,
+event_type text NOT NULL,
+payload jsonb NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+attempts integer NOT NULL DEFAULT 0,
+next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+last_error text NULL,
+sent_at timestamp with time zone NULL,
+dead_at timestamp with time zone NULL
+);
+
+CREATE TABLE IF NOT EXISTS company_revisions (
+id bigserial PRIMARY KEY,
+company_id uuid NOT NULL,
+revision integer NOT NULL,
+operation text NOT NULL,
+snapshot jsonb NOT NULL,
+changes jsonb NOT NULL DEFAULT '[]',
+actor text NOT NULL DEFAULT '',
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (company_id, revision)
+);
+
+CREATE TABLE IF NOT EXISTS company_history (
+id bigserial PRIMARY KEY,
+company_id uuid NOT NULL,
+name varchar(15) NOT NULL,
+description varchar(3000) NULL,
+employees integer NOT NULL,
+registered boolean NOT NULL,
+type text NOT NULL,
+version integer NOT NULL,
+attributes jsonb NOT NULL DEFAULT '{}',
+valid_from timestamp with time zone NOT NULL,
+valid_to timestamp with time zone NULL CHECK (valid_to IS NULL OR valid_to >= valid_from),
+EXCLUDE USING gist (company_id WITH =, tstzrange(valid_from, valid_to) WITH &&)
+);
+
+CREATE TABLE IF NOT EXISTS company_addresses (
+id uuid PRIMARY KEY,
+company_id uuid NOT NULL,
+type text NOT NULL,
+lines text[] NOT NULL,
+city varchar(100) NOT NULL,
+postal_code varchar(20) NOT NULL DEFAULT '',
+country char(2) NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS company_addresses_registered_idx ON company_addresses (company_id) WHERE type = 'registered';
+
+CREATE TABLE IF NOT EXISTS tags (
+id bigserial PRIMARY KEY,
+name varchar(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS company_tags (
+company_id uuid NOT NULL,
+tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (company_id, tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+id uuid PRIMARY KEY,
+url text NOT NULL,
+event_types text[] NOT NULL DEFAULT '{}',
+secret text NOT NULL,
+enabled boolean NOT NULL DEFAULT true,
+consecutive_failures integer NOT NULL DEFAULT 0,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+id bigserial PRIMARY KEY,
+webhook_id uuid NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+event_id bigint NOT NULL,
+event_type text NOT NULL,
+company_id uuid NOT NULL,
+payload jsonb NOT NULL,
+attempts integer NOT NULL DEFAULT 0,
+next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+response_status integer NULL,
+last_error text NULL,
+delivered_at timestamp with time zone NULL,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (webhook_id, event_id)
+);
+
+INSERT INTO company (id,name, description, employees, registered, type) VALUES ('f1203d76-0491-47fe-9640-0aeda76ad3f6','Company One', 'Description for company one', 100, true, 'Corporations');
+INSERT INTO company_revisions (company_id, revision, operation, snapshot) VALUES ('f1203d76-0491-47fe-9640-0aeda76ad3f6', 1, 'created', '{"id":"f1203d76-0491-47fe-9640-0aeda76ad3f6","name":"Company One","description":"Description for company one","employees":100,"registered":true,"type":"Corporations","version":1}');
+INSERT INTO company_history (company_id, name, description, employees, registered, type, version, valid_from) VALUES ('f1203d76-0491-47fe-9640-0aeda76ad3f6', 'Company One', 'Description for company one', 100, true, 'Corporations', 1, '2023-01-01T00:00:00Z');
\ No newline at end of file
@@ -1 +1,9 @@
-DROP TABLE company;
\ No newline at end of file
+DROP TABLE company;
+DROP TABLE company_outbox;
+DROP TABLE company_revisions;
+DROP TABLE company_history;
+DROP TABLE company_addresses;
+DROP TABLE company_tags;
+DROP TABLE tags;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
@@ -0,0 +1,611 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"mborgnolo/companyservice/internal/validator"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+// Webhook is a subscription of a partner system to the company events, delivered by
+// POST requests to its URL and signed with its secret. An empty EventTypes subscribes
+// to every event type. A webhook is disabled after too many consecutive failed
+// deliveries, and its pending deliveries wait until it is enabled again.
+type Webhook struct {
+	ID                  uuid.UUID `json:"id"`
+	URL                 string    `json:"url"`
+	EventTypes          []string  `json:"event_types"`
+	Secret              string    `json:"-"`
+	Enabled             bool      `json:"enabled"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// Delivery statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+)
+
+// WebhookDelivery is the delivery of an event to a webhook, along with the outcome of
+// its last attempt. ResponseStatus is nil if no response was received.
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	WebhookID      uuid.UUID  `json:"webhook_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	CompanyID      uuid.UUID  `json:"company_id"`
+	Payload        []byte     `json:"-"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	ResponseStatus *int       `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// PendingWebhookDelivery is a delivery due to be attempted, along with the URL and
+// the secret of its webhook.
+type PendingWebhookDelivery struct {
+	*WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// ValidateWebhook runs validation checks on the Webhook type.
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	v.Check(webhook.URL != "", "url", "is required")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+	u, err := url.Parse(webhook.URL)
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	for _, eventType := range webhook.EventTypes {
+		v.Check(v.In(eventType, EventTypeNames...), "event_types", "must only contain: CompanyCreated, CompanyUpdated, CompanyDeleted, CompanyRestored")
+	}
+	v.Check(v.Unique(webhook.EventTypes), "event_types", "must not contain duplicate values")
+	v.Check(utf8.RuneCountInString(webhook.Secret) >= 16, "secret", "must be at least 16 characters long")
+	v.Check(utf8.RuneCountInString(webhook.Secret) <= 256, "secret", "must not be more than 256 characters long")
+}
+
+// subscribes reports whether the webhook is subscribed to the event type.
+func (w *Webhook) subscribes(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryStatus returns the status of a delivery.
+func deliveryStatus(deliveredAt *time.Time) string {
+	if deliveredAt != nil {
+		return DeliveryDelivered
+	}
+	return DeliveryPending
+}
+
+// WebhookDeliveriesSafelist sorts the deliveries of a webhook from the most recent.
+var WebhookDeliveriesSafelist = []string{"-id"}
+
+// WebhookModel wraps the sql.DB connection pool.
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+const webhookColumns = `"id", "url", "event_types", "secret", "enabled", "consecutive_failures", "created_at", "updated_at"`
+
+// scanWebhook scans the columns of a webhook selected by webhookColumns.
+func scanWebhook(scan func(dest ...interface{}) error, webhook *Webhook) error {
+	return scan(&webhook.ID, &webhook.URL, pq.Array(&webhook.EventTypes), &webhook.Secret, &webhook.Enabled, &webhook.ConsecutiveFailures,
+		&webhook.CreatedAt, &webhook.UpdatedAt)
+}
+
+// CreateWebhook inserts a new enabled webhook, and sets the id, the state and the
+// timestamps of the provided webhook.
+func (m *WebhookModel) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	id := uuid.New()
+	query := `INSERT INTO webhooks ("id", "url", "event_types", "secret") VALUES ($1, $2, $3, $4)
+		RETURNING "enabled", "consecutive_failures", "created_at", "updated_at"`
+	err := m.DB.QueryRowContext(ctx, query, id, webhook.URL, pq.Array(webhook.EventTypes), webhook.Secret).
+		Scan(&webhook.Enabled, &webhook.ConsecutiveFailures, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	webhook.ID = id
+	return nil
+}
+
+// GetWebhooks returns the webhooks, in the order they were created.
+func (m *WebhookModel) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := m.DB.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook := &Webhook{}
+		if err := scanWebhook(rows.Scan, webhook); err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// GetWebhook returns a webhook. ErrRecordNotFound is returned if it does not exist.
+func (m *WebhookModel) GetWebhook(ctx context.Context, id uuid.UUID) (*Webhook, error) {
+	webhook := &Webhook{}
+	err := scanWebhook(m.DB.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id).Scan, webhook)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// UpdateWebhook replaces the URL, the event types, the secret and the enabled state of
+// a webhook with the ones of the provided webhook, and sets its consecutive failures,
+// reset when it is enabled again, and its update time. ErrRecordNotFound is returned
+// if it does not exist.
+func (m *WebhookModel) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	query := `UPDATE webhooks SET "url" = $2, "event_types" = $3, "secret" = $4, "enabled" = $5,
+		"consecutive_failures" = CASE WHEN $5 AND NOT enabled THEN 0 ELSE consecutive_failures END, "updated_at" = NOW()
+		WHERE id = $1 RETURNING "consecutive_failures", "updated_at"`
+	err := m.DB.QueryRowContext(ctx, query, webhook.ID, webhook.URL, pq.Array(webhook.EventTypes), webhook.Secret, webhook.Enabled).
+		Scan(&webhook.ConsecutiveFailures, &webhook.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook along with its deliveries. ErrRecordNotFound is
+// returned if it does not exist.
+func (m *WebhookModel) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// EnqueueWebhookDeliveries schedules the delivery of an event to every enabled webhook
+// subscribed to its type. An event enqueued again is not delivered twice.
+func (m *WebhookModel) EnqueueWebhookDeliveries(ctx context.Context, eventID int64, eventType string, companyID uuid.UUID, payload []byte) error {
+	query := `INSERT INTO webhook_deliveries ("webhook_id", "event_id", "event_type", "company_id", "payload")
+		SELECT id, $1::bigint, $2::text, $3::uuid, $4::jsonb FROM webhooks
+		WHERE enabled AND (event_types = '{}' OR $2 = ANY(event_types))
+		ON CONFLICT ("webhook_id", "event_id") DO NOTHING`
+	_, err := m.DB.ExecContext(ctx, query, eventID, eventType, companyID, string(payload))
+	return err
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries of enabled webhooks which are
+// due, from the longest overdue, and leases them: their next attempt is postponed by
+// lease, so that the other instances do not attempt them at the same time. The
+// deliveries locked by another instance claiming them are skipped. A delivery whose
+// attempt is not recorded, because the instance stopped, is due again once the lease
+// has expired.
+func (m *WebhookModel) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*PendingWebhookDelivery, error) {
+	query := `WITH due AS (
+			SELECT d.id, d.next_attempt_at FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.delivered_at IS NULL AND d.next_attempt_at <= NOW() AND w.enabled
+			ORDER BY d.next_attempt_at, d.id LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		), claimed AS (
+			UPDATE webhook_deliveries d SET next_attempt_at = NOW() + make_interval(secs => $2)
+			FROM due WHERE d.id = due.id
+			RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.company_id, d.payload, d.attempts, due.next_attempt_at, d.created_at
+		)
+		SELECT c."id", c."webhook_id", c."event_id", c."event_type", c."company_id", c."payload", c."attempts", c."next_attempt_at",
+			c."created_at", w."url", w."secret"
+		FROM claimed c JOIN webhooks w ON w.id = c.webhook_id
+		ORDER BY c.next_attempt_at, c.id`
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []*PendingWebhookDelivery{}
+	for rows.Next() {
+		d := &PendingWebhookDelivery{WebhookDelivery: &WebhookDelivery{Status: DeliveryPending}}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.CompanyID, &d.Payload, &d.Attempts, &d.NextAttemptAt,
+			&d.CreatedAt, &d.URL, &d.Secret)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// MarkWebhookDelivered records the successful delivery attempt of a delivery and the
+// status of the response, and resets the consecutive failures of its webhook.
+func (m *WebhookModel) MarkWebhookDelivered(ctx context.Context, id int64, status int) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	query := `UPDATE webhook_deliveries SET attempts = attempts + 1, response_status = $2, last_error = NULL, delivered_at = NOW()
+		WHERE id = $1 RETURNING webhook_id`
+	var webhookID uuid.UUID
+	if err = tx.QueryRowContext(ctx, query, id, status).Scan(&webhookID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1`, webhookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkWebhookDeliveryFailed records a failed delivery attempt of a delivery, along with
+// the status of the response, 0 if none was received, and its cause, and schedules the
+// next attempt. The consecutive failures of its webhook are counted, and the webhook is
+// disabled when they reach maxFailures. It reports whether the webhook was disabled.
+func (m *WebhookModel) MarkWebhookDeliveryFailed(ctx context.Context, id int64, status int, cause error, nextAttempt time.Time, maxFailures int) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	query := `UPDATE webhook_deliveries SET attempts = attempts + 1, response_status = NULLIF($2, 0), last_error = $3, next_attempt_at = $4
+		WHERE id = $1 RETURNING webhook_id`
+	var webhookID uuid.UUID
+	if err = tx.QueryRowContext(ctx, query, id, status, cause.Error(), nextAttempt).Scan(&webhookID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrRecordNotFound
+		}
+		return false, err
+	}
+	query = `UPDATE webhooks w SET consecutive_failures = w.consecutive_failures + 1, enabled = w.enabled AND w.consecutive_failures + 1 < $2
+		FROM webhooks old WHERE w.id = old.id AND w.id = $1 RETURNING old.enabled AND NOT w.enabled`
+	var disabled bool
+	if err = tx.QueryRowContext(ctx, query, webhookID, maxFailures).Scan(&disabled); err != nil {
+		return false, err
+	}
+	return disabled, tx.Commit()
+}
+
+// DeleteWebhookDeliveries permanently removes the deliveries delivered before the given
+// time, and returns the number of deliveries removed. The pending deliveries are kept.
+func (m *WebhookModel) DeleteWebhookDeliveries(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE delivered_at < $1`, deliveredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetWebhookDeliveries returns the page of deliveries of a webhook, from the most
+// recent, along with the pagination metadata. ErrRecordNotFound is returned if the
+// webhook does not exist.
+func (m *WebhookModel) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	if _, err := m.GetWebhook(ctx, webhookID); err != nil {
+		return nil, Metadata{}, err
+	}
+	query := fmt.Sprintf(`SELECT count(*) OVER(), "id", "webhook_id", "event_id", "event_type", "company_id", "attempts", "response_status",
+		coalesce("last_error", ''), "next_attempt_at", "delivered_at", "created_at"
+		FROM webhook_deliveries WHERE webhook_id = $1
+		ORDER BY %s %s LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		d := &WebhookDelivery{}
+		err := rows.Scan(&totalRecords, &d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.CompanyID, &d.Attempts, &d.ResponseStatus,
+			&d.LastError, &d.NextAttemptAt, &d.DeliveredAt, &d.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		d.Status = deliveryStatus(d.DeliveredAt)
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	return deliveries, CalculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// copyWebhook returns a deep copy of the webhook.
+func copyWebhook(w *Webhook) *Webhook {
+	webhook := *w
+	webhook.EventTypes = append([]string{}, w.EventTypes...)
+	return &webhook
+}
+
+// copyDelivery returns a copy of the delivery, whose payload is shared since it never
+// changes.
+func copyDelivery(d *WebhookDelivery) *WebhookDelivery {
+	delivery := *d
+	if d.ResponseStatus != nil {
+		status := *d.ResponseStatus
+		delivery.ResponseStatus = &status
+	}
+	if d.DeliveredAt != nil {
+		deliveredAt := *d.DeliveredAt
+		delivery.DeliveredAt = &deliveredAt
+	}
+	return &delivery
+}
+
+// CreateWebhook stores a new enabled webhook, and sets the id, the state and the
+// timestamps of the provided webhook.
+func (m *MemoryModel) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	now := time.Now().UTC()
+	webhook.ID = uuid.New()
+	webhook.Enabled, webhook.ConsecutiveFailures = true, 0
+	webhook.CreatedAt, webhook.UpdatedAt = now, now
+	m.webhooks[webhook.ID] = copyWebhook(webhook)
+	return nil
+}
+
+// GetWebhooks returns the webhooks, in the order they were created.
+func (m *MemoryModel) GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhooks := []*Webhook{}
+	for _, webhook := range m.webhooks {
+		webhooks = append(webhooks, copyWebhook(webhook))
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if !webhooks[i].CreatedAt.Equal(webhooks[j].CreatedAt) {
+			return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+		}
+		return webhooks[i].ID.String() < webhooks[j].ID.String()
+	})
+	return webhooks, nil
+}
+
+// GetWebhook returns a webhook.
+func (m *MemoryModel) GetWebhook(ctx context.Context, id uuid.UUID) (*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	return copyWebhook(webhook), nil
+}
+
+// UpdateWebhook replaces the URL, the event types, the secret and the enabled state of
+// a webhook with the ones of the provided webhook.
+func (m *MemoryModel) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.webhooks[webhook.ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	if webhook.EventTypes == nil {
+		webhook.EventTypes = []string{}
+	}
+	webhook.ConsecutiveFailures = stored.ConsecutiveFailures
+	if webhook.Enabled && !stored.Enabled {
+		webhook.ConsecutiveFailures = 0
+	}
+	webhook.CreatedAt, webhook.UpdatedAt = stored.CreatedAt, time.Now().UTC()
+	m.webhooks[webhook.ID] = copyWebhook(webhook)
+	return nil
+}
+
+// DeleteWebhook removes a webhook along with its deliveries.
+func (m *MemoryModel) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrRecordNotFound
+	}
+	delete(m.webhooks, id)
+	deliveries := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.deliveries = deliveries
+	return nil
+}
+
+// EnqueueWebhookDeliveries schedules the delivery of an event to every enabled webhook
+// subscribed to its type.
+func (m *MemoryModel) EnqueueWebhookDeliveries(ctx context.Context, eventID int64, eventType string, companyID uuid.UUID, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	enqueued := make(map[uuid.UUID]bool)
+	for _, delivery := range m.deliveries {
+		if delivery.EventID == eventID {
+			enqueued[delivery.WebhookID] = true
+		}
+	}
+	webhooks := []*Webhook{}
+	for _, webhook := range m.webhooks {
+		if webhook.Enabled && webhook.subscribes(eventType) && !enqueued[webhook.ID] {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	now := time.Now().UTC()
+	for _, webhook := range webhooks {
+		m.deliveries = append(m.deliveries, &WebhookDelivery{
+			ID:            m.nextDeliveryID,
+			WebhookID:     webhook.ID,
+			EventID:       eventID,
+			EventType:     eventType,
+			CompanyID:     companyID,
+			Payload:       append([]byte{}, payload...),
+			Status:        DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+		m.nextDeliveryID++
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries of enabled webhooks which are
+// due, from the longest overdue, and postpones their next attempt by lease, like the
+// SQL model.
+func (m *MemoryModel) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*PendingWebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var due []*WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if delivery.DeliveredAt == nil && !delivery.NextAttemptAt.After(now) && m.webhooks[delivery.WebhookID].Enabled {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	deliveries := []*PendingWebhookDelivery{}
+	for _, delivery := range due {
+		webhook := m.webhooks[delivery.WebhookID]
+		deliveries = append(deliveries, &PendingWebhookDelivery{WebhookDelivery: copyDelivery(delivery), URL: webhook.URL, Secret: webhook.Secret})
+		delivery.NextAttemptAt = now.Add(lease)
+	}
+	return deliveries, nil
+}
+
+// delivery returns the delivery of the given id. The caller must hold the lock.
+func (m *MemoryModel) delivery(id int64) (*WebhookDelivery, error) {
+	for _, delivery := range m.deliveries {
+		if delivery.ID == id {
+			return delivery, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// MarkWebhookDelivered records the successful delivery attempt of a delivery, and
+// resets the consecutive failures of its webhook.
+func (m *MemoryModel) MarkWebhookDelivered(ctx context.Context, id int64, status int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery, err := m.delivery(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.ResponseStatus = &status
+	delivery.LastError = ""
+	delivery.DeliveredAt = &now
+	delivery.Status = DeliveryDelivered
+	m.webhooks[delivery.WebhookID].ConsecutiveFailures = 0
+	return nil
+}
+
+// MarkWebhookDeliveryFailed records a failed delivery attempt of a delivery, and
+// disables its webhook when its consecutive failures reach maxFailures.
+func (m *MemoryModel) MarkWebhookDeliveryFailed(ctx context.Context, id int64, status int, cause error, nextAttempt time.Time, maxFailures int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery, err := m.delivery(id)
+	if err != nil {
+		return false, err
+	}
+	delivery.Attempts++
+	delivery.ResponseStatus = nil
+	if status != 0 {
+		delivery.ResponseStatus = &status
+	}
+	delivery.LastError = cause.Error()
+	delivery.NextAttemptAt = nextAttempt
+	webhook := m.webhooks[delivery.WebhookID]
+	webhook.ConsecutiveFailures++
+	if webhook.Enabled && webhook.ConsecutiveFailures >= maxFailures {
+		webhook.Enabled = false
+		return true, nil
+	}
+	return false, nil
+}
+
+// DeleteWebhookDeliveries removes the deliveries delivered before the given time.
+func (m *MemoryModel) DeleteWebhookDeliveries(ctx context.Context, deliveredBefore time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	deliveries := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.DeliveredAt != nil && delivery.DeliveredAt.Before(deliveredBefore) {
+			n++
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	m.deliveries = deliveries
+	return n, nil
+}
+
+// GetWebhookDeliveries returns the page of deliveries of a webhook, from the most
+// recent.
+func (m *MemoryModel) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.webhooks[webhookID]; !ok {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+	matching := []*WebhookDelivery{}
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			matching = append(matching, m.deliveries[i])
+		}
+	}
+	start, end := pageBounds(len(matching), filters)
+	deliveries := []*WebhookDelivery{}
+	for _, delivery := range matching[start:end] {
+		deliveries = append(deliveries, copyDelivery(delivery))
+	}
+	return deliveries, CalculateMetadata(len(matching), filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,113 @@
+//go:build integration
+// +build integration
+
+package data
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+	"testing"
+	"time"
+)
+
+func TestWebhookModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("postgres: skipping integration test")
+	}
+	db, teardown := newTestDB(t)
+	defer teardown()
+	w := WebhookModel{db}
+	ctx := context.Background()
+
+	created := &Webhook{URL: "https://partner.example.com/created", EventTypes: []string{"CompanyCreated"}, Secret: "0123456789abcdef"}
+	all := &Webhook{URL: "https://partner.example.com/all", Secret: "0123456789abcdef"}
+	for _, webhook := range []*Webhook{created, all} {
+		if err := w.CreateWebhook(ctx, webhook); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !all.Enabled || all.EventTypes == nil {
+		t.Errorf("want an enabled webhook subscribed to every event; got %+v", all)
+	}
+	companyID := uuid.New()
+	for _, event := range []struct {
+		id        int64
+		eventType string
+	}{{1, "CompanyCreated"}, {1, "CompanyCreated"}, {2, "CompanyDeleted"}} {
+		if err := w.EnqueueWebhookDeliveries(ctx, event.id, event.eventType, companyID, []byte(`{"ID":1}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := w.ClaimWebhookDeliveries(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 3 || pending[0].Secret != created.Secret || string(pending[0].Payload) != `{"ID": 1}` {
+		t.Fatalf("want 3 pending deliveries, each event once per subscribed webhook; got %d", len(pending))
+	}
+
+	for _, delivery := range pending {
+		if delivery.WebhookID != all.ID {
+			continue
+		}
+		disabled, err := w.MarkWebhookDeliveryFailed(ctx, delivery.ID, 500, errors.New("unexpected response status 500"), time.Now(), 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := delivery.EventID == 2; disabled != want {
+			t.Errorf("delivery %d: want disabled %t; got %t", delivery.ID, want, disabled)
+		}
+	}
+	if pending, _ = w.ClaimWebhookDeliveries(ctx, 10, 0); len(pending) != 1 || pending[0].WebhookID != created.ID {
+		t.Errorf("want only the deliveries of the enabled webhook pending; got %d", len(pending))
+	}
+	if err := w.MarkWebhookDelivered(ctx, pending[0].ID, 200); err != nil {
+		t.Fatal(err)
+	}
+	deliveries, metadata, err := w.GetWebhookDeliveries(ctx, all.ID, Filters{Page: 1, PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].EventID != 2 || deliveries[0].ResponseStatus == nil || *deliveries[0].ResponseStatus != 500 ||
+		metadata.TotalRecords != 2 {
+		t.Errorf("want the most recent failed delivery of 2; got %+v, %+v", deliveries, metadata)
+	}
+
+	all.Enabled = true
+	if err := w.UpdateWebhook(ctx, all); err != nil {
+		t.Fatal(err)
+	}
+	if all.ConsecutiveFailures != 0 {
+		t.Errorf("want the failures reset when enabled again; got %d", all.ConsecutiveFailures)
+	}
+	if err := w.DeleteWebhook(ctx, all.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := w.GetWebhookDeliveries(ctx, all.ID, Filters{Page: 1, PageSize: 1}); err != ErrRecordNotFound {
+		t.Errorf("want %v; got %v", ErrRecordNotFound, err)
+	}
+
+	// A claimed delivery is not claimed again until its lease expires.
+	if err := w.EnqueueWebhookDeliveries(ctx, 3, "CompanyCreated", companyID, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if pending, _ = w.ClaimWebhookDeliveries(ctx, 10, time.Minute); len(pending) != 1 || pending[0].EventID != 3 {
+		t.Errorf("want the delivery of event 3 claimed; got %+v", pending)
+	}
+	if pending, _ = w.ClaimWebhookDeliveries(ctx, 10, time.Minute); len(pending) != 0 {
+		t.Errorf("want no delivery claimed while leased; got %+v", pending)
+	}
+
+	// The delivered deliveries are pruned once they are old enough, unlike the pending ones.
+	if n, err := w.DeleteWebhookDeliveries(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("want no recent delivery pruned; got %d, %v", n, err)
+	}
+	if n, err := w.DeleteWebhookDeliveries(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("want the delivered delivery pruned; got %d, %v", n, err)
+	}
+	if deliveries, _, _ = w.GetWebhookDeliveries(ctx, created.ID, Filters{Page: 1, PageSize: 10}); len(deliveries) != 1 || deliveries[0].EventID != 3 {
+		t.Errorf("want the pending delivery of event 3 kept; got %+v", deliveries)
+	}
+}
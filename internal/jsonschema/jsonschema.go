@@ -0,0 +1,87 @@
+// Package jsonschema validates JSON documents against a JSON Schema, reporting the
+// errors with a validator.Validator. The schemas are compiled by
+// github.com/santhosh-tekuri/jsonschema, which implements the drafts 4 to 2020-12,
+// draft 2020-12 being assumed when the schema does not declare its $schema.
+package jsonschema
+
+import (
+	"bytes"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"mborgnolo/companyservice/internal/validator"
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	schema *jsonschema.Schema
+}
+
+// newCompiler returns a compiler of the schemas. The format keyword is an assertion,
+// rather than the mere annotation of draft 2020-12, so that a schema asking for an
+// email or a date is not silently satisfied by any string. References may only be
+// resolved to the local files, as the only loader registered is the file one.
+func newCompiler() *jsonschema.Compiler {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	c.AssertFormat = true
+	return c
+}
+
+// Load reads the schema of the given JSON file. Its references to other files are
+// resolved relative to it.
+func Load(path string) (*Schema, error) {
+	s, err := newCompiler().Compile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{s}, nil
+}
+
+// Parse parses a JSON Schema document.
+func Parse(b []byte) (*Schema, error) {
+	const url = "schema.json"
+	c := newCompiler()
+	if err := c.AddResource(url, bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	s, err := c.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{s}, nil
+}
+
+// Validate runs the validation checks of the schema on a decoded JSON value. The
+// errors are added to the validator under the JSON pointer of the invalid value,
+// pointer being the one of the value itself, and only the first error of a value is
+// kept.
+func (s *Schema) Validate(v *validator.Validator, pointer string, value interface{}) {
+	err := s.schema.Validate(value)
+	if err == nil {
+		return
+	}
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		addError(v, pointer, err.Error())
+		return
+	}
+	addErrors(v, pointer, verr)
+}
+
+// addErrors adds the errors at the leaves of the tree of a validation error, the
+// inner nodes only telling which subschema they were found by.
+func addErrors(v *validator.Validator, pointer string, err *jsonschema.ValidationError) {
+	if len(err.Causes) == 0 {
+		addError(v, pointer+err.InstanceLocation, err.Message)
+		return
+	}
+	for _, cause := range err.Causes {
+		addErrors(v, pointer, cause)
+	}
+}
+
+// addError adds an error to the validator unless the value already has one.
+func addError(v *validator.Validator, pointer, message string) {
+	if _, exists := v.Errors[pointer]; !exists {
+		v.AddError(pointer, message)
+	}
+}
@@ -0,0 +1,146 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"mborgnolo/companyservice/internal/validator"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"properties": {
+		"crm_id": {"type": "string", "pattern": "^CRM-[0-9]+$"},
+		"segment": {"enum": ["smb", "enterprise"]},
+		"score": {"type": "integer", "minimum": 0, "exclusiveMaximum": 100},
+		"regions": {"type": "array", "items": {"type": "string", "maxLength": 2}, "maxItems": 3},
+		"contact": {
+			"type": "object",
+			"properties": {"email/work": {"type": "string", "minLength": 3}},
+			"required": ["email/work"],
+			"additionalProperties": false
+		},
+		"website": {"type": "string", "format": "uri"},
+		"owner": {"$ref": "#/$defs/owner"},
+		"rating": {"oneOf": [{"type": "string", "pattern": "^[A-C]$"}, {"type": "integer", "minimum": 1}]}
+	},
+	"required": ["crm_id"],
+	"additionalProperties": false,
+	"$defs": {
+		"owner": {"type": "object", "patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		document string
+		want     map[string]string
+	}{
+		{"Valid", `{"crm_id": "CRM-1", "segment": "smb", "score": 99.0, "regions": ["NL"], "contact": {"email/work": "a@b"}, "website": "https://example.com", "owner": {"x-team": "sales"}, "rating": "B"}`, map[string]string{}},
+		{"Missing required", `{"segment": "smb"}`, map[string]string{"/attributes": "missing properties: 'crm_id'"}},
+		{"Wrong type", `{"crm_id": 1}`, map[string]string{"/attributes/crm_id": "expected string, but got number"}},
+		{"Pattern", `{"crm_id": "1"}`, map[string]string{"/attributes/crm_id": "does not match pattern '^CRM-[0-9]+$'"}},
+		{"Enum", `{"crm_id": "CRM-1", "segment": "big"}`, map[string]string{"/attributes/segment": `value must be one of "smb", "enterprise"`}},
+		{"Not an integer", `{"crm_id": "CRM-1", "score": 1.5}`, map[string]string{"/attributes/score": "expected integer, but got number"}},
+		{"Minimum", `{"crm_id": "CRM-1", "score": -1}`, map[string]string{"/attributes/score": "must be >= 0 but found -1"}},
+		{"Exclusive maximum", `{"crm_id": "CRM-1", "score": 100}`, map[string]string{"/attributes/score": "must be < 100 but found 100"}},
+		{"Items", `{"crm_id": "CRM-1", "regions": ["NL", "EUR"]}`, map[string]string{"/attributes/regions/1": "length must be <= 2, but got 3"}},
+		{"Max items", `{"crm_id": "CRM-1", "regions": ["a", "b", "c", "d"]}`, map[string]string{"/attributes/regions": "maximum 3 items required, but found 4 items"}},
+		{"Additional property", `{"crm_id": "CRM-1", "owned": "me"}`, map[string]string{"/attributes": "additionalProperties 'owned' not allowed"}},
+		{"Nested", `{"crm_id": "CRM-1", "contact": {}}`, map[string]string{"/attributes/contact": "missing properties: 'email/work'"}},
+		{"Format", `{"crm_id": "CRM-1", "website": "example"}`, map[string]string{"/attributes/website": "'example' is not valid 'uri'"}},
+		{"Reference", `{"crm_id": "CRM-1", "owner": {"x-team": 1}}`, map[string]string{"/attributes/owner/x-team": "expected string, but got number"}},
+		{"One of", `{"crm_id": "CRM-1", "rating": "D"}`, map[string]string{"/attributes/rating": "does not match pattern '^[A-C]$'"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.document), &value); err != nil {
+				t.Fatal(err)
+			}
+			v := validator.New()
+			s.Validate(v, "/attributes", value)
+			if len(v.Errors) != len(tt.want) {
+				t.Fatalf("want errors %v; got %v", tt.want, v.Errors)
+			}
+			for field, message := range tt.want {
+				if v.Errors[field] != message {
+					t.Errorf("%s: want %q; got %q", field, message, v.Errors[field])
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{"Boolean", `true`, ""},
+		{"Annotations", `{"title": "Attributes", "description": "Custom fields", "type": ["object", "null"]}`, ""},
+		{"Unresolved reference", `{"properties": {"a": {"$ref": "#/$defs/a"}}}`, "#/$defs/a"},
+		{"Unknown type", `{"type": "date"}`, "/type"},
+		{"Invalid pattern", `{"pattern": "("}`, "/pattern"},
+		{"Invalid count", `{"maxLength": -1}`, "/maxLength"},
+		{"Not a schema", `{"items": 1}`, "/items"},
+		{"Not JSON", `{`, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.schema))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("want no error; got %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("want error containing %q; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestFalseSchema(t *testing.T) {
+	s, err := Parse([]byte(`{"properties": {"legacy": false}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := validator.New()
+	s.Validate(v, "", map[string]interface{}{"legacy": "x", "other": "y"})
+	if len(v.Errors) != 1 || v.Errors["/legacy"] != "not allowed" {
+		t.Errorf("want only /legacy to be rejected; got %v", v.Errors)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"attributes.json": `{"properties": {"owner": {"$ref": "owner.json"}}}`,
+		"owner.json":      `{"type": "string", "maxLength": 3}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := Load(filepath.Join(dir, "attributes.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := validator.New()
+	s.Validate(v, "", map[string]interface{}{"owner": "sales"})
+	if len(v.Errors) != 1 || v.Errors["/owner"] == "" {
+		t.Errorf("want /owner rejected by the referenced schema; got %v", v.Errors)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("want an error for a missing file")
+	}
+}
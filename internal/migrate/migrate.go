@@ -0,0 +1,327 @@
+// Package migrate applies the SQL migrations of the database schema and keeps
+// track of the applied ones in the schema_migrations table.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockID is the key of the Postgres advisory lock held while migrating, so that
+// instances started concurrently do not apply the same migrations.
+const lockID = 7_041_290_112
+
+var (
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrNoDownScript   = errors.New("migration has no down script")
+)
+
+// Migration is a change of the database schema, applied by the Up script and
+// reverted by the Down script.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus reports whether a migration has been applied and when.
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts migrations on a database.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New returns a Migrator for the migration scripts found in the root directory
+// of fsys, named NNNNNN_name.up.sql and NNNNNN_name.down.sql.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Load reads the migration scripts found in the root directory of fsys and
+// returns the migrations sorted by version. Every migration must have an up
+// script, files which are not .sql scripts are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		base, direction, ok := cutLast(base, ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: name must end with .up.sql or .down.sql", file)
+		}
+		number, name, ok := strings.Cut(base, "_")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("migration %s: name must have the form NNNNNN_name", file)
+		}
+		version, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || version < 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", file, number)
+		}
+		script, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %s: version %d is used by %s too", file, version, m.Name)
+		}
+		if direction == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Latest returns the version of the last migration, -1 if there is none.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return -1
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Status returns the status of every migration, sorted by version.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		s := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			s.AppliedAt = &appliedAt
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// Pending returns the migrations which have not been applied yet.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range m.Migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all the pending migrations and returns the number of migrations applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts the last applied migration and returns the number of migrations
+// reverted, zero if no migration is applied.
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	var n int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.Migrations[i].Version]; ok {
+				n = 1
+				return revert(ctx, conn, m.Migrations[i])
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Goto applies the pending migrations up to the given version and reverts the
+// applied migrations above it, so that the schema is at the given version.
+// A version of -1 reverts all the migrations. It returns the number of
+// migrations applied or reverted.
+func (m *Migrator) Goto(ctx context.Context, version int64) (int, error) {
+	if version != -1 && !m.has(version) {
+		return 0, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	var n int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		// Revert in reverse order the migrations above the target version first.
+		for i := len(m.Migrations) - 1; i >= 0 && m.Migrations[i].Version > version; i-- {
+			if _, ok := applied[m.Migrations[i].Version]; !ok {
+				continue
+			}
+			if err := revert(ctx, conn, m.Migrations[i]); err != nil {
+				return err
+			}
+			n++
+		}
+		for _, migration := range m.Migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (m *Migrator) has(version int64) bool {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// withLock runs fn on a connection holding the migration advisory lock. The
+// lock is held by the session, so it spans the transactions run by fn.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+	if err := createTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// applied returns the time each applied migration has been applied at, keyed by version.
+func (m *Migrator) applied(ctx context.Context, q queryer) (map[int64]time.Time, error) {
+	rows, err := q.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		if isUndefinedTable(err) {
+			return map[int64]time.Time{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+func createTable(ctx context.Context, conn *sql.Conn) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp with time zone NOT NULL DEFAULT NOW()
+		)`
+	_, err := conn.ExecContext(ctx, query)
+	return err
+}
+
+// apply runs the up script of the migration and records it in the same transaction.
+func apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	return inTx(ctx, conn, migration, migration.Up,
+		`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+}
+
+// revert runs the down script of the migration and removes it in the same transaction.
+func revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("%d_%s: %w", migration.Version, migration.Name, ErrNoDownScript)
+	}
+	return inTx(ctx, conn, migration, migration.Down,
+		`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, migration Migration, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("%d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isUndefinedTable reports whether the error is caused by a missing table,
+// which is the case of schema_migrations before the first migration.
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
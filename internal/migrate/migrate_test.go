@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"mborgnolo/companyservice/migrations"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// TestLoad tests the Load function.
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "Sorted by version",
+			fsys: fstest.MapFS{
+				"000002_add_index.up.sql":      {Data: []byte("CREATE INDEX")},
+				"000001_add_table.up.sql":      {Data: []byte("CREATE TABLE")},
+				"000001_add_table.down.sql":    {Data: []byte("DROP TABLE")},
+				"000002_add_index.down.sql":    {Data: []byte("DROP INDEX")},
+				"migrations.go":                {Data: []byte("package migrations")},
+				"000003_no_down_script.up.sql": {Data: []byte("SELECT 1")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "add_table", Up: "CREATE TABLE", Down: "DROP TABLE"},
+				{Version: 2, Name: "add_index", Up: "CREATE INDEX", Down: "DROP INDEX"},
+				{Version: 3, Name: "no_down_script", Up: "SELECT 1"},
+			},
+		},
+		{
+			name:    "Missing up script",
+			fsys:    fstest.MapFS{"000001_add_table.down.sql": {Data: []byte("DROP TABLE")}},
+			wantErr: true,
+		},
+		{
+			name:    "Missing direction",
+			fsys:    fstest.MapFS{"000001_add_table.sql": {Data: []byte("CREATE TABLE")}},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid version",
+			fsys:    fstest.MapFS{"first_add_table.up.sql": {Data: []byte("CREATE TABLE")}},
+			wantErr: true,
+		},
+		{
+			name: "Duplicate version",
+			fsys: fstest.MapFS{
+				"000001_add_table.up.sql": {Data: []byte("CREATE TABLE")},
+				"000001_add_index.up.sql": {Data: []byte("CREATE INDEX")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Load(tt.fsys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %t; got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %+v; got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestEmbeddedMigrations tests that the embedded migrations are consecutive
+// and can all be reverted.
+func TestEmbeddedMigrations(t *testing.T) {
+	got, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range got {
+		if m.Version != int64(i) {
+			t.Errorf("want version %d; got %d (%s)", i, m.Version, m.Name)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+	}
+}
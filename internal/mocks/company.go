@@ -3,44 +3,106 @@ package mocks
 import (
 	"github.com/google/uuid"
 	"mborgnolo/companyservice/internal/data"
+	"time"
 )
 
 func boolPtr(b bool) *bool {
 	return &b
 }
 
-// MockCompany is a mock company used for testing.
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+// mockCompany is a mock company used for testing.
 var mockCompany = &data.Company{
 	ID:          uuid.MustParse("dc152cf7-cc4b-4555-8d4c-1878e5b9262c"),
 	Name:        "Test Company",
 	Description: data.CompanyDescription{String: "Test Company Description", Valid: true},
+	Employees:   10,
 	Registered:  boolPtr(true),
-	Type:        "Corporate",
+	Type:        "Corporations",
+	Version:     1,
 }
 
-type CompanyModel struct{}
+// mockRevision is the first revision of the mock company.
+var mockRevision = &data.CompanyRevision{
+	CompanyID: mockCompany.ID,
+	Revision:  1,
+	Operation: data.OperationCreated,
+	Snapshot:  mockCompany,
+	Changes: []data.FieldChange{
+		{Field: "name", Old: "", New: mockCompany.Name},
+		{Field: "description", Old: nil, New: mockCompany.Description.String},
+		{Field: "employees", Old: 0, New: mockCompany.Employees},
+		{Field: "registered", Old: nil, New: true},
+		{Field: "type", Old: "", New: mockCompany.Type},
+	},
+	Actor:     "john@companyservice.io",
+	CreatedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+// mockAddress is the registered address of the mock company.
+var mockAddress = &data.Address{
+	ID:         uuid.MustParse("5a0c2f7e-1d3b-4c8a-9e6f-2b7d4a1c8e30"),
+	CompanyID:  mockCompany.ID,
+	Type:       data.AddressRegistered,
+	Lines:      []string{"1 Test Street"},
+	City:       "Amsterdam",
+	PostalCode: "1011 AB",
+	Country:    "NL",
+	CreatedAt:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	UpdatedAt:  time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+}
 
-func (t *CompanyModel) GetCompany(id uuid.UUID) (*data.Company, error) {
-	if id.String() == mockCompany.ID.String() {
-		return mockCompany, nil
-	}
-	return nil, data.ErrRecordNotFound
+// mockDeletedCompany is a mock company in the trash used for testing.
+var mockDeletedCompany = &data.Company{
+	ID:          uuid.MustParse("3b1f6a52-8f0e-4a57-9d0c-6b2f7c1e4a90"),
+	Name:        "Old Company",
+	Description: data.CompanyDescription{String: "Old Company Description", Valid: true},
+	Employees:   10,
+	Registered:  boolPtr(false),
+	Type:        "Cooperative",
+	Version:     2,
+	DeletedAt:   timePtr(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)),
 }
 
-func (t *CompanyModel) CreateCompany(company *data.Company) (uuid.UUID, error) {
-	return uuid.MustParse("dc152cf7-cc4b-4555-8d4c-1878e5b9262c"), nil
+// mockDeletedRevisions are the revisions of the mock deleted company.
+var mockDeletedRevisions = []*data.CompanyRevision{
+	{
+		CompanyID: mockDeletedCompany.ID,
+		Revision:  1,
+		Operation: data.OperationCreated,
+		Snapshot: &data.Company{ID: mockDeletedCompany.ID, Name: mockDeletedCompany.Name, Description: mockDeletedCompany.Description,
+			Employees: mockDeletedCompany.Employees, Registered: mockDeletedCompany.Registered, Type: mockDeletedCompany.Type, Version: 1},
+		Changes:   []data.FieldChange{{Field: "name", Old: "", New: mockDeletedCompany.Name}},
+		Actor:     "john@companyservice.io",
+		CreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	},
+	{
+		CompanyID: mockDeletedCompany.ID,
+		Revision:  2,
+		Operation: data.OperationDeleted,
+		Snapshot:  mockDeletedCompany,
+		Changes:   []data.FieldChange{{Field: "deleted_at", Old: nil, New: "2023-01-01T00:00:00Z"}},
+		Actor:     "john@companyservice.io",
+		CreatedAt: *mockDeletedCompany.DeletedAt,
+	},
 }
 
-func (t *CompanyModel) DeleteCompany(id uuid.UUID) error {
-	if id.String() == mockCompany.ID.String() {
-		return nil
-	}
-	return data.ErrRecordNotFound
+// CompanyModel is a mock company repository holding the mock companies. It is
+// backed by a data.MemoryModel, so it follows the CompanyRepository contract
+// checked by the conformance suite instead of returning canned answers.
+type CompanyModel struct {
+	*data.MemoryModel
 }
 
-func (t *CompanyModel) UpdateCompany(company *data.Company) error {
-	if company.ID.String() == mockCompany.ID.String() {
-		return nil
-	}
-	return data.ErrRecordNotFound
+// NewCompanyModel returns a CompanyModel holding the mock company and the mock
+// deleted company, along with their revisions, and the address of the mock company.
+func NewCompanyModel() *CompanyModel {
+	m := data.NewMemoryModel()
+	m.Seed(mockCompany, mockRevision)
+	m.SeedAddresses(mockAddress)
+	m.Seed(mockDeletedCompany, mockDeletedRevisions...)
+	return &CompanyModel{MemoryModel: m}
 }
@@ -0,0 +1,13 @@
+package mocks_test
+
+import (
+	"mborgnolo/companyservice/internal/conformance"
+	"mborgnolo/companyservice/internal/mocks"
+	"testing"
+)
+
+func TestCompanyModelConformance(t *testing.T) {
+	conformance.RunCompanyRepositoryTests(t, func(t *testing.T) conformance.CompanyRepository {
+		return mocks.NewCompanyModel()
+	})
+}
@@ -0,0 +1,45 @@
+package mocks
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"mborgnolo/companyservice/internal/data"
+	"time"
+)
+
+// OutboxModel is a mock outbox holding a single pending event. It records the
+// calls made by the relay.
+type OutboxModel struct {
+	Sent   []int64
+	Failed []int64
+	// Locked simulates another instance holding the lock of the relay.
+	Locked bool
+}
+
+func (t *OutboxModel) LockRelay(ctx context.Context) (func(), bool, error) {
+	return func() {}, !t.Locked, nil
+}
+
+func (t *OutboxModel) GetPendingEvents(ctx context.Context, limit int) ([]*data.OutboxEvent, error) {
+	event := &data.OutboxEvent{
+		ID:        1,
+		CompanyID: uuid.MustParse("dc152cf7-cc4b-4555-8d4c-1878e5b9262c"),
+		Type:      data.EventType(data.CompanyCreated).String(),
+		Payload:   []byte(`{"ID":"dc152cf7-cc4b-4555-8d4c-1878e5b9262c","Type":0,"TimeStamp":"2023-01-01T00:00:00Z"}`),
+	}
+	return []*data.OutboxEvent{event}, nil
+}
+
+func (t *OutboxModel) MarkEventSent(ctx context.Context, id int64) error {
+	t.Sent = append(t.Sent, id)
+	return nil
+}
+
+func (t *OutboxModel) DeleteSentEvents(ctx context.Context, sentBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (t *OutboxModel) MarkEventFailed(ctx context.Context, id int64, nextAttempt time.Time, cause error, maxAttempts int) (bool, error) {
+	t.Failed = append(t.Failed, id)
+	return len(t.Failed) >= maxAttempts, nil
+}
@@ -0,0 +1,25 @@
+package mocks
+
+import (
+	"context"
+	"mborgnolo/companyservice/pkg/cloudevents"
+)
+
+// Publisher is a mock publisher recording the events it publishes, or failing with
+// Err if it is set.
+type Publisher struct {
+	Events []*cloudevents.Event
+	Err    error
+}
+
+func (p *Publisher) Publish(ctx context.Context, event *cloudevents.Event) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	p.Events = append(p.Events, event)
+	return nil
+}
+
+func (p *Publisher) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotationLayout is the layout of the timestamp suffixed to the rotated files, which
+// sorts them by age.
+const rotationLayout = "20060102T150405.000000000"
+
+// File is a Publisher appending the events to a file as newline delimited JSON in the
+// format of the CloudEvents specification. The file is rotated when it would grow
+// beyond its maximum size: it is renamed with the UTC time as suffix, such as
+// events.ndjson.20230101T120000.000000000, and only the most recent rotated files
+// are kept.
+type File struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// NewFile returns a Publisher appending the events to the file at path, rotated when
+// it reaches maxSize bytes and keeping backups rotated files.
+func NewFile(path string, maxSize int64, backups int) (*File, error) {
+	if maxSize <= 0 {
+		return nil, errors.New("maximum size of the events file must be positive")
+	}
+	p := &File{path: path, maxSize: maxSize, backups: backups}
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// open opens the file for appending.
+func (p *File) open() error {
+	file, err := os.OpenFile(p.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	p.file, p.size = file, info.Size()
+	return nil
+}
+
+// Publish appends the event on its own line, rotating the file first if the line
+// would make it grow beyond its maximum size.
+func (p *File) Publish(ctx context.Context, event *cloudevents.Event) error {
+	line, err := ndjsonLine(event)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return errors.New("events file is closed")
+	}
+	if p.size > 0 && p.size+int64(len(line)) > p.maxSize {
+		if err := p.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := p.file.Write(line)
+	p.size += int64(n)
+	return err
+}
+
+// rotate renames the file, opens a new one and removes the rotated files beyond the
+// number of backups. The caller must hold the lock.
+func (p *File) rotate() error {
+	if err := p.file.Close(); err != nil {
+		return err
+	}
+	p.file = nil
+	// The file is reopened even if it could not be renamed, so that the events are
+	// still appended to it.
+	renameErr := os.Rename(p.path, p.path+"."+time.Now().UTC().Format(rotationLayout))
+	if err := p.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	matches, err := filepath.Glob(p.path + ".*")
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, match := range matches {
+		if _, err := time.Parse(rotationLayout, match[len(p.path)+1:]); err == nil {
+			rotated = append(rotated, match)
+		}
+	}
+	sort.Strings(rotated)
+	for len(rotated) > p.backups {
+		if err := os.Remove(rotated[0]); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// Close closes the file.
+func (p *File) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
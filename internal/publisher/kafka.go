@@ -0,0 +1,57 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"strings"
+	"time"
+)
+
+// produceTimeout is the maximum time Kafka is waited for to acknowledge an event.
+const produceTimeout = 10 * time.Second
+
+// Kafka is a Publisher producing the events to a Kafka topic, in a CloudEvents
+// content mode.
+type Kafka struct {
+	client *kgo.Client
+	mode   cloudevents.Mode
+}
+
+// NewKafka returns a Publisher producing the events to the topic of the comma
+// separated brokers. The brokers are only connected to when the first event is
+// published.
+func NewKafka(brokers, topic string, mode cloudevents.Mode) (*Kafka, error) {
+	if brokers == "" || topic == "" {
+		return nil, errors.New("kafka brokers and topic must be provided")
+	}
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(strings.Split(brokers, ",")...),
+		kgo.DefaultProduceTopic(topic),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kafka client: %v", err)
+	}
+	return &Kafka{client: client, mode: mode}, nil
+}
+
+// Publish synchronously produces the event, keyed by its subject, the company ID, so
+// that the events of a company land in the same partition.
+func (p *Kafka) Publish(ctx context.Context, event *cloudevents.Event) error {
+	record, err := event.Record(p.mode)
+	if err != nil {
+		return err
+	}
+	record.Key = []byte(event.Subject)
+	ctx, cancel := context.WithTimeout(ctx, produceTimeout)
+	defer cancel()
+	return p.client.ProduceSync(ctx, record).FirstErr()
+}
+
+// Close flushes and closes the Kafka client.
+func (p *Kafka) Close() error {
+	p.client.Close()
+	return nil
+}
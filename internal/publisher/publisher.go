@@ -0,0 +1,102 @@
+// Package publisher delivers the company events relayed from the outbox to their
+// sinks: Kafka, a rotating NDJSON file, a writer such as the standard output, or
+// nowhere. Several sinks are combined with FanOut.
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"sync"
+)
+
+// Publisher publishes the events. An event is delivered at least once: Publish must
+// only return nil once the sink has accepted the event, and is called again for the
+// same event when it fails.
+type Publisher interface {
+	Publish(ctx context.Context, event *cloudevents.Event) error
+	Close() error
+}
+
+// Nop is a Publisher discarding the events, for a service whose events are not
+// consumed.
+type Nop struct{}
+
+// Publish discards the event.
+func (Nop) Publish(ctx context.Context, event *cloudevents.Event) error {
+	return nil
+}
+
+// Close does nothing.
+func (Nop) Close() error {
+	return nil
+}
+
+// Writer is a Publisher writing the events to an io.Writer, such as the standard
+// output, as newline delimited JSON in the format of the CloudEvents specification.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter returns a Publisher writing the events to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Publish writes the event on its own line.
+func (p *Writer) Publish(ctx context.Context, event *cloudevents.Event) error {
+	line, err := ndjsonLine(event)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(line)
+	return err
+}
+
+// Close does nothing: the writer is owned by the caller.
+func (p *Writer) Close() error {
+	return nil
+}
+
+// ndjsonLine returns the JSON encoding of the event followed by a newline.
+func ndjsonLine(event *cloudevents.Event) ([]byte, error) {
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// FanOut is a Publisher publishing the events to several sinks, in order. When a
+// sink fails, Publish stops and returns its error: since the event is published
+// again, the sinks before it receive it more than once.
+type FanOut []Publisher
+
+// Publish publishes the event to every sink.
+func (p FanOut) Publish(ctx context.Context, event *cloudevents.Event) error {
+	for i, publisher := range p {
+		if err := publisher.Publish(ctx, event); err != nil {
+			return fmt.Errorf("sink %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every sink, and returns the first error.
+func (p FanOut) Close() error {
+	var first error
+	for _, publisher := range p {
+		if err := publisher.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,139 @@
+package publisher
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testEvent(id int) *cloudevents.Event {
+	return &cloudevents.Event{
+		ID:              strconv.Itoa(id),
+		Source:          "/companyservice",
+		SpecVersion:     cloudevents.SpecVersion,
+		Type:            cloudevents.TypeCompanyCreated,
+		Subject:         "f1203d76-0491-47fe-9640-0aeda76ad3f6",
+		Time:            time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		DataContentType: "application/json",
+		Data:            []byte(`{"ID":"f1203d76-0491-47fe-9640-0aeda76ad3f6","Type":0}`),
+	}
+}
+
+// readEvents returns the events of an NDJSON file.
+func readEvents(t *testing.T, path string) []*cloudevents.Event {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*cloudevents.Event
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		event := &cloudevents.Event{}
+		if err := event.UnmarshalJSON(scanner.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewWriter(&buf)
+	for i := 1; i <= 2; i++ {
+		if err := p.Publish(context.Background(), testEvent(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := `{"id":"1","source":"/companyservice","specversion":"1.0","type":"io.companyservice.company.created",` +
+		`"subject":"f1203d76-0491-47fe-9640-0aeda76ad3f6","time":"2023-01-01T00:00:00Z","datacontenttype":"application/json",` +
+		`"data":{"ID":"f1203d76-0491-47fe-9640-0aeda76ad3f6","Type":0}}` + "\n"
+	if lines := bytes.SplitAfter(buf.Bytes(), []byte("\n")); len(lines) != 3 || string(lines[0]) != want {
+		t.Errorf("want 2 lines starting with %s; got %s", want, buf.Bytes())
+	}
+	invalid := testEvent(3)
+	invalid.Type = ""
+	if err := p.Publish(context.Background(), invalid); err == nil {
+		t.Error("want error for an invalid event; got nil")
+	}
+}
+
+func TestFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	line, err := ndjsonLine(testEvent(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each file holds two events.
+	p, err := NewFile(path, int64(2*len(line)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 7; i++ {
+		if err := p.Publish(context.Background(), testEvent(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if events := readEvents(t, path); len(events) != 1 || events[0].ID != "7" {
+		t.Errorf("want the last event in the current file; got %+v", events)
+	}
+	rotated, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 2 {
+		t.Fatalf("want 2 rotated files kept; got %v", rotated)
+	}
+	if events := readEvents(t, rotated[0]); len(events) != 2 || events[0].ID != "3" {
+		t.Errorf("want events 3 and 4 in the oldest file kept; got %+v", events)
+	}
+	if err := p.Publish(context.Background(), testEvent(8)); err == nil {
+		t.Error("want error after close; got nil")
+	}
+
+	// The file is appended to when it is opened again.
+	p, err = NewFile(path, int64(2*len(line)), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.Publish(context.Background(), testEvent(8)); err != nil {
+		t.Fatal(err)
+	}
+	if events := readEvents(t, path); len(events) != 2 || events[1].ID != "8" {
+		t.Errorf("want the event appended; got %+v", events)
+	}
+}
+
+type failingPublisher struct{ Nop }
+
+func (failingPublisher) Publish(ctx context.Context, event *cloudevents.Event) error {
+	return errors.New("unavailable")
+}
+
+func TestFanOut(t *testing.T) {
+	var first, last bytes.Buffer
+	p := FanOut{NewWriter(&first), NewWriter(&last)}
+	if err := p.Publish(context.Background(), testEvent(1)); err != nil {
+		t.Fatal(err)
+	}
+	if first.Len() == 0 || first.String() != last.String() {
+		t.Errorf("want the event written by every sink; got %q and %q", first.String(), last.String())
+	}
+	p = FanOut{Nop{}, failingPublisher{}, NewWriter(&last)}
+	last.Reset()
+	if err := p.Publish(context.Background(), testEvent(2)); err == nil || last.Len() != 0 {
+		t.Errorf("want the failure of the second sink to stop the publication; got %v, %q", err, last.String())
+	}
+}
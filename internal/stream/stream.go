@@ -0,0 +1,148 @@
+// Package stream fans the company events out to the live subscribers of the service,
+// such as the clients of the Server-Sent Events stream. The most recent events are
+// kept in a bounded replay buffer, so that a subscriber which reconnects resumes after
+// the last event it received.
+package stream
+
+import (
+	"context"
+	"errors"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"sync"
+)
+
+// ErrClosed is returned by Subscribe once the broker has been closed.
+var ErrClosed = errors.New("event stream closed")
+
+// subscriberQueue is the number of events queued for a subscriber. A subscriber
+// lagging further behind is dropped, and resumes from the replay buffer when it
+// subscribes again.
+const subscriberQueue = 256
+
+// Broker is a Publisher delivering the events to its subscribers. It is safe for
+// concurrent use. The events are shared by the subscribers, which must not modify
+// them.
+type Broker struct {
+	mu          sync.Mutex
+	size        int
+	events      []*cloudevents.Event
+	ids         map[string]bool
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+// NewBroker returns a Broker replaying up to size events.
+func NewBroker(size int) *Broker {
+	return &Broker{size: size, ids: make(map[string]bool), subscribers: make(map[*Subscription]bool)}
+}
+
+// Subscription receives the events published after it was created, until it is
+// closed. Every event is delivered, so that the subscriber knows its position in the
+// stream even when it is only interested in some of them.
+type Subscription struct {
+	// Replay holds the buffered events which followed the last event received by the
+	// subscriber, published before the subscription.
+	Replay []*cloudevents.Event
+	// Missed reports that the last event received by the subscriber is no longer
+	// buffered, so that events may have been missed.
+	Missed bool
+	// LatestEventID is the ID of the most recent event published before the
+	// subscription, empty if none is buffered.
+	LatestEventID string
+
+	broker  *Broker
+	events  chan *cloudevents.Event
+	dropped bool
+}
+
+// Subscribe returns a subscription to the events. If lastEventID is not empty, the
+// subscription replays the buffered events which followed it.
+func (b *Broker) Subscribe(lastEventID string) (*Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrClosed
+	}
+	s := &Subscription{broker: b, events: make(chan *cloudevents.Event, subscriberQueue)}
+	if len(b.events) > 0 {
+		s.LatestEventID = b.events[len(b.events)-1].ID
+	}
+	if lastEventID != "" {
+		s.Missed = !b.ids[lastEventID]
+		replay := false
+		for _, event := range b.events {
+			if replay {
+				s.Replay = append(s.Replay, event)
+			}
+			replay = replay || event.ID == lastEventID
+		}
+	}
+	b.subscribers[s] = true
+	return s, nil
+}
+
+// Events returns the channel of the events, closed when the subscription ends:
+// when it is closed, when it lags behind, or when the broker is closed.
+func (s *Subscription) Events() <-chan *cloudevents.Event {
+	return s.events
+}
+
+// Dropped reports whether the subscription ended because it lagged behind.
+func (s *Subscription) Dropped() bool {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.dropped
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.unsubscribe(s)
+}
+
+// unsubscribe removes the subscription and closes its channel. The caller must hold
+// the lock.
+func (b *Broker) unsubscribe(s *Subscription) {
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Publish buffers the event and delivers it to the subscribers. An event
+// published again is ignored, and so are the events published once the broker has
+// been closed, so that the other sinks still receive them on shutdown.
+func (b *Broker) Publish(ctx context.Context, event *cloudevents.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || b.ids[event.ID] {
+		return nil
+	}
+	b.events = append(b.events, event)
+	b.ids[event.ID] = true
+	for len(b.events) > b.size {
+		delete(b.ids, b.events[0].ID)
+		b.events = b.events[1:]
+	}
+	for s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			s.dropped = true
+			b.unsubscribe(s)
+		}
+	}
+	return nil
+}
+
+// Close ends every subscription, and rejects the new ones.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for s := range b.subscribers {
+		b.unsubscribe(s)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"mborgnolo/companyservice/pkg/cloudevents"
+	"strconv"
+	"testing"
+)
+
+func testEvent(id int) *cloudevents.Event {
+	return &cloudevents.Event{ID: strconv.Itoa(id), Type: cloudevents.TypeCompanyCreated, Subject: "f1203d76-0491-47fe-9640-0aeda76ad3f6"}
+}
+
+// eventIDs returns the IDs of the events.
+func eventIDs(events []*cloudevents.Event) []string {
+	ids := []string{}
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(3)
+	for id := 1; id <= 4; id++ {
+		b.Publish(context.Background(), testEvent(id))
+	}
+	// An event published again is not buffered twice.
+	b.Publish(context.Background(), testEvent(3))
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		wantReplay  string
+		wantMissed  bool
+	}{
+		{"New subscriber", "", "[]", false},
+		{"Buffered", "2", "[3 4]", false},
+		{"Up to date", "4", "[]", false},
+		{"Evicted", "1", "[]", true},
+		{"Unknown", "5", "[]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := b.Subscribe(tt.lastEventID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if got := eventIDs(s.Replay); fmt.Sprint(got) != tt.wantReplay {
+				t.Errorf("want replay %s; got %v", tt.wantReplay, got)
+			}
+			if s.Missed != tt.wantMissed {
+				t.Errorf("want missed %t; got %t", tt.wantMissed, s.Missed)
+			}
+			if s.LatestEventID != "4" {
+				t.Errorf("want latest event 4; got %q", s.LatestEventID)
+			}
+		})
+	}
+}
+
+func TestBrokerSubscribers(t *testing.T) {
+	b := NewBroker(10)
+	live, err := b.Subscribe("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed, err := b.Subscribe("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	closed.Close()
+
+	b.Publish(context.Background(), testEvent(1))
+	if event := <-live.Events(); event.ID != "1" {
+		t.Errorf("want event 1; got %s", event.ID)
+	}
+	if _, ok := <-closed.Events(); ok {
+		t.Error("want no event after the subscription is closed")
+	}
+
+	// A subscriber lagging behind is dropped.
+	for id := 2; id <= subscriberQueue+2; id++ {
+		b.Publish(context.Background(), testEvent(id))
+	}
+	n := 0
+	for range live.Events() {
+		n++
+	}
+	if n != subscriberQueue || !live.Dropped() {
+		t.Errorf("want %d events queued and the subscriber dropped; got %d, dropped %t", subscriberQueue, n, live.Dropped())
+	}
+
+	// Closing the broker ends the subscriptions.
+	last, err := b.Subscribe("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-last.Events(); ok || last.Dropped() {
+		t.Error("want the subscription ended, without being dropped")
+	}
+	if _, err := b.Subscribe(""); err != ErrClosed {
+		t.Errorf("want %v; got %v", ErrClosed, err)
+	}
+	if err := b.Publish(context.Background(), testEvent(100)); err != nil {
+		t.Errorf("want the events ignored once closed; got %v", err)
+	}
+}
@@ -41,3 +41,15 @@ func (v *Validator) In(value string, list ...string) bool {
 	}
 	return false
 }
+
+// Unique reports whether all the values are distinct.
+func (v *Validator) Unique(values []string) bool {
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			return false
+		}
+		seen[value] = true
+	}
+	return true
+}
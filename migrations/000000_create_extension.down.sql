@@ -0,0 +1 @@
+DROP EXTENSION IF EXISTS "uuid-ossp";
\ No newline at end of file
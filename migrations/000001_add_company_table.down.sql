@@ -0,0 +1 @@
+DROP TABLE IF EXISTS company;
\ No newline at end of file
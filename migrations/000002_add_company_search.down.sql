@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS company_search_idx;
+
+ALTER TABLE company DROP COLUMN IF EXISTS search;
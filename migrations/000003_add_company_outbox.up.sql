@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS company_outbox (
+id bigserial PRIMARY KEY,
+company_id uuid NOT NULL,
+event_type text NOT NULL,
+payload jsonb NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+attempts integer NOT NULL DEFAULT 0,
+next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+last_error text NULL,
+sent_at timestamp with time zone NULL
+);
+
+CREATE INDEX IF NOT EXISTS company_outbox_pending_idx ON company_outbox (id) WHERE sent_at IS NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS company_name_active_idx;
+ALTER TABLE company ADD CONSTRAINT company_name_key UNIQUE (name);
+
+DROP INDEX IF EXISTS company_deleted_at_idx;
+
+ALTER TABLE company DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,6 @@
+ALTER TABLE company ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone NULL;
+
+CREATE INDEX IF NOT EXISTS company_deleted_at_idx ON company (deleted_at) WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE company DROP CONSTRAINT IF EXISTS company_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS company_name_active_idx ON company (name) WHERE deleted_at IS NULL;
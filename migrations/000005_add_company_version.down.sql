@@ -0,0 +1 @@
+ALTER TABLE company DROP COLUMN IF EXISTS version;
@@ -0,0 +1 @@
+ALTER TABLE company ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1;
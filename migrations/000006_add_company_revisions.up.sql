@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS company_revisions (
+id bigserial PRIMARY KEY,
+company_id uuid NOT NULL,
+revision integer NOT NULL,
+operation text NOT NULL,
+snapshot jsonb NOT NULL,
+changes jsonb NOT NULL DEFAULT '[]',
+actor text NOT NULL DEFAULT '',
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+UNIQUE (company_id, revision)
+);
+
+-- Record the current state of the existing companies as their first revision.
+INSERT INTO company_revisions (company_id, revision, operation, snapshot)
+SELECT id, version, 'created', jsonb_build_object(
+    'id', id, 'name', name, 'description', description, 'employees', employees,
+    'registered', registered, 'type', type, 'version', version, 'deleted_at', deleted_at)
+FROM company
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS company_history (
+id bigserial PRIMARY KEY,
+company_id uuid NOT NULL,
+name varchar(15) NOT NULL,
+description varchar(3000) NULL,
+employees integer NOT NULL,
+registered boolean NOT NULL,
+type text NOT NULL,
+version integer NOT NULL,
+valid_from timestamp with time zone NOT NULL,
+valid_to timestamp with time zone NULL
+);
+
+CREATE INDEX IF NOT EXISTS company_history_company_id_idx ON company_history (company_id, valid_from);
+
+-- Open the period of validity of the current state of the existing companies.
+INSERT INTO company_history (company_id, name, description, employees, registered, type, version, valid_from)
+SELECT c.id, c.name, c.description, c.employees, c.registered, c.type, c.version,
+    COALESCE((SELECT max(r.created_at) FROM company_revisions r WHERE r.company_id = c.id), NOW())
+FROM company c
+WHERE c.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM company_history h WHERE h.company_id = c.id);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS company_notify ON company;
+DROP FUNCTION IF EXISTS notify_company_change();
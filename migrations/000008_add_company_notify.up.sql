@@ -0,0 +1,33 @@
+-- Notify the listeners of the company_changes channel of every committed change of
+-- a company, with its id and the operation: created, updated, deleted (moved to the
+-- trash), restored (from the trash) or purged.
+CREATE OR REPLACE FUNCTION notify_company_change() RETURNS trigger AS $$
+DECLARE
+    operation text;
+    company_id uuid;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        operation := 'created';
+        company_id := NEW.id;
+    ELSIF TG_OP = 'DELETE' THEN
+        operation := 'purged';
+        company_id := OLD.id;
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        operation := 'deleted';
+        company_id := NEW.id;
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        operation := 'restored';
+        company_id := NEW.id;
+    ELSE
+        operation := 'updated';
+        company_id := NEW.id;
+    END IF;
+    PERFORM pg_notify('company_changes', json_build_object('id', company_id, 'operation', operation)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS company_notify ON company;
+CREATE TRIGGER company_notify
+AFTER INSERT OR UPDATE OR DELETE ON company
+FOR EACH ROW EXECUTE FUNCTION notify_company_change();
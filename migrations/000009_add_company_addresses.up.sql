@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS company_addresses (
+id uuid PRIMARY KEY,
+company_id uuid NOT NULL,
+type text NOT NULL,
+lines text[] NOT NULL,
+city varchar(100) NOT NULL,
+postal_code varchar(20) NOT NULL DEFAULT '',
+country char(2) NOT NULL,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS company_addresses_company_id_idx ON company_addresses (company_id);
+
+-- A company has at most one registered address.
+CREATE UNIQUE INDEX IF NOT EXISTS company_addresses_registered_idx ON company_addresses (company_id) WHERE type = 'registered';
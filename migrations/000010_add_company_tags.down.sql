@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS company_tags_notify ON company_tags;
+DROP FUNCTION IF EXISTS notify_company_tags_change();
+DROP TABLE IF EXISTS company_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS tags (
+id bigserial PRIMARY KEY,
+name varchar(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS company_tags (
+company_id uuid NOT NULL,
+tag_id bigint NOT NULL REFERENCES tags ON DELETE CASCADE,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (company_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS company_tags_tag_id_idx ON company_tags (tag_id);
+
+-- The tags are part of the company representation, so a change of the tags of a
+-- company is notified like an update of the company.
+CREATE OR REPLACE FUNCTION notify_company_tags_change() RETURNS trigger AS $$
+DECLARE
+    company_id uuid;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        company_id := OLD.company_id;
+    ELSE
+        company_id := NEW.company_id;
+    END IF;
+    PERFORM pg_notify('company_changes', json_build_object('id', company_id, 'operation', 'updated')::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS company_tags_notify ON company_tags;
+CREATE TRIGGER company_tags_notify
+AFTER INSERT OR DELETE ON company_tags
+FOR EACH ROW EXECUTE FUNCTION notify_company_tags_change();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS company_parent_id_idx;
+ALTER TABLE company DROP CONSTRAINT IF EXISTS company_parent_id_check;
+ALTER TABLE company DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,5 @@
+-- The parent of a company is the company owning it. Cycles are prevented by the
+-- service, which serialises the changes of the hierarchy.
+ALTER TABLE company ADD COLUMN IF NOT EXISTS parent_id uuid NULL;
+ALTER TABLE company ADD CONSTRAINT company_parent_id_check CHECK (parent_id <> id);
+CREATE INDEX IF NOT EXISTS company_parent_id_idx ON company (parent_id);
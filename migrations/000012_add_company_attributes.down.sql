@@ -0,0 +1,4 @@
+ALTER TABLE company_history DROP COLUMN IF EXISTS attributes;
+DROP INDEX IF EXISTS company_attributes_idx;
+ALTER TABLE company DROP CONSTRAINT IF EXISTS company_attributes_check;
+ALTER TABLE company DROP COLUMN IF EXISTS attributes;
@@ -0,0 +1,7 @@
+-- The attributes of a company are the free-form fields added by each deployment, as
+-- a JSON object validated by the service against the configured JSON Schema. The GIN
+-- index supports the containment lookups of the attribute values.
+ALTER TABLE company ADD COLUMN IF NOT EXISTS attributes jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE company ADD CONSTRAINT company_attributes_check CHECK (jsonb_typeof(attributes) = 'object');
+CREATE INDEX IF NOT EXISTS company_attributes_idx ON company USING GIN (attributes jsonb_path_ops);
+ALTER TABLE company_history ADD COLUMN IF NOT EXISTS attributes jsonb NOT NULL DEFAULT '{}';
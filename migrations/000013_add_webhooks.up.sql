@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+id uuid PRIMARY KEY,
+url text NOT NULL,
+event_types text[] NOT NULL DEFAULT '{}',
+secret text NOT NULL,
+enabled boolean NOT NULL DEFAULT true,
+consecutive_failures integer NOT NULL DEFAULT 0,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+id bigserial PRIMARY KEY,
+webhook_id uuid NOT NULL REFERENCES webhooks ON DELETE CASCADE,
+event_id bigint NOT NULL,
+event_type text NOT NULL,
+company_id uuid NOT NULL,
+payload jsonb NOT NULL,
+attempts integer NOT NULL DEFAULT 0,
+next_attempt_at timestamp with time zone NOT NULL DEFAULT NOW(),
+response_status integer NULL,
+last_error text NULL,
+delivered_at timestamp with time zone NULL,
+created_at timestamp with time zone NOT NULL DEFAULT NOW(),
+-- An event relayed more than once is delivered once to each webhook.
+UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE delivered_at IS NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE company_history DROP CONSTRAINT IF EXISTS company_history_period_excl;
+ALTER TABLE company_history DROP CONSTRAINT IF EXISTS company_history_period_check;
@@ -0,0 +1,17 @@
+-- The periods of validity of the states of a company follow each other without
+-- overlapping. Periods recorded before this migration may overlap when concurrent
+-- transactions changed the same company: each period is made to start after the end
+-- of the previous ones, and a period left ending before it starts is made empty.
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+UPDATE company_history h SET valid_from = p.previous_valid_to
+FROM (
+    SELECT id, max(valid_to) OVER (PARTITION BY company_id ORDER BY id ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS previous_valid_to
+    FROM company_history
+) p
+WHERE h.id = p.id AND p.previous_valid_to > h.valid_from;
+UPDATE company_history SET valid_to = valid_from WHERE valid_to < valid_from;
+
+ALTER TABLE company_history ADD CONSTRAINT company_history_period_check CHECK (valid_to IS NULL OR valid_to >= valid_from);
+ALTER TABLE company_history ADD CONSTRAINT company_history_period_excl
+    EXCLUDE USING gist (company_id WITH =, tstzrange(valid_from, valid_to) WITH &&);
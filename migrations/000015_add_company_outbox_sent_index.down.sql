@@ -0,0 +1 @@
+DROP INDEX IF EXISTS company_outbox_sent_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS company_outbox_sent_idx ON company_outbox (sent_at, id) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS company_outbox_pending_idx;
+CREATE INDEX IF NOT EXISTS company_outbox_pending_idx ON company_outbox (id) WHERE sent_at IS NULL;
+
+ALTER TABLE company_outbox DROP COLUMN IF EXISTS dead_at;
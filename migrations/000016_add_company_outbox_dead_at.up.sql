@@ -0,0 +1,4 @@
+ALTER TABLE company_outbox ADD COLUMN IF NOT EXISTS dead_at timestamp with time zone NULL;
+
+DROP INDEX IF EXISTS company_outbox_pending_idx;
+CREATE INDEX IF NOT EXISTS company_outbox_pending_idx ON company_outbox (id) WHERE sent_at IS NULL AND dead_at IS NULL;
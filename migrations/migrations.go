@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migration scripts of the database schema.
+package migrations
+
+import "embed"
+
+// FS holds the migration scripts. Every migration is made of a
+// NNNNNN_name.up.sql script and the NNNNNN_name.down.sql script reverting it.
+//
+//go:embed *.sql
+var FS embed.FS
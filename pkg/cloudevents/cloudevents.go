@@ -0,0 +1,245 @@
+// Package cloudevents encodes and decodes the Kafka messages of the company events
+// as CloudEvents 1.0, following the Kafka protocol binding of the specification in
+// its structured and binary content modes. It is meant to be imported by the
+// consumers of the company events topic.
+package cloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"strings"
+	"time"
+)
+
+// SpecVersion is the version of the CloudEvents specification of the events.
+const SpecVersion = "1.0"
+
+// Event types of the company events.
+const (
+	TypeCompanyCreated  = "io.companyservice.company.created"
+	TypeCompanyUpdated  = "io.companyservice.company.updated"
+	TypeCompanyDeleted  = "io.companyservice.company.deleted"
+	TypeCompanyRestored = "io.companyservice.company.restored"
+)
+
+// Mode is the content mode of a Kafka message holding an event.
+type Mode string
+
+const (
+	// Structured messages hold the whole event, attributes and data, in their value,
+	// encoded as a JSON object.
+	Structured Mode = "structured"
+	// Binary messages hold the data of the event in their value and its attributes in
+	// their headers, prefixed by ce_.
+	Binary Mode = "binary"
+)
+
+// ParseMode returns the content mode of the given name.
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(name); mode {
+	case Structured, Binary:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid content mode %q: must be structured or binary", name)
+	}
+}
+
+// contentType is the content type of the structured messages.
+const contentType = "application/cloudevents+json; charset=UTF-8"
+
+var (
+	// ErrNotCloudEvent is returned when a message holds no event in any content mode.
+	ErrNotCloudEvent = errors.New("cloudevents: message is not a CloudEvent")
+	// ErrSpecVersion is returned when an event uses an unsupported version of the
+	// specification.
+	ErrSpecVersion = errors.New("cloudevents: unsupported specversion")
+)
+
+// Event is a CloudEvent. Data holds the encoded data of the event, whose media type
+// is DataContentType.
+type Event struct {
+	ID              string
+	Source          string
+	SpecVersion     string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	Data            []byte
+}
+
+// Validate checks that the event has the required attributes of the specification.
+func (e *Event) Validate() error {
+	if e.SpecVersion != SpecVersion {
+		return fmt.Errorf("%w %q", ErrSpecVersion, e.SpecVersion)
+	}
+	switch {
+	case e.ID == "":
+		return errors.New("cloudevents: missing id attribute")
+	case e.Source == "":
+		return errors.New("cloudevents: missing source attribute")
+	case e.Type == "":
+		return errors.New("cloudevents: missing type attribute")
+	}
+	return nil
+}
+
+// DecodeData decodes the JSON data of the event into v.
+func (e *Event) DecodeData(v interface{}) error {
+	if !isJSON(e.DataContentType) {
+		return fmt.Errorf("cloudevents: data of type %q is not JSON", e.DataContentType)
+	}
+	return json.Unmarshal(e.Data, v)
+}
+
+// isJSON reports whether the media type is JSON, which is assumed when it is missing.
+func isJSON(mediaType string) bool {
+	mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+	return mediaType == "" || mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// structuredEvent is the JSON encoding of an event in a structured message. JSON data
+// is embedded as is, other data is encoded in base64.
+type structuredEvent struct {
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// MarshalJSON encodes the event in the JSON format of the specification, used by the
+// structured messages.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	s := structuredEvent{ID: e.ID, Source: e.Source, SpecVersion: e.SpecVersion, Type: e.Type, Subject: e.Subject,
+		DataContentType: e.DataContentType}
+	if !e.Time.IsZero() {
+		s.Time = &e.Time
+	}
+	if isJSON(e.DataContentType) {
+		s.Data = e.Data
+	} else {
+		s.DataBase64 = e.Data
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON decodes an event encoded in the JSON format of the specification.
+// The attributes are not validated.
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var s structuredEvent
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*e = Event{
+		ID:              s.ID,
+		Source:          s.Source,
+		SpecVersion:     s.SpecVersion,
+		Type:            s.Type,
+		Subject:         s.Subject,
+		DataContentType: s.DataContentType,
+		Data:            s.DataBase64,
+	}
+	if s.Time != nil {
+		e.Time = *s.Time
+	}
+	if s.Data != nil {
+		e.Data = s.Data
+	}
+	return nil
+}
+
+// Record returns the Kafka record holding the event in the given content mode. The
+// key of the record is left to the caller.
+func (e *Event) Record(mode Mode) (*kgo.Record, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	switch mode {
+	case Structured:
+		value, err := e.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		return &kgo.Record{Value: value, Headers: []kgo.RecordHeader{{Key: "content-type", Value: []byte(contentType)}}}, nil
+	case Binary:
+		headers := []kgo.RecordHeader{
+			{Key: "ce_specversion", Value: []byte(e.SpecVersion)},
+			{Key: "ce_id", Value: []byte(e.ID)},
+			{Key: "ce_source", Value: []byte(e.Source)},
+			{Key: "ce_type", Value: []byte(e.Type)},
+		}
+		if e.Subject != "" {
+			headers = append(headers, kgo.RecordHeader{Key: "ce_subject", Value: []byte(e.Subject)})
+		}
+		if !e.Time.IsZero() {
+			headers = append(headers, kgo.RecordHeader{Key: "ce_time", Value: []byte(e.Time.Format(time.RFC3339Nano))})
+		}
+		if e.DataContentType != "" {
+			headers = append(headers, kgo.RecordHeader{Key: "content-type", Value: []byte(e.DataContentType)})
+		}
+		return &kgo.Record{Value: e.Data, Headers: headers}, nil
+	default:
+		return nil, fmt.Errorf("cloudevents: invalid content mode %q", mode)
+	}
+}
+
+// Decode returns the event held by a Kafka record in either content mode: binary if
+// it has a ce_specversion header, structured if its content type is
+// application/cloudevents+json. ErrNotCloudEvent is returned otherwise.
+func Decode(record *kgo.Record) (*Event, error) {
+	headers := make(map[string]string, len(record.Headers))
+	for _, header := range record.Headers {
+		headers[strings.ToLower(header.Key)] = string(header.Value)
+	}
+	var e *Event
+	var err error
+	switch {
+	case headers["ce_specversion"] != "":
+		e, err = decodeBinary(headers, record.Value)
+	case strings.HasPrefix(strings.ToLower(headers["content-type"]), "application/cloudevents+json"):
+		e, err = decodeStructured(record.Value)
+	default:
+		return nil, ErrNotCloudEvent
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = e.Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func decodeBinary(headers map[string]string, value []byte) (*Event, error) {
+	e := &Event{
+		ID:              headers["ce_id"],
+		Source:          headers["ce_source"],
+		SpecVersion:     headers["ce_specversion"],
+		Type:            headers["ce_type"],
+		Subject:         headers["ce_subject"],
+		DataContentType: headers["content-type"],
+		Data:            value,
+	}
+	if t, ok := headers["ce_time"]; ok {
+		var err error
+		if e.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+			return nil, fmt.Errorf("cloudevents: invalid time attribute: %w", err)
+		}
+	}
+	return e, nil
+}
+
+func decodeStructured(value []byte) (*Event, error) {
+	e := &Event{}
+	if err := e.UnmarshalJSON(value); err != nil {
+		return nil, fmt.Errorf("cloudevents: invalid structured event: %w", err)
+	}
+	return e, nil
+}
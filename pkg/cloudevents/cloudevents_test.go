@@ -0,0 +1,123 @@
+package cloudevents
+
+import (
+	"bytes"
+	"errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"testing"
+	"time"
+)
+
+func testEvent() *Event {
+	return &Event{
+		ID:              "42",
+		Source:          "/companyservice",
+		SpecVersion:     SpecVersion,
+		Type:            TypeCompanyUpdated,
+		Subject:         "f1203d76-0491-47fe-9640-0aeda76ad3f6",
+		Time:            time.Date(2023, 1, 1, 12, 30, 0, 500, time.UTC),
+		DataContentType: "application/json",
+		Data:            []byte(`{"ID":"f1203d76-0491-47fe-9640-0aeda76ad3f6","Type":1}`),
+	}
+}
+
+func header(record *kgo.Record, key string) string {
+	for _, h := range record.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, mode := range []Mode{Structured, Binary} {
+		t.Run(string(mode), func(t *testing.T) {
+			want := testEvent()
+			record, err := want.Record(mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Decode(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != want.ID || got.Source != want.Source || got.SpecVersion != want.SpecVersion || got.Type != want.Type ||
+				got.Subject != want.Subject || !got.Time.Equal(want.Time) || got.DataContentType != want.DataContentType {
+				t.Errorf("want %+v; got %+v", want, got)
+			}
+			var data struct{ Type int }
+			if err := got.DecodeData(&data); err != nil || data.Type != 1 {
+				t.Errorf("want the data; got %+v, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	record, err := testEvent().Record(Structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header(record, "content-type") != contentType {
+		t.Errorf("want content type %q; got %q", contentType, header(record, "content-type"))
+	}
+	if !bytes.Contains(record.Value, []byte(`"data":{"ID":`)) || !bytes.Contains(record.Value, []byte(`"type":"io.companyservice.company.updated"`)) {
+		t.Errorf("want the data embedded in the event; got %s", record.Value)
+	}
+
+	record, err = testEvent().Record(Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header(record, "ce_type") != TypeCompanyUpdated || header(record, "ce_time") != "2023-01-01T12:30:00.0000005Z" ||
+		header(record, "content-type") != "application/json" {
+		t.Errorf("want the attributes in the headers; got %+v", record.Headers)
+	}
+	if !bytes.Equal(record.Value, testEvent().Data) {
+		t.Errorf("want the data as value; got %s", record.Value)
+	}
+
+	binary := testEvent()
+	binary.DataContentType = "application/octet-stream"
+	record, err = binary.Record(Structured)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(record)
+	if err != nil || !bytes.Equal(got.Data, binary.Data) || !bytes.Contains(record.Value, []byte(`"data_base64":`)) {
+		t.Errorf("want the data encoded in base64; got %s, %v", record.Value, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	structured := []kgo.RecordHeader{{Key: "content-type", Value: []byte(contentType)}}
+	tests := []struct {
+		name    string
+		record  *kgo.Record
+		wantErr error
+	}{
+		{"Legacy payload", &kgo.Record{Value: []byte(`{"ID":"f1203d76-0491-47fe-9640-0aeda76ad3f6","Type":1}`)}, ErrNotCloudEvent},
+		{"Unsupported version", &kgo.Record{Headers: []kgo.RecordHeader{{Key: "ce_specversion", Value: []byte("0.3")}}}, ErrSpecVersion},
+		{"Missing id", &kgo.Record{Value: []byte(`{"specversion":"1.0","source":"/s","type":"t"}`), Headers: structured}, nil},
+		{"Invalid JSON", &kgo.Record{Value: []byte(`{`), Headers: structured}, nil},
+		{"Invalid time", &kgo.Record{Headers: []kgo.RecordHeader{{Key: "ce_specversion", Value: []byte("1.0")}, {Key: "ce_time", Value: []byte("now")}}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(tt.record)
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("want error %v; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if mode, err := ParseMode("binary"); err != nil || mode != Binary {
+		t.Errorf("want %q; got %q, %v", Binary, mode, err)
+	}
+	if _, err := ParseMode("batched"); err == nil {
+		t.Error("want an error for an unknown mode")
+	}
+}